@@ -5,148 +5,6080 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"go.etcd.io/bbolt"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/netutil"
 )
 
 ///////////////////////////////////////////////////////////////////////////////////////
 //                          part one · constants and globals                         //
 ///////////////////////////////////////////////////////////////////////////////////////
 
-// DB is the global database connection object.
+// MemoryPath is the database path that opens a temporary in-memory database.
+const MemoryPath = ":memory:"
+
+// Version is the current version of Gesedels.
+const Version = "0.0.0"
+
+// DefaultPath is the default database path.
+const DefaultPath = "./gesedels.db"
+
+// ExpandDepth is the maximum nesting depth of expanded value references.
+const ExpandDepth = 8
+
+// BatchSize is the number of pairs written per transaction by bulk operations.
+const BatchSize = 1000
+
+// CompactTxSize is the maximum number of bytes copied per transaction by compaction.
+const CompactTxSize = 1 << 20
+
+// BloomBits is the number of Bloom filter bits per expected pair key.
+const BloomBits = 10
+
+// BloomHashes is the number of bits set per pair key in a Bloom filter.
+const BloomHashes = 7
+
+// IndexPrefix is the number of leading value bytes indexed per pair by the value index.
+const IndexPrefix = 64
+
+// IndexName is the private user and name of the pair served as the index page.
+const IndexName = "__index__"
+
+// HookRetries is the number of times a failed webhook delivery is retried.
+const HookRetries = 3
+
+// HookBuffer is the maximum number of Events waiting for webhook delivery, beyond
+// which new Events are dropped.
+const HookBuffer = 256
+
+// HookTimeout is the maximum time for each webhook delivery attempt.
+const HookTimeout = 10 * time.Second
+
+// backupStamp is the UTC timestamp layout in snapshot file names.
+const backupStamp = "20060102T150405Z"
+
+// ErrPairExists is the error for writing a new pair over an existing one.
+var ErrPairExists = errors.New("pair already exists")
+
+// errDryRun is the error for rolling back a write transaction in DryRun mode.
+var errDryRun = errors.New("dry run")
+
+// ErrNotFound is the error for a subcommand or self-test on a pair that does not exist.
+// Database functions report missing pairs with a false boolean instead, never with
+// ErrNotFound.
+var ErrNotFound = errors.New("pair does not exist")
+
+// ErrLoop is the error for value references that loop or nest too deeply.
+var ErrLoop = errors.New("reference loop detected")
+
+// ErrMissingRef is the error for a value reference to a pair that does not exist.
+var ErrMissingRef = errors.New("reference does not exist")
+
+// ErrNoPath is the error for a JSON path that does not resolve in a document.
+var ErrNoPath = errors.New("path does not exist")
+
+// ErrNotGzip is the error for a gzip value that is not valid gzip data.
+var ErrNotGzip = errors.New("value is not valid gzip")
+
+// ErrNotInteger is the error for a numeric operation on a non-integer value.
+var ErrNotInteger = errors.New("value is not an integer")
+
+// ErrOverflow is the error for a numeric operation with a result out of int64 range.
+var ErrOverflow = errors.New("integer overflow")
+
+// ErrNotJSON is the error for a JSON operation on a value that is not valid JSON.
+var ErrNotJSON = errors.New("value is not valid json")
+
+// ErrNoBucket is the error for a write to a database without a root bucket when
+// StrictBucket is set.
+var ErrNoBucket = errors.New("bucket does not exist")
+
+// ErrQuota is the error for a write that would exceed a user's quota.
+var ErrQuota = errors.New("user quota exceeded")
+
+// ErrPrivate is the error for an import of a pair with a private user or name.
+var ErrPrivate = errors.New("pair is private")
+
+// ErrReadOnly is the error for a write to a database opened read-only.
+var ErrReadOnly = errors.New("database is read-only")
+
+// ErrTooLarge is the error for a value longer than MaxValue.
+var ErrTooLarge = errors.New("value is too large")
+
+// OpenAPI is the embedded OpenAPI document describing the server endpoints.
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// Addr is the global address the server listens on.
+var Addr string
+
+// APIVersion is the global API version prefixed to Bucket, so data for different
+// versions can live side by side in one database, or an empty string for none.
+var APIVersion string
+
+// Bucket is the global name of the database bucket containing all user buckets.
+var Bucket = []byte("main")
+
+// BloomFilter is the global flag that builds a Bloom filter of pair keys for each
+// database, so lookups for missing pairs skip the database.
+var BloomFilter bool
+
+// Blooms is the global map of running Bloom filters by database connection.
+var Blooms sync.Map
+
+// CORSOrigin is the global origin allowed for cross-origin requests, or empty for none.
+var CORSOrigin string
+
+// expandRef is the pattern of a "${user:name}" or "${name}" value reference.
+var expandRef = regexp.MustCompile(`\$\{([^}:]+)(?::([^}]+))?\}`)
+
+// EventLog is the global flag that records every set and delete of a pair as an Event.
+var EventLog bool
+
+// IndexValues is the global flag that maintains an index of pairs by value prefix.
+var IndexValues bool
+
+// StrictBucket is the global flag that fails writes if the root bucket does not exist,
+// instead of creating it.
+var StrictBucket bool
+
+// RequireJSON is the global flag that rejects writes of values that are not valid JSON,
+// and PUT requests without a JSON content type.
+var RequireJSON bool
+
+// DryRun is the global flag that validates write requests without committing them.
+var DryRun bool
+
+// DB is the global database connection object, used by requests without a store.
 var DB *bbolt.DB
 
+// Hook is the global Webhook that Events are posted to, or nil for none.
+var Hook *Webhook
+
+// HookBackoff is the global wait before the first retry of a failed webhook delivery,
+// doubled after each retry.
+var HookBackoff = time.Second
+
+// HostRouting is the global flag that scopes path users under the tenant named by
+// the request host.
+var HostRouting bool
+
+// IdleTimeout is the global maximum time an idle keep-alive connection is kept open.
+var IdleTimeout = 60 * time.Second
+
+// KeySep is the global separator between users and names in pair keys and legacy
+// flat keys, which names cannot contain.
+var KeySep = ":"
+
+// LowerKeys is the global flag that stores user and name keys in lowercase, so they
+// match regardless of case. Existing keys are never rewritten, so switching it on a
+// populated database is unsafe: lowercase lookups cannot reach mixed-case keys, and
+// case-sensitive lookups only reach lowercase keys by their lowercase names.
+var LowerKeys = true
+
+// MaxConns is the global maximum number of simultaneous connections, or zero for no
+// limit.
+var MaxConns int
+
+// MaxBody is the global maximum size of a JSON or import request body in bytes.
+var MaxBody = 64 << 20
+
+// MaxHeader is the global maximum size of request headers in bytes, beyond which
+// requests are rejected with a 431 response.
+var MaxHeader = http.DefaultMaxHeaderBytes
+
+// MaxName is the global maximum length of user and name strings in bytes.
+var MaxName = 255
+
+// MaxList is the global maximum number of names returned by a listing request.
+var MaxList = 1000
+
+// MaxValue is the global maximum length of a pair value in bytes.
+var MaxValue = 1 << 20
+
+// MaxUserBytes is the global maximum stored bytes per user, or zero for no limit.
+var MaxUserBytes int
+
+// MaxUserKeys is the global maximum number of pairs per user, or zero for no limit.
+var MaxUserKeys int
+
+// MethodRequests is the global count of served requests by HTTP method, excluding
+// metrics requests.
+var MethodRequests = map[string]*atomic.Int64{
+	"DELETE": new(atomic.Int64),
+	"GET":    new(atomic.Int64),
+	"HEAD":   new(atomic.Int64),
+	"PATCH":  new(atomic.Int64),
+	"POST":   new(atomic.Int64),
+	"PUT":    new(atomic.Int64),
+	"OTHER":  new(atomic.Int64),
+}
+
+// MinGzip is the global minimum size in bytes of a compressed response body.
+var MinGzip = 1024
+
+// QueueDelay is the global maximum time a queued write waits to join a batch.
+var QueueDelay = 2 * time.Millisecond
+
+// QueueSize is the global maximum number of queued writes applied in one batch.
+var QueueSize = 256
+
+// Queues is the global map of running write Queues by database connection.
+var Queues sync.Map
+
+// reloadMutex is the global lock held by Reload while it changes settings and reopens
+// DB, and held for reading by every request so none sees a partial reload.
+var reloadMutex sync.RWMutex
+
+// ReadOnly is the global flag that rejects all write requests.
+var ReadOnly bool
+
+// ReadTimeout is the global maximum time to read a request, including its body.
+var ReadTimeout = 10 * time.Second
+
+// Requests is the global count of served requests, excluding metrics requests.
+var Requests atomic.Int64
+
+// TrustProxy is the global flag that identifies clients by X-Forwarded-For headers.
+var TrustProxy bool
+
+// SocketMode is the global file permissions of a Unix socket listener.
+var SocketMode os.FileMode = 0660
+
+// startTime is the global time the server process started.
+var startTime time.Time
+
+// streamMutex is the global lock held for reading by streamed responses instead of
+// reloadMutex, and taken for writing by Reload and compaction before reloadMutex, so
+// waiting for long streams does not stall other requests.
+var streamMutex sync.RWMutex
+
+// TrimValues is the global flag that trims and newline-terminates stored values.
+var TrimValues = true
+
+// WriteTimeout is the global maximum time to handle a request and write its response.
+// It includes the time to read the request body, so servers accepting large values
+// over slow connections may need a longer WriteTimeout.
+var WriteTimeout = 10 * time.Second
+
+// IndexMessage is the global index page message, or empty for the server status.
+var IndexMessage string
+
+// Token is the global password required for write requests, or empty for none.
+var Token string
+
+// Upstream is the global URL of the server that pairs are read through from and
+// writes are proxied to, or empty for none.
+var Upstream string
+
+// UpstreamTTL is the global time pairs read through from Upstream are kept locally.
+var UpstreamTTL = time.Minute
+
+// Watchers is the global map of pair keys to watchers closed when those pairs change.
+var Watchers sync.Map
+
+// watchMutex is the global lock held while a watcher is added to or removed from
+// Watchers, so a removed watcher is never handed out.
+var watchMutex sync.Mutex
+
+// WatchTimeout is the global maximum time a watch request waits for a pair to change.
+var WatchTimeout = 30 * time.Second
+
 ///////////////////////////////////////////////////////////////////////////////////////
 //                      part two · string sanitisation functions                     //
 ///////////////////////////////////////////////////////////////////////////////////////
 
-// IsPrivate returns true if a name string is surrounded with two leading underscores.
+// CanonicalPath returns a URL path without a single trailing slash, unless it is the
+// root path.
+func CanonicalPath(path string) string {
+	if len(path) > 1 {
+		return strings.TrimSuffix(path, "/")
+	}
+
+	return path
+}
+
+// EscapeName returns a user or name string percent-encoded as a URL path segment, so
+// names with slashes, spaces or reserved characters can be requested as listed.
+func EscapeName(name string) string {
+	return url.PathEscape(name)
+}
+
+// IsPrivate returns true if a name string begins and ends with two underscores.
 func IsPrivate(name string) bool {
 	return strings.HasPrefix(name, "__") && strings.HasSuffix(name, "__")
 }
 
-// PairKey returns a lowercase pair key string from user and name strings.
+// JoinNames returns user or name strings escaped with EscapeName, one per line.
+func JoinNames(names []string) string {
+	elems := make([]string, len(names))
+	for i, name := range names {
+		elems[i] = EscapeName(name)
+	}
+
+	return strings.Join(elems, "\n")
+}
+
+// NameKey returns a bucket or pair key from a user or name string, in lowercase if
+// LowerKeys is set.
+func NameKey(text string) []byte {
+	if LowerKeys {
+		text = strings.ToLower(text)
+	}
+
+	return []byte(text)
+}
+
+// PairKey returns a pair key string from user and name strings, in lowercase if
+// LowerKeys is set.
 func PairKey(user, name string) []byte {
-	user = strings.ToLower(user)
-	name = strings.ToLower(name)
-	return []byte(user + ":" + name)
+	return []byte(string(NameKey(user)) + KeySep + string(NameKey(name)))
 }
 
-// PairValue returns a whitespace-trimmed pair value string.
+// PairValue returns a whitespace-trimmed, newline-terminated pair value string, or
+// the unchanged string if TrimValues is not set.
 func PairValue(text string) []byte {
+	if !TrimValues {
+		return []byte(text)
+	}
+
 	return []byte(strings.TrimSpace(text) + "\n")
 }
 
+// TenantFromHost returns the lowercase leading label of a host string with an
+// optional port, or an empty string if the host is an IP address or has fewer than
+// three labels.
+func TenantFromHost(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return ""
+	}
+
+	labels := strings.Split(host, ".")
+	if len(labels) < 3 {
+		return ""
+	}
+
+	return labels[0]
+}
+
+// ValidName returns true if a user or name string is non-empty, does not contain
+// KeySep and is no longer than MaxName.
+func ValidName(name string) bool {
+	return name != "" && !strings.Contains(name, KeySep) && len(name) <= MaxName
+}
+
 ///////////////////////////////////////////////////////////////////////////////////////
-//                      part three · database handling functions                     //
+//                       part three · value encoding functions                       //
 ///////////////////////////////////////////////////////////////////////////////////////
 
-// DeletePair deletes an existing pair from a database.
-func DeletePair(db *bbolt.DB, user, name string) error {
-	return db.Update(func(tx *bbolt.Tx) error {
-		if buck := tx.Bucket([]byte("main")); buck != nil {
-			return buck.Delete(PairKey(user, name))
-		}
+// valueMagic is the leading byte of a stored value with a metadata header.
+const valueMagic = 0x00
 
-		return nil
-	})
+// flagExpiry is the header flag for a value with an expiry timestamp.
+const flagExpiry = 1 << 0
+
+// flagModified is the header flag for a value with a modification timestamp.
+const flagModified = 1 << 1
+
+// flagRaw is the header flag for a value stored verbatim without trimming.
+const flagRaw = 1 << 2
+
+// flagType is the header flag for a value with a length-prefixed content type.
+const flagType = 1 << 3
+
+// flagGzip is the header flag for a value stored compressed with gzip.
+const flagGzip = 1 << 4
+
+// Event is a JSON-encodable set or delete of a pair, with its sequence number and time
+// in Unix nanoseconds.
+type Event struct {
+	Seq  uint64 `json:"seq,omitempty"`
+	Time int64  `json:"time"`
+	Op   string `json:"op"`
+	User string `json:"user"`
+	Name string `json:"name"`
 }
 
-// GetPair returns the value of an existing pair from a database and a boolean
-// indicating if the pair exists.
-func GetPair(db *bbolt.DB, user, name string) (string, bool, error) {
-	var pval string
-	var okay = false
+// Record is a JSON-encodable pair for exporting and importing, with its optional
+// modification time in Unix nanoseconds.
+type Record struct {
+	User     string `json:"user"`
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Modified int64  `json:"modified,omitempty"`
+}
 
-	return pval, okay, db.View(func(tx *bbolt.Tx) error {
-		if buck := tx.Bucket([]byte("main")); buck != nil {
-			bytes := buck.Get(PairKey(user, name))
-			pval = string(bytes)
-			okay = bytes != nil
+// TxOp is a JSON-encodable write operation in a multi-operation transaction.
+type TxOp struct {
+	Op    string `json:"op"`
+	User  string `json:"user"`
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+// Usage is a JSON-encodable count of the pairs and stored bytes for a user.
+type Usage struct {
+	Keys  int `json:"keys"`
+	Bytes int `json:"bytes"`
+}
+
+// Value is a decoded pair value with its optional metadata, where an empty Type is
+// plain text and Data is compressed if Gzip is set.
+type Value struct {
+	Data     []byte
+	Expiry   time.Time
+	Modified time.Time
+	Raw      bool
+	Type     string
+	Gzip     bool
+}
+
+// decodeJSON returns a decoded JSON document with its numbers kept verbatim, and a
+// boolean indicating if the document was valid.
+func decodeJSON(data []byte) (any, bool) {
+	var elem any
+	if !json.Valid(data) {
+		return nil, false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&elem); err != nil {
+		return nil, false
+	}
+
+	return elem, true
+}
+
+// decodeTime returns a timestamp and the remaining bytes from the start of header
+// bytes, and a boolean indicating if the bytes were long enough.
+func decodeTime(bytes []byte) (time.Time, []byte, bool) {
+	if len(bytes) < 8 {
+		return time.Time{}, bytes, false
+	}
+
+	return time.Unix(0, int64(binary.BigEndian.Uint64(bytes))), bytes[8:], true
+}
+
+// decodeType returns a content type and the remaining bytes from the start of header
+// bytes, and a boolean indicating if the bytes were long enough.
+func decodeType(bytes []byte) (string, []byte, bool) {
+	if len(bytes) < 1 || len(bytes) < 1+int(bytes[0]) {
+		return "", bytes, false
+	}
+
+	size := 1 + int(bytes[0])
+	return string(bytes[1:size]), bytes[size:], true
+}
+
+// mergePatch returns a decoded JSON document with a decoded JSON merge patch applied,
+// replacing the document unless both are objects.
+func mergePatch(doc, patch any) any {
+	diff, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	base, ok := doc.(map[string]any)
+	if !ok {
+		base = make(map[string]any)
+	}
+
+	for key, elem := range diff {
+		if elem == nil {
+			delete(base, key)
+		} else {
+			base[key] = mergePatch(base[key], elem)
 		}
+	}
 
-		return nil
-	})
+	return base
 }
 
-// SetPair sets the value of a new or existing pair in a database.
-func SetPair(db *bbolt.DB, user, name, pval string) error {
-	return db.Update(func(tx *bbolt.Tx) error {
-		buck, err := tx.CreateBucketIfNotExists([]byte("main"))
-		if err != nil {
-			return err
+// DecodeValue returns a Value from stored bytes, treating bytes without a valid
+// metadata header as plain text data.
+func DecodeValue(bytes []byte) Value {
+	if len(bytes) < 2 || bytes[0] != valueMagic {
+		return Value{Data: bytes}
+	}
+
+	var vval Value
+	var okay = true
+	flags, rest := bytes[1], bytes[2:]
+	if flags&flagExpiry != 0 {
+		vval.Expiry, rest, okay = decodeTime(rest)
+	}
+
+	if okay && flags&flagModified != 0 {
+		vval.Modified, rest, okay = decodeTime(rest)
+	}
+
+	if okay && flags&flagType != 0 {
+		vval.Type, rest, okay = decodeType(rest)
+	}
+
+	if !okay {
+		return Value{Data: bytes}
+	}
+
+	vval.Raw = flags&flagRaw != 0
+	vval.Gzip = flags&flagGzip != 0
+	vval.Data = rest
+	return vval
+}
+
+// EncodeValue returns the stored bytes of a Value, omitting the metadata header if
+// the Value has no metadata and any Type longer than 255 bytes.
+func EncodeValue(vval Value) []byte {
+	var flags byte
+	if !vval.Expiry.IsZero() {
+		flags |= flagExpiry
+	}
+
+	if !vval.Modified.IsZero() {
+		flags |= flagModified
+	}
+
+	if vval.Raw {
+		flags |= flagRaw
+	}
+
+	if vval.Type != "" && len(vval.Type) <= 255 {
+		flags |= flagType
+	}
+
+	if vval.Gzip {
+		flags |= flagGzip
+	}
+
+	if flags == 0 && (len(vval.Data) == 0 || vval.Data[0] != valueMagic) {
+		return vval.Data
+	}
+
+	bytes := []byte{valueMagic, flags}
+	if flags&flagExpiry != 0 {
+		bytes = binary.BigEndian.AppendUint64(bytes, uint64(vval.Expiry.UnixNano()))
+	}
+
+	if flags&flagModified != 0 {
+		bytes = binary.BigEndian.AppendUint64(bytes, uint64(vval.Modified.UnixNano()))
+	}
+
+	if flags&flagType != 0 {
+		bytes = append(append(bytes, byte(len(vval.Type))), vval.Type...)
+	}
+
+	return append(bytes, vval.Data...)
+}
+
+// Expired returns true if a Value has an expiry time in the past.
+func (vval Value) Expired() bool {
+	return !vval.Expiry.IsZero() && time.Now().After(vval.Expiry)
+}
+
+// ExtractPath returns the JSON field at a dotted path of object keys and array
+// indexes in a JSON document, ErrNoPath if the path does not resolve, or ErrNotJSON if
+// the document is not valid JSON.
+func ExtractPath(value []byte, path string) ([]byte, error) {
+	elem, ok := decodeJSON(value)
+	if !ok {
+		return nil, ErrNotJSON
+	}
+
+	for _, part := range strings.Split(path, ".") {
+		switch node := elem.(type) {
+		case map[string]any:
+			if elem, ok = node[part]; !ok {
+				return nil, ErrNoPath
+			}
+		case []any:
+			indx, err := strconv.Atoi(part)
+			if err != nil || indx < 0 || indx >= len(node) {
+				return nil, ErrNoPath
+			}
+
+			elem = node[indx]
+		default:
+			return nil, ErrNoPath
 		}
+	}
 
-		return buck.Put(PairKey(user, name), PairValue(pval))
-	})
+	buff := new(bytes.Buffer)
+	enc := json.NewEncoder(buff)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(elem); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buff.Bytes(), []byte("\n")), nil
 }
 
-///////////////////////////////////////////////////////////////////////////////////////
-//                        part four · http response functions                        //
-///////////////////////////////////////////////////////////////////////////////////////
+// GunzipValue returns a Value with its Data decompressed if Gzip is set, ErrNotGzip if
+// the Data is not valid gzip, or ErrTooLarge if it decompresses to over MaxValue bytes.
+func GunzipValue(vval Value) (Value, error) {
+	if !vval.Gzip {
+		return vval, nil
+	}
 
-// WriteHTTP writes a plaintext response to a ResponseWriter.
-func WriteHTTP(w http.ResponseWriter, code int, form string, elems ...any) {
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.WriteHeader(code)
-	fmt.Fprintf(w, form+"\n", elems...)
+	read, err := gzip.NewReader(bytes.NewReader(vval.Data))
+	if err != nil {
+		return Value{}, ErrNotGzip
+	}
+
+	defer read.Close()
+	data, err := io.ReadAll(io.LimitReader(read, int64(MaxValue)+1))
+	switch {
+	case err != nil:
+		return Value{}, ErrNotGzip
+	case len(data) > MaxValue:
+		return Value{}, fmt.Errorf("%w: decompressed over limit of %d bytes", ErrTooLarge, MaxValue)
+	}
+
+	vval.Data, vval.Gzip = data, false
+	return vval, nil
 }
 
-// WriteError writes a plaintext error response to a ResponseWriter.
-func WriteError(w http.ResponseWriter, code int, form string, elems ...any) {
-	form = fmt.Sprintf("server error %d: %s", code, form)
-	WriteHTTP(w, code, form, elems...)
+// MergePatch returns a JSON document with a JSON merge patch applied as in RFC 7386,
+// deleting object members patched to null, or ErrNotJSON if the document is not
+// valid JSON.
+func MergePatch(existing, patch []byte) ([]byte, error) {
+	doc, ok := decodeJSON(existing)
+	if !ok {
+		return nil, ErrNotJSON
+	}
+
+	diff, ok := decodeJSON(patch)
+	if !ok {
+		return nil, errors.New("patch is not valid json")
+	}
+
+	buff := new(bytes.Buffer)
+	enc := json.NewEncoder(buff)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(mergePatch(doc, diff)); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buff.Bytes(), []byte("\n")), nil
 }
 
-// WriteFailure writes a plaintext failure response to a ResponseWriter.
-func WriteFailure(w http.ResponseWriter, code int, form string, elems ...any) {
-	form = fmt.Sprintf("client error %d: %s", code, form)
-	WriteHTTP(w, code, form, elems...)
+// PairETag returns the weak entity tag of a value's data.
+func PairETag(data []byte) string {
+	hash := fnv.New64a()
+	hash.Write(data)
+	return fmt.Sprintf(`W/"%016x"`, hash.Sum64())
 }
 
-///////////////////////////////////////////////////////////////////////////////////////
-//                       part five · server endpoint functions                       //
-///////////////////////////////////////////////////////////////////////////////////////
+// ValidateJSON returns ErrNotJSON with the parsing error if a body is not a single
+// valid JSON document.
+func ValidateJSON(body []byte) error {
+	var elem json.RawMessage
+	if err := json.Unmarshal(body, &elem); err != nil {
+		return fmt.Errorf("%w: %s", ErrNotJSON, err)
+	}
 
-// GetIndex returns the index page.
-func GetIndex(w http.ResponseWriter, r *http.Request) {
-	WriteHTTP(w, http.StatusOK, "Hello.")
+	return nil
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////
-//                         part six · main runtime functions                         //
+//                      part four · database handling functions                      //
 ///////////////////////////////////////////////////////////////////////////////////////
 
-// try panics on a non-nil error.
-func try(err error) {
+// watcher is a channel closed when a pair changes, with its number of waiting callers.
+type watcher struct {
+	wait chan struct{}
+	refs int
+}
+
+// writeRequest is a write function submitted to a Queue, with a channel for its result.
+type writeRequest struct {
+	fn   func(*bbolt.Tx) error
+	errc chan error
+}
+
+// Backups is a goroutine that periodically writes snapshots of a database, or of DB if
+// it has none, into a directory, keeping only a number of the newest snapshots.
+type Backups struct {
+	db   *bbolt.DB
+	dir  string
+	keep int
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Bloom is a Bloom filter of the pair keys in a database, where a key it does not
+// have definitely does not exist and a key it has may be a false positive. Deleted
+// keys are never removed, so they only add false positives.
+type Bloom struct {
+	db   *bbolt.DB
+	bits []atomic.Uint64
+}
+
+// TxError is the error for an invalid operation in a multi-operation transaction.
+type TxError struct {
+	Index int
+	Err   error
+}
+
+// Queue is a bounded queue of write functions, applied to a database in batched
+// transactions by a single goroutine.
+type Queue struct {
+	db   *bbolt.DB
+	reqs chan writeRequest
+	done chan struct{}
+}
+
+// Sweeper is a goroutine that periodically deletes expired pairs from a database, or
+// from DB if it has none, so pairs that are never read again do not fill the disk.
+type Sweeper struct {
+	db   *bbolt.DB
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Webhook is a bounded queue of Events, posted as JSON to a URL by a single goroutine
+// so slow deliveries never block writes.
+type Webhook struct {
+	url   string
+	evnts chan Event
+	ctx   context.Context
+	stop  context.CancelFunc
+	done  chan struct{}
+}
+
+// run writes and prunes snapshots for a Backups after every interval until it is
+// closed.
+func (b *Backups) run(interval time.Duration) {
+	defer close(b.done)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			b.snapshot()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// snapshot writes and prunes one snapshot for a Backups, holding reloadMutex for
+// reading while it uses DB, so reloads and compactions never close it mid-snapshot.
+func (b *Backups) snapshot() {
+	db := b.db
+	if db == nil {
+		reloadMutex.RLock()
+		defer reloadMutex.RUnlock()
+		db = DB
+	}
+
+	dest, err := SnapshotDB(db, b.dir)
 	if err != nil {
-		panic(err)
+		slog.Error("backup failed", "error", err)
+		return
+	}
+
+	slog.Debug("backup written", "path", dest)
+	if err := PruneBackups(b.dir, db.Path(), b.keep); err != nil {
+		slog.Error("backup prune failed", "error", err)
 	}
 }
 
-// main runs the main Gesedels program.
-func main() {
-	// Define and parse command-line functions.
-	fset := flag.NewFlagSet("gesedels", flag.ExitOnError)
-	addr := fset.String("addr", "127.0.0.1:8080", "set server address")
-	path := fset.String("path", "./gesedels.db", "set database path")
-	fset.Parse(os.Args[1:])
-
-	// Connect to and set database.
-	db, err := bbolt.Open(*path, 0666, nil)
-	try(err)
-	DB = db
+// Close stops a Backups after any snapshot in progress is written.
+func (b *Backups) Close() {
+	close(b.stop)
+	<-b.done
+}
 
-	// Initialise mux and register endpoints.
-	mux := http.NewServeMux()
-	mux.HandleFunc("GET /", GetIndex)
+// Add adds a pair key to a Bloom.
+func (b *Bloom) Add(key []byte) {
+	size := uint64(len(b.bits)) * 64
+	hash, step := hashKey(key)
+	for range BloomHashes {
+		bit := hash % size
+		b.bits[bit/64].Or(1 << (bit % 64))
+		hash += step
+	}
+}
+
+// Close stops a Bloom filtering lookups for its database.
+func (b *Bloom) Close() {
+	Blooms.Delete(b.db)
+}
 
-	// Initialise and run server.
-	srv := &http.Server{Addr: *addr, Handler: mux}
-	try(srv.ListenAndServe())
+// Has returns true if a pair key may be in a Bloom, or false if it is definitely not.
+func (b *Bloom) Has(key []byte) bool {
+	size := uint64(len(b.bits)) * 64
+	hash, step := hashKey(key)
+	for range BloomHashes {
+		bit := hash % size
+		if b.bits[bit/64].Load()&(1<<(bit%64)) == 0 {
+			return false
+		}
+
+		hash += step
+	}
+
+	return true
+}
+
+// apply applies a batch of write requests to a Queue's database in one transaction,
+// or in separate transactions if the batch fails, so each request gets its own error.
+func (q *Queue) apply(batch []writeRequest) {
+	err := q.db.Update(func(tx *bbolt.Tx) error {
+		for _, req := range batch {
+			if err := req.fn(tx); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	slog.Debug("batch applied", "path", q.db.Path(), "size", len(batch), "error", err)
+	for _, req := range batch {
+		if err != nil {
+			req.errc <- q.db.Update(req.fn)
+		} else {
+			req.errc <- nil
+		}
+	}
+}
+
+// run collects and applies batches of write requests from a Queue until it is closed.
+func (q *Queue) run(size int, delay time.Duration) {
+	defer close(q.done)
+
+	for req := range q.reqs {
+		batch := []writeRequest{req}
+		timer := time.NewTimer(delay)
+
+	collect:
+		for len(batch) < size {
+			select {
+			case req, ok := <-q.reqs:
+				if !ok {
+					break collect
+				}
+
+				batch = append(batch, req)
+			case <-timer.C:
+				break collect
+			}
+		}
+
+		timer.Stop()
+		q.apply(batch)
+	}
+}
+
+// Close stops a Queue after applying all of its pending writes. The Queue must not be
+// submitted to after it is closed.
+func (q *Queue) Close() {
+	Queues.Delete(q.db)
+	close(q.reqs)
+	<-q.done
+}
+
+// Submit applies a write function to a Queue's database in the next batch and
+// returns its result.
+func (q *Queue) Submit(fn func(*bbolt.Tx) error) error {
+	errc := make(chan error, 1)
+	q.reqs <- writeRequest{fn, errc}
+	return <-errc
+}
+
+// run sweeps expired pairs for a Sweeper after every interval until it is closed.
+func (s *Sweeper) run(interval time.Duration) {
+	defer close(s.done)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			s.sweep()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// sweep deletes the expired pairs for a Sweeper once, holding reloadMutex for reading
+// while it uses DB, so reloads and compactions never close it mid-sweep.
+func (s *Sweeper) sweep() {
+	db := s.db
+	if db == nil {
+		reloadMutex.RLock()
+		defer reloadMutex.RUnlock()
+		db = DB
+	}
+
+	size, err := SweepPairs(db)
+	if err != nil {
+		slog.Error("sweep failed", "error", err)
+		return
+	}
+
+	slog.Debug("sweep finished", "deleted", size)
+}
+
+// Close stops a Sweeper after any sweep in progress is finished.
+func (s *Sweeper) Close() {
+	close(s.stop)
+	<-s.done
+}
+
+// post posts an Event to a Webhook's URL, retrying failed deliveries HookRetries times
+// with doubling backoff.
+func (w *Webhook) post(evnt Event) error {
+	data, err := json.Marshal(evnt)
+	if err != nil {
+		return err
+	}
+
+	wait := HookBackoff
+	for try := 0; ; try++ {
+		err := w.send(data)
+		if err == nil || try == HookRetries {
+			return err
+		}
+
+		select {
+		case <-time.After(wait):
+			wait *= 2
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		}
+	}
+}
+
+// run posts Events from a Webhook until it is closed, logging and dropping Events
+// that cannot be delivered.
+func (w *Webhook) run() {
+	defer close(w.done)
+
+	for evnt := range w.evnts {
+		if w.ctx.Err() != nil {
+			continue
+		}
+
+		if err := w.post(evnt); err != nil {
+			slog.Warn("webhook failed", "op", evnt.Op, "user", evnt.User, "name", evnt.Name, "error", err)
+		}
+	}
+}
+
+// send makes one delivery attempt of JSON data to a Webhook's URL.
+func (w *Webhook) send(data []byte) error {
+	ctx, cancel := context.WithTimeout(w.ctx, HookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Close stops a Webhook after any delivery in progress, dropping undelivered Events.
+// The Webhook must not be sent to after it is closed.
+func (w *Webhook) Close() {
+	w.stop()
+	close(w.evnts)
+	<-w.done
+}
+
+// Send queues an Event for delivery by a Webhook without blocking, dropping it if the
+// Webhook's queue is full.
+func (w *Webhook) Send(evnt Event) {
+	select {
+	case w.evnts <- evnt:
+	default:
+		slog.Warn("webhook dropped", "op", evnt.Op, "user", evnt.User, "name", evnt.Name)
+	}
+}
+
+// Error returns the error message of a TxError.
+func (e *TxError) Error() string {
+	return fmt.Sprintf("operation %d: %s", e.Index, e.Err)
+}
+
+// Unwrap returns the underlying error of a TxError.
+func (e *TxError) Unwrap() error {
+	return e.Err
+}
+
+// addUsage adds a change in pairs and stored bytes to a user's usage counter in a
+// transaction, or returns ErrQuota if a growing change would exceed MaxUserKeys or
+// MaxUserBytes.
+func addUsage(tx *bbolt.Tx, user string, keys, size int) error {
+	usage := getUsage(tx, user)
+	usage.Keys += keys
+	usage.Bytes += size
+
+	switch {
+	case keys > 0 && MaxUserKeys > 0 && usage.Keys > MaxUserKeys:
+		return fmt.Errorf("%w: user %s is over limit of %d pairs", ErrQuota, user, MaxUserKeys)
+	case size > 0 && MaxUserBytes > 0 && usage.Bytes > MaxUserBytes:
+		return fmt.Errorf("%w: user %s is over limit of %d bytes", ErrQuota, user, MaxUserBytes)
+	}
+
+	buck, err := tx.CreateBucketIfNotExists(usageName())
+	if err != nil {
+		return err
+	}
+
+	if usage.Keys <= 0 {
+		return buck.Delete(NameKey(user))
+	}
+
+	data := binary.BigEndian.AppendUint64(nil, uint64(usage.Keys))
+	data = binary.BigEndian.AppendUint64(data, uint64(usage.Bytes))
+	return buck.Put(NameKey(user), data)
+}
+
+// backupName returns the timestamped snapshot file name for a database path.
+func backupName(path string, when time.Time) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return fmt.Sprintf("%s-%s.db", base, when.UTC().Format(backupStamp))
+}
+
+// hashKey returns the first bit and bit step of a pair key in a Bloom, from two
+// independent hashes so the steps for different keys differ.
+func hashKey(key []byte) (uint64, uint64) {
+	hash, step := fnv.New64a(), fnv.New64()
+	hash.Write(key)
+	step.Write(key)
+	return hash.Sum64(), step.Sum64() | 1
+}
+
+// copyPair copies an existing pair to a new user and name in a transaction, returning
+// false if it does not exist, or ErrPairExists if the destination exists and
+// overwrite is not set.
+func copyPair(tx *bbolt.Tx, srcUser, srcName, dstUser, dstName string, overwrite bool) (bool, error) {
+	buck := userBucket(tx, srcUser)
+	if buck == nil {
+		return false, nil
+	}
+
+	data := buck.Get(NameKey(srcName))
+	vval := DecodeValue(data)
+	if data == nil || vval.Expired() {
+		return false, nil
+	}
+
+	if dest := userBucket(tx, dstUser); dest != nil && !overwrite {
+		if data := dest.Get(NameKey(dstName)); data != nil && !DecodeValue(data).Expired() {
+			return true, ErrPairExists
+		}
+	}
+
+	vval.Data = bytes.Clone(vval.Data)
+	vval.Modified = time.Time{}
+	return true, putPair(tx, dstUser, dstName, vval)
+}
+
+// createPair sets the value of a new pair in a transaction only if it does not exist,
+// returning true if it was set.
+func createPair(tx *bbolt.Tx, user, name string, vval Value) (bool, error) {
+	if buck := userBucket(tx, user); buck != nil {
+		data := buck.Get(NameKey(name))
+		if data != nil && !DecodeValue(data).Expired() {
+			return false, nil
+		}
+	}
+
+	return true, putPair(tx, user, name, vval)
+}
+
+// deletePair deletes an existing pair from a transaction, along with its user bucket
+// if no other pairs remain in it.
+func deletePair(tx *bbolt.Tx, user, name string) error {
+	buck := userBucket(tx, user)
+	if buck == nil {
+		return nil
+	}
+
+	if data := buck.Get(NameKey(name)); data != nil {
+		if err := addUsage(tx, user, -1, -len(data)); err != nil {
+			return err
+		}
+
+		if err := indexPair(tx, user, name, data, false); err != nil {
+			return err
+		}
+
+		if err := LogEvent(tx, "delete", user, name); err != nil {
+			return err
+		}
+	}
+
+	if err := buck.Delete(NameKey(name)); err != nil {
+		return err
+	}
+
+	notifyPair(tx, user, name)
+	if pkey, _ := buck.Cursor().First(); pkey == nil {
+		return tx.Bucket(rootName()).DeleteBucket(NameKey(user))
+	}
+
+	return nil
+}
+
+// eventsName returns the name of the bucket holding Events for the root bucket.
+func eventsName() []byte {
+	return []byte(string(rootName()) + KeySep + "events")
+}
+
+// expandValue returns a value string with its references recursively expanded up to
+// a depth, skipping references to pairs already being expanded, with a scope prefixed
+// to each referenced user.
+func expandValue(db *bbolt.DB, scope, user, value string, depth int, seen map[string]bool) (string, error) {
+	var errs error
+
+	text := expandRef.ReplaceAllStringFunc(value, func(ref string) string {
+		if errs != nil {
+			return ref
+		}
+
+		subs := expandRef.FindStringSubmatch(ref)
+		refUser, refName := user, subs[1]
+		if subs[2] != "" {
+			refUser, refName = scope+subs[1], subs[2]
+		}
+
+		pkey := string(PairKey(refUser, refName))
+		if IsPrivate(refUser) || IsPrivate(refName) {
+			errs = fmt.Errorf("%w: %s", ErrMissingRef, pkey)
+			return ref
+		}
+
+		if seen[pkey] || depth <= 0 {
+			errs = fmt.Errorf("%w at %s", ErrLoop, pkey)
+			return ref
+		}
+
+		pval, ok, err := GetPair(db, refUser, refName)
+		switch {
+		case err != nil:
+			errs = err
+			return ref
+		case !ok:
+			errs = fmt.Errorf("%w: %s", ErrMissingRef, pkey)
+			return ref
+		}
+
+		seen[pkey] = true
+		pval, errs = expandValue(db, scope, refUser, strings.TrimSuffix(pval, "\n"), depth-1, seen)
+		delete(seen, pkey)
+		return pval
+	})
+
+	return text, errs
+}
+
+// exportBucket writes all unexpired pairs in a user bucket to an Encoder as JSON
+// Records, skipping private users and names.
+func exportBucket(enc *json.Encoder, user string, buck *bbolt.Bucket) error {
+	return buck.ForEach(func(name, bytes []byte) error {
+		vval := DecodeValue(bytes)
+		if vval.Expired() || IsPrivate(user) || IsPrivate(string(name)) {
+			return nil
+		}
+
+		vval, err := GunzipValue(vval)
+		if err != nil {
+			return err
+		}
+
+		rec := Record{User: user, Name: string(name), Value: string(vval.Data)}
+		if !vval.Modified.IsZero() {
+			rec.Modified = vval.Modified.UnixNano()
+		}
+
+		return enc.Encode(rec)
+	})
+}
+
+// getUsage returns a user's usage counter from a transaction, counting the user's
+// pairs if no counter is stored.
+func getUsage(tx *bbolt.Tx, user string) Usage {
+	var usage Usage
+	if buck := tx.Bucket(usageName()); buck != nil {
+		if data := buck.Get(NameKey(user)); len(data) == 16 {
+			usage.Keys = int(binary.BigEndian.Uint64(data))
+			usage.Bytes = int(binary.BigEndian.Uint64(data[8:]))
+			return usage
+		}
+	}
+
+	if buck := userBucket(tx, user); buck != nil {
+		buck.ForEach(func(_, data []byte) error {
+			usage.Keys++
+			usage.Bytes += len(data)
+			return nil
+		})
+	}
+
+	return usage
+}
+
+// importPairs writes newline-delimited JSON Records from a Reader into a database in
+// transactions of BatchSize pairs with a put function, returning the number of pairs
+// it set and the number of malformed lines and pairs it did not set.
+func importPairs(db *bbolt.DB, r io.Reader, put func(*bbolt.Tx, Record) (bool, error)) (int, int, error) {
+	var done, skip int
+	var recs []Record
+	read := bufio.NewReader(r)
+
+	flush := func() error {
+		var stale int
+		err := update(db, func(tx *bbolt.Tx) error {
+			stale = 0
+			for _, rec := range recs {
+				okay, err := put(tx, rec)
+				if err != nil {
+					return err
+				}
+
+				if !okay {
+					stale++
+				}
+			}
+
+			return nil
+		})
+
+		if err == nil {
+			done += len(recs) - stale
+			skip += stale
+			recs = recs[:0]
+		}
+
+		return err
+	}
+
+	for {
+		line, err := read.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) != 0 {
+			var rec Record
+			switch {
+			case json.Unmarshal(line, &rec) != nil:
+				skip++
+			case !ValidName(rec.User) || !ValidName(rec.Name) || len(PairValue(rec.Value)) > MaxValue:
+				skip++
+			case RequireJSON && !json.Valid([]byte(rec.Value)):
+				skip++
+			default:
+				recs = append(recs, rec)
+			}
+		}
+
+		if len(recs) >= BatchSize || (err != nil && len(recs) != 0) {
+			if err := flush(); err != nil {
+				return done, skip, err
+			}
+		}
+
+		switch {
+		case err == io.EOF:
+			return done, skip, nil
+		case err != nil:
+			return done, skip, err
+		}
+	}
+}
+
+// indexKey returns the value index key for the encoded value of a pair, made of up to
+// IndexPrefix bytes of its decompressed value, its pair key and the length of its pair
+// key as a big-endian uint16.
+func indexKey(data []byte, user, name string) []byte {
+	vval := DecodeValue(data)
+	if plain, err := GunzipValue(vval); err == nil {
+		vval = plain
+	}
+
+	pkey := PairKey(user, name)
+	ikey := append(bytes.Clone(vval.Data[:min(len(vval.Data), IndexPrefix)]), pkey...)
+	return binary.BigEndian.AppendUint16(ikey, uint16(len(pkey)))
+}
+
+// indexName returns the name of the bucket holding the value index for the root bucket.
+func indexName() []byte {
+	return []byte(string(rootName()) + KeySep + "index")
+}
+
+// indexPair adds or removes the value index key for the encoded value of a pair in a
+// transaction, if IndexValues is set and the value is not nil.
+func indexPair(tx *bbolt.Tx, user, name string, data []byte, add bool) error {
+	if !IndexValues || data == nil {
+		return nil
+	}
+
+	buck, err := tx.CreateBucketIfNotExists(indexName())
+	if err != nil {
+		return err
+	}
+
+	if add {
+		return buck.Put(indexKey(data, user, name), []byte{})
+	}
+
+	return buck.Delete(indexKey(data, user, name))
+}
+
+// isDiskFull returns true if an error is caused by a full disk, including errors that
+// bbolt formats as text instead of wrapping.
+func isDiskFull(err error) bool {
+	return err != nil && (errors.Is(err, syscall.ENOSPC) ||
+		strings.Contains(err.Error(), syscall.ENOSPC.Error()))
+}
+
+// markPair adds a pair to the Bloom for a transaction's database, if one is running,
+// before the pair is written so lookups never miss it.
+func markPair(tx *bbolt.Tx, user, name string) {
+	if bloom, ok := Blooms.Load(tx.DB()); ok {
+		bloom.(*Bloom).Add(PairKey(user, name))
+	}
+}
+
+// mergePair sets the value of a new or existing pair in a transaction only if its
+// modification time is newer than the current value's, returning true if it was set.
+func mergePair(tx *bbolt.Tx, user, name string, vval Value) (bool, error) {
+	if buck := userBucket(tx, user); buck != nil {
+		data := buck.Get(NameKey(name))
+		if curr := DecodeValue(data); data != nil && !curr.Expired() && !vval.Modified.After(curr.Modified) {
+			return false, nil
+		}
+	}
+
+	return true, putPair(tx, user, name, vval)
+}
+
+// notifyPair wakes all watchers of a pair once a transaction commits.
+func notifyPair(tx *bbolt.Tx, user, name string) {
+	pkey := string(PairKey(user, name))
+	tx.OnCommit(func() {
+		if wtch, ok := Watchers.LoadAndDelete(pkey); ok {
+			close(wtch.(*watcher).wait)
+		}
+	})
+}
+
+// openDB returns a database connection for a path with bbolt Options, or for a
+// writable temporary file that is deleted on open if the path is MemoryPath.
+func openDB(path string, opts *bbolt.Options) (*bbolt.DB, error) {
+	slog.Debug("database opened", "path", path, "read_only", opts.ReadOnly)
+	if path != MemoryPath {
+		return bbolt.Open(path, 0666, opts)
+	}
+
+	file, err := os.CreateTemp("", "gesedels-*.db")
+	if err != nil {
+		return nil, err
+	}
+
+	file.Close()
+	defer os.Remove(file.Name())
+	return bbolt.Open(file.Name(), 0666, &bbolt.Options{Timeout: opts.Timeout})
+}
+
+// putPair sets the value of a new or existing pair in a transaction, stamped with
+// the current time if it has no modification time, or returns ErrTooLarge if the value
+// is longer than MaxValue, ErrNotGzip if it is an invalid gzip value, ErrNotJSON if
+// RequireJSON is set and it is not valid JSON, ErrNoBucket if StrictBucket is set and
+// the root bucket does not exist or ErrQuota if it would exceed the user's quota.
+func putPair(tx *bbolt.Tx, user, name string, vval Value) error {
+	if len(vval.Data) > MaxValue {
+		return fmt.Errorf("%w: %d bytes is over limit of %d", ErrTooLarge, len(vval.Data), MaxValue)
+	}
+
+	plain, err := GunzipValue(vval)
+	if err != nil {
+		return err
+	}
+
+	if RequireJSON {
+		if err := ValidateJSON(plain.Data); err != nil {
+			return err
+		}
+	}
+
+	if vval.Modified.IsZero() {
+		vval.Modified = time.Now()
+	}
+
+	if StrictBucket && tx.Bucket(rootName()) == nil {
+		return fmt.Errorf("%w: %s", ErrNoBucket, rootName())
+	}
+
+	root, err := tx.CreateBucketIfNotExists(rootName())
+	if err != nil {
+		return err
+	}
+
+	buck, err := root.CreateBucketIfNotExists(NameKey(user))
+	if err != nil {
+		return err
+	}
+
+	data := EncodeValue(vval)
+	prev := buck.Get(NameKey(name))
+	keys, size := 1, len(data)
+	if prev != nil {
+		keys, size = 0, len(data)-len(prev)
+	}
+
+	if err := addUsage(tx, user, keys, size); err != nil {
+		return err
+	}
+
+	if err := indexPair(tx, user, name, prev, false); err != nil {
+		return err
+	}
+
+	if err := indexPair(tx, user, name, data, true); err != nil {
+		return err
+	}
+
+	if err := LogEvent(tx, "set", user, name); err != nil {
+		return err
+	}
+
+	markPair(tx, user, name)
+	notifyPair(tx, user, name)
+	return buck.Put(NameKey(name), data)
+}
+
+// rootName returns the name of the bucket containing all user buckets, Bucket for
+// APIVersion.
+func rootName() []byte {
+	return versionName(APIVersion)
+}
+
+// startDB moves any flat pairs in a database into user buckets and starts its write
+// Queue, unless ReadOnly is set, and its Bloom if BloomFilter is set.
+func startDB(db *bbolt.DB) error {
+	if !ReadOnly {
+		if _, err := MigrateToBuckets(db); err != nil {
+			return err
+		}
+
+		if _, err := SyncIndex(db); err != nil {
+			return err
+		}
+
+		NewQueue(db, QueueSize, QueueDelay)
+	}
+
+	if BloomFilter {
+		if _, err := NewBloom(db); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stopDB closes a database after closing any Queue or Bloom running for it.
+func stopDB(db *bbolt.DB) error {
+	if queue, ok := Queues.Load(db); ok {
+		queue.(*Queue).Close()
+	}
+
+	if bloom, ok := Blooms.Load(db); ok {
+		bloom.(*Bloom).Close()
+	}
+
+	return db.Close()
+}
+
+// update applies a write function to a database, through its Queue if one is running,
+// or rolls it back if DryRun is set, returning ErrReadOnly if the database is
+// read-only.
+func update(db *bbolt.DB, fn func(*bbolt.Tx) error) error {
+	if db.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	if DryRun {
+		err := db.Update(func(tx *bbolt.Tx) error {
+			if err := fn(tx); err != nil {
+				return err
+			}
+
+			return errDryRun
+		})
+
+		if errors.Is(err, errDryRun) {
+			return nil
+		}
+
+		return err
+	}
+
+	if queue, ok := Queues.Load(db); ok {
+		return queue.(*Queue).Submit(fn)
+	}
+
+	return db.Update(fn)
+}
+
+// usageName returns the name of the bucket holding usage counters for the root bucket.
+func usageName() []byte {
+	return []byte(string(rootName()) + KeySep + "usage")
+}
+
+// userBucket returns the nested bucket for a user from a transaction, or nil if it
+// does not exist.
+func userBucket(tx *bbolt.Tx, user string) *bbolt.Bucket {
+	if buck := tx.Bucket(rootName()); buck != nil {
+		return buck.Bucket(NameKey(user))
+	}
+
+	return nil
+}
+
+// versionName returns the name of Bucket prefixed with an API version and KeySep, or
+// Bucket if the version is empty.
+func versionName(version string) []byte {
+	if version == "" {
+		return Bucket
+	}
+
+	return []byte(version + KeySep + string(Bucket))
+}
+
+// waitContext runs a function in a goroutine and returns its error, or the Context's
+// error if it is done first. A bbolt transaction cannot be cancelled once started, so
+// this bounds the wait for the function but not its work.
+func waitContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- fn()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AppendPair appends a newline-terminated value to the value of a new or existing
+// pair in a database.
+func AppendPair(db *bbolt.DB, user, name, text string) error {
+	return update(db, func(tx *bbolt.Tx) error {
+		var vval Value
+		if buck := userBucket(tx, user); buck != nil {
+			vval = DecodeValue(buck.Get(NameKey(name)))
+		}
+
+		if vval.Expired() {
+			vval = Value{}
+		}
+
+		vval, err := GunzipValue(vval)
+		if err != nil {
+			return err
+		}
+
+		vval.Data = append(bytes.Clone(vval.Data), PairValue(text)...)
+		vval.Modified = time.Time{}
+		return putPair(tx, user, name, vval)
+	})
+}
+
+// ApplyTx applies a list of set and delete operations to a database within a single
+// transaction, applying none and returning a TxError if any operation is invalid.
+func ApplyTx(db *bbolt.DB, ops []TxOp) error {
+	for i, op := range ops {
+		var err error
+		switch {
+		case op.Op != "set" && op.Op != "delete":
+			err = fmt.Errorf("invalid op %q", op.Op)
+		case !ValidName(op.User):
+			err = fmt.Errorf("invalid name %q", op.User)
+		case !ValidName(op.Name):
+			err = fmt.Errorf("invalid name %q", op.Name)
+		case op.Op == "set" && strings.TrimSpace(op.Value) == "":
+			err = errors.New("value is empty")
+		case op.Op == "set" && len(PairValue(op.Value)) > MaxValue:
+			err = fmt.Errorf("%w: over limit of %d bytes", ErrTooLarge, MaxValue)
+		case op.Op == "set" && RequireJSON && !json.Valid([]byte(op.Value)):
+			err = ErrNotJSON
+		}
+
+		if err != nil {
+			return &TxError{i, err}
+		}
+	}
+
+	return update(db, func(tx *bbolt.Tx) error {
+		for _, op := range ops {
+			var err error
+			if op.Op == "set" {
+				err = putPair(tx, op.User, op.Name, Value{Data: PairValue(op.Value)})
+			} else {
+				err = deletePair(tx, op.User, op.Name)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// BackupDB writes a consistent snapshot of an entire database file to a Writer.
+func BackupDB(db *bbolt.DB, w io.Writer) error {
+	return db.View(func(tx *bbolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+// BackupFile writes a consistent snapshot of an entire database file to a destination
+// path, replacing it only once the snapshot is complete.
+func BackupFile(db *bbolt.DB, dest string) error {
+	file, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name())
+	if err := BackupDB(db, file); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), dest)
+}
+
+// CheckDB returns the joined integrity errors found in a database, if any.
+func CheckDB(db *bbolt.DB) error {
+	return db.View(func(tx *bbolt.Tx) error {
+		var errs []error
+		for err := range tx.Check() {
+			slog.Error("database check failed", "error", err)
+			errs = append(errs, err)
+		}
+
+		return errors.Join(errs...)
+	})
+}
+
+// CompactDB writes a compacted copy of a database, without its free pages, to a new
+// database file at a destination path and returns the file sizes before and after.
+func CompactDB(db *bbolt.DB, dest string) (int64, int64, error) {
+	dst, err := bbolt.Open(dest, 0666, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := bbolt.Compact(dst, db, CompactTxSize); err != nil {
+		dst.Close()
+		return 0, 0, err
+	}
+
+	if err := dst.Close(); err != nil {
+		return 0, 0, err
+	}
+
+	src, err := os.Stat(db.Path())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	out, err := os.Stat(dest)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return src.Size(), out.Size(), nil
+}
+
+// CopyAcross copies an existing pair in a database to a new user and name within a
+// single transaction, returning false if it does not exist, or ErrPairExists if the
+// destination exists.
+func CopyAcross(db *bbolt.DB, srcUser, srcName, dstUser, dstName string) (bool, error) {
+	var okay = false
+
+	err := update(db, func(tx *bbolt.Tx) (err error) {
+		okay, err = copyPair(tx, srcUser, srcName, dstUser, dstName, false)
+		return err
+	})
+
+	return okay, err
+}
+
+// CopyPair copies an existing pair for a user in a database to a new name within a
+// single transaction, returning false if it does not exist, or ErrPairExists if the
+// new name exists and overwrite is not set.
+func CopyPair(db *bbolt.DB, user, srcName, dstName string, overwrite bool) (bool, error) {
+	var okay = false
+
+	err := update(db, func(tx *bbolt.Tx) (err error) {
+		okay, err = copyPair(tx, user, srcName, user, dstName, overwrite)
+		return err
+	})
+
+	return okay, err
+}
+
+// CountPairs returns the number of unexpired pairs for a user in a database.
+func CountPairs(db *bbolt.DB, user string) (int, error) {
+	var size int
+
+	err := db.View(func(tx *bbolt.Tx) error {
+		buck := userBucket(tx, user)
+		if buck == nil {
+			return nil
+		}
+
+		curs := buck.Cursor()
+		for name, data := curs.First(); name != nil; name, data = curs.Next() {
+			if !DecodeValue(data).Expired() {
+				size++
+			}
+		}
+
+		return nil
+	})
+
+	return size, err
+}
+
+// CountKeys returns the total number of pairs for all users in a database.
+func CountKeys(db *bbolt.DB) (int, error) {
+	var size int
+
+	err := db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(rootName())
+		if root == nil {
+			return nil
+		}
+
+		return root.ForEachBucket(func(user []byte) error {
+			size += root.Bucket(user).Stats().KeyN
+			return nil
+		})
+	})
+
+	return size, err
+}
+
+// CreatePair sets the value of a new pair in a database only if it does not exist,
+// returning false if it does.
+func CreatePair(db *bbolt.DB, user, name, pval string) (bool, error) {
+	return CreatePairValue(db, user, name, Value{Data: PairValue(pval)})
+}
+
+// CreatePairValue sets the decoded value of a new pair in a database only if it does
+// not exist, returning false if it does.
+func CreatePairValue(db *bbolt.DB, user, name string, vval Value) (bool, error) {
+	var okay = false
+
+	err := update(db, func(tx *bbolt.Tx) (err error) {
+		okay, err = createPair(tx, user, name, vval)
+		return err
+	})
+
+	return okay, err
+}
+
+// DBStats returns the page and transaction statistics of a database, with its file
+// size and the statistics of each top-level bucket, gathered in one transaction.
+func DBStats(db *bbolt.DB) (map[string]any, error) {
+	stat := db.Stats()
+	stats := map[string]any{
+		"free_pages":     stat.FreePageN,
+		"pending_pages":  stat.PendingPageN,
+		"free_alloc":     stat.FreeAlloc,
+		"freelist_inuse": stat.FreelistInuse,
+		"tx_count":       stat.TxN,
+		"open_tx_count":  stat.OpenTxN,
+	}
+
+	return stats, db.View(func(tx *bbolt.Tx) error {
+		bucks := make(map[string]any)
+		stats["size"] = tx.Size()
+		stats["buckets"] = bucks
+
+		return tx.ForEach(func(name []byte, buck *bbolt.Bucket) error {
+			stat := buck.Stats()
+			bucks[string(name)] = map[string]any{
+				"keys":           stat.KeyN,
+				"depth":          stat.Depth,
+				"branch_pages":   stat.BranchPageN,
+				"branch_inuse":   stat.BranchInuse,
+				"leaf_pages":     stat.LeafPageN,
+				"leaf_overflow":  stat.LeafOverflowN,
+				"leaf_inuse":     stat.LeafInuse,
+				"buckets":        stat.BucketN,
+				"inline_buckets": stat.InlineBucketN,
+			}
+
+			return nil
+		})
+	})
+}
+
+// DeletePair deletes an existing pair from a database, along with its user bucket
+// if no other pairs remain in it.
+func DeletePair(db *bbolt.DB, user, name string) error {
+	return update(db, func(tx *bbolt.Tx) error {
+		return deletePair(tx, user, name)
+	})
+}
+
+// DeleteUser deletes all pairs for a user from a database, returning the number of
+// pairs deleted.
+func DeleteUser(db *bbolt.DB, user string) (int, error) {
+	var size int
+	if user == "" {
+		return 0, errors.New("cannot delete empty user")
+	}
+
+	err := update(db, func(tx *bbolt.Tx) error {
+		buck := userBucket(tx, user)
+		if buck == nil {
+			size = 0
+			return nil
+		}
+
+		size = buck.Stats().KeyN
+		err := buck.ForEach(func(name, data []byte) error {
+			notifyPair(tx, user, string(name))
+			if err := indexPair(tx, user, string(name), data, false); err != nil {
+				return err
+			}
+
+			return LogEvent(tx, "delete", user, string(name))
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if usage := tx.Bucket(usageName()); usage != nil {
+			if err := usage.Delete(NameKey(user)); err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket(rootName()).DeleteBucket(NameKey(user))
+	})
+
+	return size, err
+}
+
+// ExpandValue returns a value string for a user with each "${user:name}" or
+// "${name}" reference replaced by the recursively expanded value of that pair,
+// returning ErrLoop for references nested over a depth or referring to themselves,
+// or ErrMissingRef for references to pairs that do not exist or are private.
+func ExpandValue(db *bbolt.DB, user, value string, depth int) (string, error) {
+	return expandValue(db, "", user, value, depth, make(map[string]bool))
+}
+
+// ExportEvents writes all Events in a database after a sequence number to a Writer as
+// newline-delimited JSON.
+func ExportEvents(db *bbolt.DB, after uint64, w io.Writer) error {
+	return db.View(func(tx *bbolt.Tx) error {
+		buck := tx.Bucket(eventsName())
+		if buck == nil {
+			return nil
+		}
+
+		curs := buck.Cursor()
+		for skey, data := curs.Seek(binary.BigEndian.AppendUint64(nil, after+1)); skey != nil; skey, data = curs.Next() {
+			if _, err := w.Write(append(bytes.Clone(data), '\n')); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ExportPairs writes all unexpired pairs in a database to a Writer as newline-delimited
+// JSON Records, excluding private pairs.
+func ExportPairs(db *bbolt.DB, w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	return db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(rootName())
+		if root == nil {
+			return nil
+		}
+
+		return root.ForEachBucket(func(user []byte) error {
+			return exportBucket(enc, string(user), root.Bucket(user))
+		})
+	})
+}
+
+// ExportUser writes all unexpired pairs for a user in a database to a Writer as
+// newline-delimited JSON Records, like ExportPairs.
+func ExportUser(db *bbolt.DB, user string, w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	return db.View(func(tx *bbolt.Tx) error {
+		if buck := userBucket(tx, user); buck != nil {
+			return exportBucket(enc, user, buck)
+		}
+
+		return nil
+	})
+}
+
+// FindPairs returns the users and names of up to a limit of unexpired pairs in a
+// database whose values begin with a prefix, looked up in the value index and checked
+// against their current values, excluding private pairs. A limit of zero or less
+// returns all pairs.
+func FindPairs(db *bbolt.DB, prefix string, limit int) ([]Record, error) {
+	var recs []Record
+	pref := []byte(prefix)
+	seek := pref[:min(len(pref), IndexPrefix)]
+
+	err := db.View(func(tx *bbolt.Tx) error {
+		index := tx.Bucket(indexName())
+		if index == nil {
+			return nil
+		}
+
+		curs := index.Cursor()
+		for ikey, _ := curs.Seek(seek); ikey != nil && bytes.HasPrefix(ikey, seek); ikey, _ = curs.Next() {
+			size := int(binary.BigEndian.Uint16(ikey[len(ikey)-2:]))
+			pkey := ikey[len(ikey)-2-size : len(ikey)-2]
+			user, name, _ := strings.Cut(string(pkey), KeySep)
+			buck := userBucket(tx, user)
+			if buck == nil || IsPrivate(user) || IsPrivate(name) {
+				continue
+			}
+
+			vval := DecodeValue(buck.Get(NameKey(name)))
+			if plain, err := GunzipValue(vval); err == nil {
+				vval = plain
+			}
+
+			if vval.Expired() || !bytes.HasPrefix(vval.Data, pref) {
+				continue
+			}
+
+			recs = append(recs, Record{User: user, Name: name})
+			if limit > 0 && len(recs) == limit {
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	return recs, err
+}
+
+// FollowPair fetches the value of a pair from Upstream and stores it in a database to
+// expire after UpstreamTTL, returning the stored Value and a boolean indicating if the
+// pair exists upstream.
+func FollowPair(ctx context.Context, db *bbolt.DB, user, name string) (Value, bool, error) {
+	addr := strings.TrimSuffix(Upstream, "/") + "/" + EscapeName(user) + "/" + EscapeName(name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr, nil)
+	if err != nil {
+		return Value{}, false, err
+	}
+
+	req.Header.Set("Accept", "*/*")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Value{}, false, err
+	}
+
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return Value{}, false, nil
+	default:
+		return Value{}, false, fmt.Errorf("upstream returned %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(MaxValue)+1))
+	if err != nil {
+		return Value{}, false, err
+	}
+
+	vval := Value{Data: data, Expiry: time.Now().Add(UpstreamTTL)}
+	mtype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mtype {
+	case "", "text/plain":
+	case "application/octet-stream":
+		vval.Raw = true
+	default:
+		vval.Type = resp.Header.Get("Content-Type")
+	}
+
+	return vval, true, SetPairValue(db, user, name, vval)
+}
+
+// GetPair returns the value of an existing pair from a database, decompressed if it is
+// a gzip value, and a boolean indicating if the pair exists. Expired pairs do not
+// exist and are deleted, unless the database is read-only.
+func GetPair(db *bbolt.DB, user, name string) (string, bool, error) {
+	vval, okay, err := GetPairValue(db, user, name)
+	if err == nil {
+		vval, err = GunzipValue(vval)
+	}
+
+	return string(vval.Data), okay, err
+}
+
+// GetPairContext returns the value of an existing pair from a database like GetPair,
+// or the Context's error if it is done first.
+func GetPairContext(ctx context.Context, db *bbolt.DB, user, name string) (string, bool, error) {
+	vval, okay, err := GetPairValueContext(ctx, db, user, name)
+	if err == nil {
+		vval, err = GunzipValue(vval)
+	}
+
+	return string(vval.Data), okay, err
+}
+
+// GetPairValue returns the decoded Value of an existing pair from a database, still
+// compressed if it is a gzip value, and a boolean indicating if the pair exists, with
+// the same expiry rules as GetPair. Pairs missing from a running Bloom are not looked
+// up, while false positives fall through to the database as normal.
+func GetPairValue(db *bbolt.DB, user, name string) (Value, bool, error) {
+	var vval Value
+	var okay = false
+	if bloom, ok := Blooms.Load(db); ok && !bloom.(*Bloom).Has(PairKey(user, name)) {
+		return vval, false, nil
+	}
+
+	err := db.View(func(tx *bbolt.Tx) error {
+		if buck := userBucket(tx, user); buck != nil {
+			bytes := buck.Get(NameKey(name))
+			vval = DecodeValue(bytes)
+			okay = bytes != nil
+		}
+
+		return nil
+	})
+
+	switch {
+	case err != nil || !okay || !vval.Expired():
+		return vval, okay, err
+	case db.IsReadOnly():
+		return Value{}, false, nil
+	}
+
+	return Value{}, false, update(db, func(tx *bbolt.Tx) error {
+		if buck := userBucket(tx, user); buck != nil {
+			if DecodeValue(buck.Get(NameKey(name))).Expired() {
+				return deletePair(tx, user, name)
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetPairValueContext returns the decoded value of an existing pair from a database
+// like GetPairValue, or the Context's error if it is done first.
+func GetPairValueContext(ctx context.Context, db *bbolt.DB, user, name string) (Value, bool, error) {
+	var vval Value
+	var okay bool
+
+	err := waitContext(ctx, func() (err error) {
+		vval, okay, err = GetPairValue(db, user, name)
+		return err
+	})
+
+	if err != nil {
+		return Value{}, false, err
+	}
+
+	return vval, okay, nil
+}
+
+// GetPairs returns the values of multiple pairs for a user from a database in a single
+// transaction, with nil values for missing or expired pairs.
+func GetPairs(db *bbolt.DB, user string, names []string) (map[string]*string, error) {
+	pairs := make(map[string]*string, len(names))
+
+	return pairs, db.View(func(tx *bbolt.Tx) error {
+		buck := userBucket(tx, user)
+		for _, name := range names {
+			pairs[name] = nil
+			if buck == nil {
+				continue
+			}
+
+			if data := buck.Get(NameKey(name)); data != nil {
+				vval, err := GunzipValue(DecodeValue(data))
+				if err != nil {
+					return err
+				}
+
+				if !vval.Expired() {
+					pval := string(vval.Data)
+					pairs[name] = &pval
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetSetPair sets the value of a new or existing pair in a database and returns its
+// previous value and a boolean indicating if it existed, in a single transaction.
+// Expired pairs do not exist.
+func GetSetPair(db *bbolt.DB, user, name, newValue string) (string, bool, error) {
+	var prev string
+	var okay bool
+
+	err := update(db, func(tx *bbolt.Tx) error {
+		prev, okay = "", false
+		if buck := userBucket(tx, user); buck != nil {
+			if data := buck.Get(NameKey(name)); data != nil && !DecodeValue(data).Expired() {
+				vval, err := GunzipValue(DecodeValue(data))
+				if err != nil {
+					return err
+				}
+
+				prev, okay = string(vval.Data), true
+			}
+		}
+
+		return putPair(tx, user, name, Value{Data: PairValue(newValue)})
+	})
+
+	if err != nil {
+		return "", false, err
+	}
+
+	return prev, okay, nil
+}
+
+// ImportPairs writes newline-delimited JSON Records from a Reader into a database in
+// transactions of BatchSize pairs, returning the number of pairs imported and the
+// number of malformed or stale lines skipped. Records with a modification time are
+// merged as in MergePair, so fresher existing values are kept. A private pair rolls
+// back its batch and stops the import with ErrPrivate.
+func ImportPairs(db *bbolt.DB, r io.Reader) (int, int, error) {
+	return importPairs(db, r, func(tx *bbolt.Tx, rec Record) (bool, error) {
+		if IsPrivate(rec.User) || IsPrivate(rec.Name) {
+			return false, fmt.Errorf("%w: %s", ErrPrivate, PairKey(rec.User, rec.Name))
+		}
+
+		vval := Value{Data: PairValue(rec.Value)}
+		if rec.Modified == 0 {
+			return true, putPair(tx, rec.User, rec.Name, vval)
+		}
+
+		vval.Modified = time.Unix(0, rec.Modified)
+		return mergePair(tx, rec.User, rec.Name, vval)
+	})
+}
+
+// IncrPair adds a delta to the integer value of a new or existing pair in a database,
+// treating missing or empty values as zero, and returns the new integer or ErrOverflow
+// if it would be out of int64 range.
+func IncrPair(db *bbolt.DB, user, name string, delta int64) (int64, error) {
+	var numb int64
+
+	err := update(db, func(tx *bbolt.Tx) error {
+		var vval Value
+		var curr int64
+		if buck := userBucket(tx, user); buck != nil {
+			vval = DecodeValue(buck.Get(NameKey(name)))
+		}
+
+		if vval.Expired() {
+			vval = Value{}
+		}
+
+		vval, err := GunzipValue(vval)
+		if err != nil {
+			return err
+		}
+
+		if text := strings.TrimSpace(string(vval.Data)); text != "" {
+			var err error
+			if curr, err = strconv.ParseInt(text, 10, 64); err != nil {
+				return ErrNotInteger
+			}
+		}
+
+		if delta > 0 && curr > math.MaxInt64-delta || delta < 0 && curr < math.MinInt64-delta {
+			return ErrOverflow
+		}
+
+		numb = curr + delta
+		vval.Data = PairValue(strconv.FormatInt(numb, 10))
+		vval.Modified = time.Time{}
+		return putPair(tx, user, name, vval)
+	})
+
+	return numb, err
+}
+
+// InitBucket creates the root bucket in a database, returning false if it already
+// exists.
+func InitBucket(db *bbolt.DB) (bool, error) {
+	var okay = false
+
+	err := update(db, func(tx *bbolt.Tx) error {
+		okay = tx.Bucket(rootName()) == nil
+		_, err := tx.CreateBucketIfNotExists(rootName())
+		return err
+	})
+
+	return okay, err
+}
+
+// ListPairs returns the names of up to a limit of unexpired public pairs for a user in
+// a database after a name, and the next name to list after or an empty string. A
+// limit of zero or less returns all names.
+func ListPairs(db *bbolt.DB, user, after string, limit int) ([]string, string, error) {
+	return SearchPairs(db, user, "", after, limit)
+}
+
+// ListUsers returns the sorted names of all public users with pairs in a database.
+// This scans every user bucket name but no pairs, so it is cheap enough to run
+// uncached.
+func ListUsers(db *bbolt.DB) ([]string, error) {
+	var users []string
+
+	err := db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(rootName())
+		if root == nil {
+			return nil
+		}
+
+		return root.ForEachBucket(func(user []byte) error {
+			if !IsPrivate(string(user)) {
+				users = append(users, string(user))
+			}
+
+			return nil
+		})
+	})
+
+	return users, err
+}
+
+// LogEvent appends an Event for a set or delete of a pair to the events bucket in a
+// transaction if EventLog is set, so it commits or rolls back with the change, and
+// sends it to Hook once the transaction commits if Hook is set.
+func LogEvent(tx *bbolt.Tx, op, user, name string) error {
+	evnt := Event{0, time.Now().UnixNano(), op, string(NameKey(user)), string(NameKey(name))}
+	if EventLog {
+		buck, err := tx.CreateBucketIfNotExists(eventsName())
+		if err != nil {
+			return err
+		}
+
+		seq, err := buck.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		evnt.Seq = seq
+		data, err := json.Marshal(evnt)
+		if err != nil {
+			return err
+		}
+
+		if err := buck.Put(binary.BigEndian.AppendUint64(nil, seq), data); err != nil {
+			return err
+		}
+	}
+
+	if hook := Hook; hook != nil {
+		tx.OnCommit(func() { hook.Send(evnt) })
+	}
+
+	return nil
+}
+
+// MergePair sets the value of a new or existing pair in a database only if a
+// timestamp in Unix nanoseconds is newer than the modification time of its current
+// value, so the most recent write wins.
+func MergePair(db *bbolt.DB, user, name, pval string, ts int64) error {
+	return update(db, func(tx *bbolt.Tx) error {
+		_, err := mergePair(tx, user, name, Value{Data: PairValue(pval), Modified: time.Unix(0, ts)})
+		return err
+	})
+}
+
+// MigrateToBuckets moves all pairs stored under flat "user:name" keys, separated by
+// KeySep, in a database into nested user buckets, returning the number of pairs moved.
+func MigrateToBuckets(db *bbolt.DB) (int, error) {
+	var size int
+
+	return size, db.Update(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(rootName())
+		if root == nil {
+			return nil
+		}
+
+		var pkeys, pvals [][]byte
+		root.ForEach(func(pkey, pval []byte) error {
+			if pval != nil && bytes.Contains(pkey, []byte(KeySep)) {
+				pkeys = append(pkeys, bytes.Clone(pkey))
+				pvals = append(pvals, bytes.Clone(pval))
+			}
+
+			return nil
+		})
+
+		for i, pkey := range pkeys {
+			user, name, _ := bytes.Cut(pkey, []byte(KeySep))
+			buck, err := root.CreateBucketIfNotExists(user)
+			if err != nil {
+				return err
+			}
+
+			if err := buck.Put(name, pvals[i]); err != nil {
+				return err
+			}
+
+			if err := root.Delete(pkey); err != nil {
+				return err
+			}
+
+			size++
+		}
+
+		for _, name := range [][]byte{usageName(), indexName()} {
+			if size != 0 && tx.Bucket(name) != nil {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// MigrateVersion copies all unexpired pairs in a database from the root bucket of one
+// API version to the root bucket of another, decompressed and passed through a
+// transform function if it is not nil, replacing any existing pairs, and returns the
+// number of pairs copied. The destination usage counters and value index are deleted
+// so they are rebuilt for the copied pairs.
+func MigrateVersion(db *bbolt.DB, from, to string, transform func([]byte) []byte) (int, error) {
+	var size int
+	if from == to {
+		return 0, fmt.Errorf("cannot migrate version %q to itself", from)
+	}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		size = 0
+		src := tx.Bucket(versionName(from))
+		if src == nil {
+			return nil
+		}
+
+		dest, err := tx.CreateBucketIfNotExists(versionName(to))
+		if err != nil {
+			return err
+		}
+
+		err = src.ForEachBucket(func(user []byte) error {
+			buck, err := dest.CreateBucketIfNotExists(user)
+			if err != nil {
+				return err
+			}
+
+			return src.Bucket(user).ForEach(func(name, data []byte) error {
+				vval, err := GunzipValue(DecodeValue(data))
+				if err != nil || vval.Expired() {
+					return err
+				}
+
+				vval.Gzip = false
+				if transform != nil {
+					vval.Data = transform(bytes.Clone(vval.Data))
+				}
+
+				size++
+				return buck.Put(name, EncodeValue(vval))
+			})
+		})
+
+		if err != nil {
+			return err
+		}
+
+		for _, kind := range []string{"usage", "index"} {
+			name := []byte(string(versionName(to)) + KeySep + kind)
+			if tx.Bucket(name) != nil {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return size, err
+}
+
+// NewBackups starts and returns a Backups for a database, or for DB as it is reloaded
+// if nil, writing a snapshot into a directory after every interval and keeping up to a
+// number of the newest snapshots.
+func NewBackups(db *bbolt.DB, dir string, interval time.Duration, keep int) *Backups {
+	backups := &Backups{db, dir, keep, make(chan struct{}), make(chan struct{})}
+	go backups.run(interval)
+	return backups
+}
+
+// NewBloom returns a running Bloom for a database, sized for twice its current pairs
+// and filled from a scan of their keys, which must finish before the database is
+// served.
+func NewBloom(db *bbolt.DB) (*Bloom, error) {
+	size, err := CountKeys(db)
+	if err != nil {
+		return nil, err
+	}
+
+	bits := BloomBits * max(2*size, 1024)
+	bloom := &Bloom{db, make([]atomic.Uint64, (bits+63)/64)}
+	Blooms.Store(db, bloom)
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(rootName())
+		if root == nil {
+			return nil
+		}
+
+		return root.ForEachBucket(func(user []byte) error {
+			return root.Bucket(user).ForEach(func(name, _ []byte) error {
+				bloom.Add(PairKey(string(user), string(name)))
+				return nil
+			})
+		})
+	})
+
+	if err != nil {
+		bloom.Close()
+		return nil, err
+	}
+
+	return bloom, nil
+}
+
+// NewQueue starts and returns a Queue for a database, applying batches of up to a
+// size of writes after waiting up to a delay for each batch to fill.
+func NewQueue(db *bbolt.DB, size int, delay time.Duration) *Queue {
+	queue := &Queue{db, make(chan writeRequest, size), make(chan struct{})}
+	go queue.run(size, delay)
+	Queues.Store(db, queue)
+	return queue
+}
+
+// NewSweeper starts and returns a Sweeper for a database, or for DB as it is reloaded
+// if nil, deleting its expired pairs after every interval.
+func NewSweeper(db *bbolt.DB, interval time.Duration) *Sweeper {
+	sweeper := &Sweeper{db, make(chan struct{}), make(chan struct{})}
+	go sweeper.run(interval)
+	return sweeper
+}
+
+// NewWebhook starts and returns a Webhook posting to a URL, holding up to a size of
+// Events waiting for delivery.
+func NewWebhook(url string, size int) *Webhook {
+	ctx, stop := context.WithCancel(context.Background())
+	hook := &Webhook{url, make(chan Event, size), ctx, stop, make(chan struct{})}
+	go hook.run()
+	return hook
+}
+
+// OpenDB returns a database connection for a path, opened read-only if ReadOnly is
+// set. A MemoryPath database is a writable temporary file that is deleted on open and
+// freed on close.
+func OpenDB(path string) (*bbolt.DB, error) {
+	return openDB(path, &bbolt.Options{ReadOnly: ReadOnly})
+}
+
+// PatchPair applies a JSON merge patch to the value of an existing pair in a database,
+// returning false if it does not exist, or ErrNotJSON if its value is not valid JSON.
+func PatchPair(db *bbolt.DB, user, name string, patch []byte) (bool, error) {
+	var okay = false
+
+	err := update(db, func(tx *bbolt.Tx) error {
+		okay = false
+		buck := userBucket(tx, user)
+		if buck == nil {
+			return nil
+		}
+
+		data := buck.Get(NameKey(name))
+		vval := DecodeValue(data)
+		if data == nil || vval.Expired() {
+			return nil
+		}
+
+		okay = true
+		vval, err := GunzipValue(vval)
+		if err != nil {
+			return err
+		}
+
+		text, err := MergePatch(vval.Data, patch)
+		if err != nil {
+			return err
+		}
+
+		if !vval.Raw {
+			text = PairValue(string(text))
+		}
+
+		vval.Data = text
+		vval.Modified = time.Time{}
+		return putPair(tx, user, name, vval)
+	})
+
+	return okay, err
+}
+
+// PopPair deletes an existing pair from a database and returns its value and a
+// boolean indicating if it existed, in a single transaction. Expired pairs do not
+// exist.
+func PopPair(db *bbolt.DB, user, name string) (string, bool, error) {
+	var pval string
+	var okay bool
+
+	err := update(db, func(tx *bbolt.Tx) error {
+		pval, okay = "", false
+		buck := userBucket(tx, user)
+		if buck == nil {
+			return nil
+		}
+
+		data := buck.Get(NameKey(name))
+		if data == nil || DecodeValue(data).Expired() {
+			return nil
+		}
+
+		vval, err := GunzipValue(DecodeValue(data))
+		if err != nil {
+			return err
+		}
+
+		pval, okay = string(vval.Data), true
+		return deletePair(tx, user, name)
+	})
+
+	if err != nil {
+		return "", false, err
+	}
+
+	return pval, okay, nil
+}
+
+// PruneBackups deletes all but a number of the newest snapshots of a database path
+// from a directory, or none if the number is not positive.
+func PruneBackups(dir, path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	elems, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	for _, elem := range elems {
+		stamp, ok := strings.CutPrefix(elem.Name(), base+"-")
+		stamp, ok2 := strings.CutSuffix(stamp, ".db")
+		if _, err := time.Parse(backupStamp, stamp); ok && ok2 && err == nil && !elem.IsDir() {
+			names = append(names, elem.Name())
+		}
+	}
+
+	for _, name := range names[:max(len(names)-keep, 0)] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RenamePair moves an existing pair for a user in a database to a new name within a
+// single transaction, returning false if it does not exist, or ErrPairExists if the
+// new name exists and overwrite is not set.
+func RenamePair(db *bbolt.DB, user, oldName, newName string, overwrite bool) (bool, error) {
+	var okay = false
+
+	err := update(db, func(tx *bbolt.Tx) error {
+		buck := userBucket(tx, user)
+		if buck == nil {
+			return nil
+		}
+
+		data := buck.Get(NameKey(oldName))
+		if data == nil || DecodeValue(data).Expired() {
+			return nil
+		}
+
+		okay = true
+		if bytes.Equal(NameKey(oldName), NameKey(newName)) {
+			return nil
+		}
+
+		dest := buck.Get(NameKey(newName))
+		if dest != nil && !DecodeValue(dest).Expired() && !overwrite {
+			return ErrPairExists
+		}
+
+		if dest != nil {
+			if err := addUsage(tx, user, -1, -len(dest)); err != nil {
+				return err
+			}
+
+			if err := indexPair(tx, user, newName, dest, false); err != nil {
+				return err
+			}
+		}
+
+		if err := indexPair(tx, user, oldName, data, false); err != nil {
+			return err
+		}
+
+		if err := indexPair(tx, user, newName, data, true); err != nil {
+			return err
+		}
+
+		if err := LogEvent(tx, "set", user, newName); err != nil {
+			return err
+		}
+
+		if err := LogEvent(tx, "delete", user, oldName); err != nil {
+			return err
+		}
+
+		markPair(tx, user, newName)
+		if err := buck.Put(NameKey(newName), bytes.Clone(data)); err != nil {
+			return err
+		}
+
+		notifyPair(tx, user, oldName)
+		notifyPair(tx, user, newName)
+		return buck.Delete(NameKey(oldName))
+	})
+
+	return okay, err
+}
+
+// ReopenDB closes DB and opens and starts a database at a path in its place, or
+// reopens the previous path if the new one fails. Callers must hold reloadMutex for
+// writing.
+func ReopenDB(path string) error {
+	prev := DB.Path()
+	if err := stopDB(DB); err != nil {
+		return err
+	}
+
+	db, err := OpenDB(path)
+	if err != nil {
+		if db, perr := OpenDB(prev); perr == nil {
+			DB = db
+			return errors.Join(err, startDB(db))
+		}
+
+		return err
+	}
+
+	DB = db
+	return startDB(db)
+}
+
+// SearchPairs returns the names of up to a limit of unexpired public pairs for a user
+// in a database that begin with a prefix after a name, and the next name to search
+// after or an empty string. A limit of zero or less returns all names.
+func SearchPairs(db *bbolt.DB, user, prefix, after string, limit int) ([]string, string, error) {
+	var names []string
+	var next string
+	pref := NameKey(prefix)
+	seek := pref
+	if akey := NameKey(after); bytes.Compare(akey, seek) > 0 {
+		seek = akey
+	}
+
+	err := db.View(func(tx *bbolt.Tx) error {
+		buck := userBucket(tx, user)
+		if buck == nil {
+			return nil
+		}
+
+		curs := buck.Cursor()
+		for name, data := curs.Seek(seek); name != nil && bytes.HasPrefix(name, pref); name, data = curs.Next() {
+			switch {
+			case after != "" && bytes.Equal(name, NameKey(after)):
+				continue
+			case DecodeValue(data).Expired() || IsPrivate(string(name)):
+				continue
+			case limit > 0 && len(names) == limit:
+				next = names[len(names)-1]
+				return nil
+			}
+
+			names = append(names, string(name))
+		}
+
+		return nil
+	})
+
+	return names, next, err
+}
+
+// SearchPairsContext returns the names of pairs for a user in a database that begin
+// with a prefix like SearchPairs, or the Context's error if it is done first.
+func SearchPairsContext(ctx context.Context, db *bbolt.DB, user, prefix, after string, limit int) ([]string, string, error) {
+	var names []string
+	var next string
+
+	err := waitContext(ctx, func() (err error) {
+		names, next, err = SearchPairs(db, user, prefix, after, limit)
+		return err
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return names, next, nil
+}
+
+// SeedPairs writes newline-delimited JSON Records from a Reader into a database like
+// ImportPairs, but only for pairs that do not exist, returning the number of pairs
+// seeded and the number of malformed lines and existing pairs skipped.
+func SeedPairs(db *bbolt.DB, r io.Reader) (int, int, error) {
+	return importPairs(db, r, func(tx *bbolt.Tx, rec Record) (bool, error) {
+		return createPair(tx, rec.User, rec.Name, Value{Data: PairValue(rec.Value)})
+	})
+}
+
+// SetPair sets the value of a new or existing pair in a database.
+func SetPair(db *bbolt.DB, user, name, pval string) error {
+	return SetPairTTL(db, user, name, pval, 0)
+}
+
+// SetPairRaw sets the verbatim, untrimmed value of a new or existing pair in a
+// database.
+func SetPairRaw(db *bbolt.DB, user, name string, data []byte) error {
+	return SetPairValue(db, user, name, Value{Data: data, Raw: true})
+}
+
+// SetPairGzip sets the gzip-compressed value of a new or existing pair in a database,
+// stored compressed and decompressed on read, or returns ErrNotGzip if the data is
+// not valid gzip.
+func SetPairGzip(db *bbolt.DB, user, name string, data []byte) error {
+	return SetPairValue(db, user, name, Value{Data: data, Gzip: true})
+}
+
+// SetPairs sets the values of multiple new or existing pairs for a user in a
+// database within a single transaction.
+func SetPairs(db *bbolt.DB, user string, pairs map[string]string) error {
+	return update(db, func(tx *bbolt.Tx) error {
+		for name, pval := range pairs {
+			if err := putPair(tx, user, name, Value{Data: PairValue(pval)}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// SetPairTTL sets the value of a new or existing pair in a database that expires
+// after a duration, or never if the duration is zero.
+func SetPairTTL(db *bbolt.DB, user, name, pval string, ttl time.Duration) error {
+	vval := Value{Data: PairValue(pval)}
+	if ttl != 0 {
+		vval.Expiry = time.Now().Add(ttl)
+	}
+
+	return SetPairValue(db, user, name, vval)
+}
+
+// SetPairValue sets the decoded value of a new or existing pair in a database.
+func SetPairValue(db *bbolt.DB, user, name string, vval Value) error {
+	return update(db, func(tx *bbolt.Tx) error {
+		return putPair(tx, user, name, vval)
+	})
+}
+
+// ShrinkDB compacts DB into a new file, swaps it in place of the old file and reopens
+// it with ReopenDB, returning the file sizes before and after.
+func ShrinkDB() (int64, int64, error) {
+	path := DB.Path()
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	file.Close()
+	defer os.Remove(file.Name())
+	before, after, err := CompactDB(DB, file.Name())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return 0, 0, err
+	}
+
+	return before, after, ReopenDB(path)
+}
+
+// SnapshotDB writes a timestamped snapshot of a database into a directory, returning
+// the snapshot's path.
+func SnapshotDB(db *bbolt.DB, dir string) (string, error) {
+	dest := filepath.Join(dir, backupName(db.Path(), time.Now()))
+	return dest, BackupFile(db, dest)
+}
+
+// SwapPair sets the value of an existing pair in a database only if its current value
+// equals an old value, returning true if the pair was set.
+func SwapPair(db *bbolt.DB, user, name, oldVal, newVal string) (bool, error) {
+	return SwapPairValue(db, user, name, oldVal, Value{Data: PairValue(newVal)})
+}
+
+// SwapPairFunc sets the decoded value of an existing pair in a database only if a
+// function returns true for its current stored Value, returning true if the pair was
+// set.
+func SwapPairFunc(db *bbolt.DB, user, name string, match func(Value) bool, newVal Value) (bool, error) {
+	var okay = false
+
+	err := update(db, func(tx *bbolt.Tx) error {
+		okay = false
+		buck := userBucket(tx, user)
+		if buck == nil {
+			return nil
+		}
+
+		data := buck.Get(NameKey(name))
+		vval := DecodeValue(data)
+		okay = data != nil && !vval.Expired() && match(vval)
+		if !okay {
+			return nil
+		}
+
+		return putPair(tx, user, name, newVal)
+	})
+
+	return okay, err
+}
+
+// SwapPairValue sets the decoded value of an existing pair in a database only if its
+// current value equals an old value, returning true if the pair was set.
+func SwapPairValue(db *bbolt.DB, user, name, oldVal string, newVal Value) (bool, error) {
+	return SwapPairFunc(db, user, name, func(vval Value) bool {
+		plain, err := GunzipValue(vval)
+		return err == nil && bytes.Equal(plain.Data, PairValue(oldVal))
+	}, newVal)
+}
+
+// SweepPairs deletes all expired pairs in a database, returning the number of pairs
+// deleted. Expired pairs are found in read transactions and deleted in write
+// transactions of up to BatchSize pairs, so other writes are never held up for long.
+func SweepPairs(db *bbolt.DB) (int, error) {
+	var size int
+	var last Record
+
+	for {
+		var recs []Record
+		err := db.View(func(tx *bbolt.Tx) error {
+			root := tx.Bucket(rootName())
+			if root == nil {
+				return nil
+			}
+
+			ucur := root.Cursor()
+			for user, _ := ucur.Seek([]byte(last.User)); user != nil; user, _ = ucur.Next() {
+				buck := root.Bucket(user)
+				if buck == nil {
+					continue
+				}
+
+				curs := buck.Cursor()
+				name, data := curs.First()
+				if string(user) == last.User && last.Name != "" {
+					if name, data = curs.Seek([]byte(last.Name)); string(name) == last.Name {
+						name, data = curs.Next()
+					}
+				}
+
+				for ; name != nil; name, data = curs.Next() {
+					if DecodeValue(data).Expired() {
+						recs = append(recs, Record{User: string(user), Name: string(name)})
+						if len(recs) == BatchSize {
+							return nil
+						}
+					}
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil || len(recs) == 0 {
+			return size, err
+		}
+
+		var done int
+		err = update(db, func(tx *bbolt.Tx) error {
+			done = 0
+			for _, rec := range recs {
+				buck := userBucket(tx, rec.User)
+				if buck == nil || !DecodeValue(buck.Get(NameKey(rec.Name))).Expired() {
+					continue
+				}
+
+				if err := deletePair(tx, rec.User, rec.Name); err != nil {
+					return err
+				}
+
+				done++
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return size, err
+		}
+
+		size += done
+		last = recs[len(recs)-1]
+		if len(recs) < BatchSize {
+			return size, nil
+		}
+	}
+}
+
+// SyncIndex builds the value index of a database from all its pairs if IndexValues is
+// set and it has no index, or deletes its index if IndexValues is not set, so an index
+// missing writes made without IndexValues is never used. It returns the number of
+// pairs indexed.
+func SyncIndex(db *bbolt.DB) (int, error) {
+	var size int
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		exists := tx.Bucket(indexName()) != nil
+		switch {
+		case !IndexValues && exists:
+			return tx.DeleteBucket(indexName())
+		case !IndexValues || exists:
+			return nil
+		}
+
+		if _, err := tx.CreateBucket(indexName()); err != nil {
+			return err
+		}
+
+		root := tx.Bucket(rootName())
+		if root == nil {
+			return nil
+		}
+
+		return root.ForEachBucket(func(user []byte) error {
+			return root.Bucket(user).ForEach(func(name, data []byte) error {
+				size++
+				return indexPair(tx, string(user), string(name), data, true)
+			})
+		})
+	})
+
+	return size, err
+}
+
+// TouchPair sets the expiry of an existing pair in a database to a duration from now
+// without changing its value, or removes its expiry if the duration is zero, returning
+// false if it does not exist.
+func TouchPair(db *bbolt.DB, user, name string, ttl time.Duration) (bool, error) {
+	var okay = false
+
+	err := update(db, func(tx *bbolt.Tx) error {
+		okay = false
+		buck := userBucket(tx, user)
+		if buck == nil {
+			return nil
+		}
+
+		data := buck.Get(NameKey(name))
+		vval := DecodeValue(data)
+		if data == nil || vval.Expired() {
+			return nil
+		}
+
+		okay = true
+		vval.Data = bytes.Clone(vval.Data)
+		vval.Expiry = time.Time{}
+		if ttl != 0 {
+			vval.Expiry = time.Now().Add(ttl)
+		}
+
+		return putPair(tx, user, name, vval)
+	})
+
+	return okay, err
+}
+
+// UserUsage returns the number of pairs and stored bytes for a user in a database,
+// including expired pairs that have not been deleted yet.
+func UserUsage(db *bbolt.DB, user string) (Usage, error) {
+	var usage Usage
+	err := db.View(func(tx *bbolt.Tx) error {
+		usage = getUsage(tx, user)
+		return nil
+	})
+
+	return usage, err
+}
+
+// WatchPair returns a channel that is closed the next time a pair is set or deleted
+// in any database, and a function to call once the channel is no longer waited on, so
+// the pair is removed from Watchers when it has no other callers.
+func WatchPair(user, name string) (<-chan struct{}, func()) {
+	pkey := string(PairKey(user, name))
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+
+	item, _ := Watchers.LoadOrStore(pkey, &watcher{wait: make(chan struct{})})
+	wtch := item.(*watcher)
+	wtch.refs++
+	return wtch.wait, sync.OnceFunc(func() {
+		watchMutex.Lock()
+		defer watchMutex.Unlock()
+
+		if wtch.refs--; wtch.refs == 0 {
+			Watchers.CompareAndDelete(pkey, wtch)
+		}
+	})
+}
+
+///////////////////////////////////////////////////////////////////////////////////////
+//                        part five · http response functions                        //
+///////////////////////////////////////////////////////////////////////////////////////
+
+// flushWriter is a Writer that flushes a ResponseWriter after every write.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+// Write writes bytes to the flushWriter's ResponseWriter and flushes it.
+func (fw flushWriter) Write(bytes []byte) (int, error) {
+	size, err := fw.w.Write(bytes)
+	if err == nil {
+		err = http.NewResponseController(fw.w).Flush()
+	}
+
+	return size, err
+}
+
+// AcceptsGzip returns true if a Request accepts gzip-encoded responses.
+func AcceptsGzip(r *http.Request) bool {
+	for _, code := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		code, prms, _ := strings.Cut(code, ";")
+		if strings.TrimSpace(code) == "gzip" && strings.TrimSpace(prms) != "q=0" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientAddr returns the host address of a Request's client, taken from the last
+// X-Forwarded-For entry if TrustProxy is set.
+func ClientAddr(r *http.Request) string {
+	if fwds := r.Header.Get("X-Forwarded-For"); TrustProxy && fwds != "" {
+		elems := strings.Split(fwds, ",")
+		return strings.TrimSpace(elems[len(elems)-1])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// SendsGzip returns true if a Request's body is compressed with gzip, to store as a
+// gzip value.
+func SendsGzip(r *http.Request) bool {
+	return strings.EqualFold(strings.TrimSpace(r.Header.Get("Content-Encoding")), "gzip")
+}
+
+// SendsJSON returns true if a Request's body is a JSON document.
+func SendsJSON(r *http.Request) bool {
+	mime, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	return strings.EqualFold(strings.TrimSpace(mime), "application/json")
+}
+
+// SendsRaw returns true if a Request's body is raw binary data to store verbatim.
+func SendsRaw(r *http.Request) bool {
+	mime, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	return strings.TrimSpace(mime) == "application/octet-stream"
+}
+
+// ValueType returns the normalised media type of a Request's body to store with its
+// value, or an empty string for plain text, form, raw binary or invalid types.
+func ValueType(r *http.Request) string {
+	mtype, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.Contains(mtype, "/") {
+		return ""
+	}
+
+	switch mtype {
+	case "text/plain", "application/x-www-form-urlencoded", "application/octet-stream":
+		return ""
+	}
+
+	if ctype := mime.FormatMediaType(mtype, params); len(ctype) <= 255 {
+		return ctype
+	}
+
+	return ""
+}
+
+// WantsJSON returns true if a Request accepts JSON responses.
+func WantsJSON(r *http.Request) bool {
+	for _, mime := range strings.Split(r.Header.Get("Accept"), ",") {
+		mime, _, _ = strings.Cut(mime, ";")
+		if strings.TrimSpace(mime) == "application/json" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WriteHTTP writes a plaintext or JSON response to a ResponseWriter.
+func WriteHTTP(w http.ResponseWriter, code int, form string, elems ...any) {
+	if _, ok := w.(jsonWriter); ok {
+		WriteJSON(w, code, map[string]any{"value": fmt.Sprintf(form, elems...)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, form+"\n", elems...)
+}
+
+// WriteJSON writes a JSON response to a ResponseWriter.
+func WriteJSON(w http.ResponseWriter, code int, payload any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(payload)
+}
+
+// WriteCompressed writes a plaintext response body to a ResponseWriter, compressed
+// with gzip if the Request accepts it, the body is at least MinGzip bytes long and the
+// response has no Content-Encoding already.
+func WriteCompressed(w http.ResponseWriter, r *http.Request, code int, body []byte) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	if len(body) < MinGzip || !AcceptsGzip(r) || w.Header().Get("Content-Encoding") != "" {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(code)
+		w.Write(body)
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(code)
+	gzw := gzip.NewWriter(w)
+	gzw.Write(body)
+	gzw.Close()
+}
+
+// WriteError writes a plaintext or JSON error response to a ResponseWriter.
+func WriteError(w http.ResponseWriter, code int, form string, elems ...any) {
+	if _, ok := w.(jsonWriter); ok {
+		WriteJSON(w, code, map[string]any{"error": fmt.Sprintf(form, elems...), "code": code})
+		return
+	}
+
+	form = fmt.Sprintf("server error %d: %s", code, form)
+	WriteHTTP(w, code, form, elems...)
+}
+
+// WriteFailure writes a plaintext or JSON failure response to a ResponseWriter.
+func WriteFailure(w http.ResponseWriter, code int, form string, elems ...any) {
+	if _, ok := w.(jsonWriter); ok {
+		WriteJSON(w, code, map[string]any{"error": fmt.Sprintf(form, elems...), "code": code})
+		return
+	}
+
+	form = fmt.Sprintf("client error %d: %s", code, form)
+	WriteHTTP(w, code, form, elems...)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////
+//                        part six · server endpoint functions                       //
+///////////////////////////////////////////////////////////////////////////////////////
+
+// decodeBody decodes a Request's JSON body of up to MaxBody bytes into a pointer and
+// returns true, or writes a failure response and returns false.
+func decodeBody(w http.ResponseWriter, r *http.Request, dest any) bool {
+	var mbe *http.MaxBytesError
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, int64(MaxBody))).Decode(dest)
+	switch {
+	case errors.As(err, &mbe):
+		WriteFailure(w, http.StatusRequestEntityTooLarge, "body is over limit of %d bytes", MaxBody)
+	case err != nil:
+		WriteFailure(w, http.StatusBadRequest, "invalid json: %s", err)
+	default:
+		return true
+	}
+
+	return false
+}
+
+// holdStream swaps the reloadMutex read lock held for a Request for a streamMutex read
+// lock, returning the function to release it.
+func holdStream(r *http.Request) func() {
+	releaseReload(r)
+	streamMutex.RLock()
+	return streamMutex.RUnlock
+}
+
+// lookupValue returns the Value of an existing pair, read through from Upstream if it
+// is set and the pair is missing, and true, or writes a failure or error response and
+// returns false.
+func lookupValue(w http.ResponseWriter, r *http.Request, user, name string) (Value, bool) {
+	vval, ok, err := GetPairValueContext(r.Context(), RequestDB(r), user, name)
+	if err == nil && !ok && Upstream != "" {
+		vval, ok, err = FollowPair(r.Context(), RequestDB(r), user, name)
+	}
+
+	switch {
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, "%s", err)
+	case !ok:
+		WriteFailure(w, http.StatusNotFound, "pair %s/%s does not exist", user, name)
+	}
+
+	return vval, ok && err == nil
+}
+
+// matchETag returns true if a conditional header value of "*" or comma-separated
+// entity tags weakly matches an entity tag.
+func matchETag(head, etag string) bool {
+	for _, elem := range strings.Split(head, ",") {
+		elem = strings.TrimSpace(elem)
+		if elem == "*" || strings.TrimPrefix(elem, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// putCreate sets the value of a new pair if it does not exist, for a Request with an
+// "If-None-Match: *" header.
+func putCreate(w http.ResponseWriter, r *http.Request, user, name, body string, ttl time.Duration) {
+	switch {
+	case strings.TrimSpace(r.Header.Get("If-None-Match")) != "*":
+		WriteFailure(w, http.StatusBadRequest, "If-None-Match must be *")
+		return
+	case ttl != 0:
+		WriteFailure(w, http.StatusBadRequest, "ttl cannot be used with If-None-Match")
+		return
+	case SendsRaw(r):
+		WriteFailure(w, http.StatusBadRequest, "raw values cannot be used with If-None-Match")
+		return
+	case SendsGzip(r):
+		WriteFailure(w, http.StatusBadRequest, "gzip values cannot be used with If-None-Match")
+		return
+	}
+
+	vval := Value{Data: PairValue(body), Type: ValueType(r)}
+	ok, err := CreatePairValue(RequestDB(r), user, name, vval)
+	switch {
+	case err != nil:
+		writeWriteError(w, r, err)
+	case !ok:
+		WriteFailure(w, http.StatusPreconditionFailed, "pair %s/%s already exists", user, name)
+	default:
+		WriteHTTP(w, http.StatusCreated, "Created.")
+	}
+}
+
+// putSwap sets the value of an existing pair if its current value or entity tag
+// matches the Request's "If-Match" header.
+func putSwap(w http.ResponseWriter, r *http.Request, user, name, body string, ttl time.Duration) {
+	if ttl != 0 {
+		WriteFailure(w, http.StatusBadRequest, "ttl cannot be used with If-Match")
+		return
+	}
+
+	if SendsRaw(r) {
+		WriteFailure(w, http.StatusBadRequest, "raw values cannot be used with If-Match")
+		return
+	}
+
+	if SendsGzip(r) {
+		WriteFailure(w, http.StatusBadRequest, "gzip values cannot be used with If-Match")
+		return
+	}
+
+	var ok bool
+	var err error
+	want := r.Header.Get("If-Match")
+	vval := Value{Data: PairValue(body), Type: ValueType(r)}
+	if strings.HasPrefix(want, `W/"`) || strings.HasPrefix(want, `"`) {
+		ok, err = SwapPairFunc(RequestDB(r), user, name, func(curr Value) bool {
+			return matchETag(want, PairETag(curr.Data))
+		}, vval)
+	} else {
+		ok, err = SwapPairValue(RequestDB(r), user, name, want, vval)
+	}
+
+	switch {
+	case err != nil:
+		writeWriteError(w, r, err)
+	case !ok:
+		WriteFailure(w, http.StatusPreconditionFailed, "pair %s/%s does not match", user, name)
+	default:
+		WriteHTTP(w, http.StatusOK, "Updated.")
+	}
+}
+
+// readBody returns a Request's non-empty body of up to MaxValue bytes and true, or
+// writes a failure response and returns false.
+func readBody(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var mbe *http.MaxBytesError
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(MaxValue)))
+	switch {
+	case errors.As(err, &mbe):
+		WriteFailure(w, http.StatusRequestEntityTooLarge, "body is over limit of %d bytes", MaxValue)
+	case err != nil:
+		WriteFailure(w, http.StatusBadRequest, "cannot read body: %s", err)
+	case len(body) == 0 || !SendsRaw(r) && strings.TrimSpace(string(body)) == "":
+		WriteFailure(w, http.StatusBadRequest, "body is empty")
+	default:
+		return string(body), true
+	}
+
+	return "", false
+}
+
+// readValue returns a Request's non-empty "value" query and true if it is set and the
+// body is empty, or its body as in readBody, or writes a failure response and returns
+// false.
+func readValue(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if !r.URL.Query().Has("value") {
+		return readBody(w, r)
+	}
+
+	pval := r.URL.Query().Get("value")
+	body, _ := io.ReadAll(io.LimitReader(r.Body, 1))
+	switch {
+	case len(body) != 0:
+		WriteFailure(w, http.StatusBadRequest, "body and value query cannot both be set")
+	case len(pval) > MaxValue:
+		WriteFailure(w, http.StatusRequestEntityTooLarge, "value is over limit of %d bytes", MaxValue)
+	case pval == "" || !SendsRaw(r) && strings.TrimSpace(pval) == "":
+		WriteFailure(w, http.StatusBadRequest, "value is empty")
+	default:
+		return pval, true
+	}
+
+	return "", false
+}
+
+// streamResponse removes the write deadline of a ResponseWriter, so a streamed response
+// that takes longer than WriteTimeout to send is not cut off.
+func streamResponse(w http.ResponseWriter) {
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
+
+// validJSON returns true if a body, decompressed if it is gzip, is a valid JSON
+// document, or writes a failure response and returns false. Invalid gzip is left for
+// the write to reject.
+func validJSON(w http.ResponseWriter, r *http.Request, body string) bool {
+	data := []byte(body)
+	if SendsGzip(r) {
+		vval, err := GunzipValue(Value{Data: data, Gzip: true})
+		if err != nil {
+			return true
+		}
+
+		data = vval.Data
+	}
+
+	if err := ValidateJSON(data); err != nil {
+		WriteFailure(w, http.StatusBadRequest, "%s", err)
+		return false
+	}
+
+	return true
+}
+
+// validPath returns true if all path strings are valid names and not private names
+// reserved for server metadata, or writes a failure response and returns false.
+func validPath(w http.ResponseWriter, elems ...string) bool {
+	for _, elem := range elems {
+		switch {
+		case !ValidName(elem):
+			WriteFailure(w, http.StatusBadRequest, "invalid name %q", elem)
+			return false
+		case IsPrivate(elem):
+			WriteFailure(w, http.StatusForbidden, "name %q is private", elem)
+			return false
+		}
+	}
+
+	return true
+}
+
+// writable returns true if the server is not read-only, marking the response if
+// DryRun is set, or writes a failure response and returns false.
+func writable(w http.ResponseWriter) bool {
+	if DryRun {
+		w.Header().Set("X-Dry-Run", "true")
+	}
+
+	if ReadOnly {
+		w.Header().Set("Allow", "GET, HEAD")
+		WriteFailure(w, http.StatusMethodNotAllowed, "server is read-only")
+		return false
+	}
+
+	return true
+}
+
+// writeValue writes the data of a Value in the Request's requested format, serving
+// gzip values compressed if the Request accepts gzip and decompressed otherwise.
+func writeValue(w http.ResponseWriter, r *http.Request, vval Value) {
+	if vval.Gzip && (WantsJSON(r) || !AcceptsGzip(r)) {
+		plain, err := GunzipValue(vval)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+
+		vval = plain
+	}
+
+	if vval.Gzip {
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+
+	switch {
+	case WantsJSON(r) && vval.Raw:
+		WriteHTTP(w, http.StatusOK, "%s", vval.Data)
+	case WantsJSON(r):
+		WriteHTTP(w, http.StatusOK, "%s", strings.TrimSuffix(string(vval.Data), "\n"))
+	case vval.Raw:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		WriteCompressed(w, r, http.StatusOK, vval.Data)
+	case vval.Type != "":
+		w.Header().Set("Content-Type", vval.Type)
+		WriteCompressed(w, r, http.StatusOK, vval.Data)
+	default:
+		WriteCompressed(w, r, http.StatusOK, vval.Data)
+	}
+}
+
+// writeWriteError writes the failure response for an error returned by a write: 507
+// for a full disk or exceeded quota, 413 for an oversized value, 400 for a value that
+// is not valid JSON, 503 for a missing root bucket, or 500 for anything else.
+func writeWriteError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case isDiskFull(err):
+		slog.Error("disk full", "path", RequestDB(r).Path(), "error", err)
+		WriteError(w, http.StatusInsufficientStorage, "disk is full")
+	case errors.Is(err, ErrTooLarge):
+		WriteFailure(w, http.StatusRequestEntityTooLarge, "%s", err)
+	case errors.Is(err, ErrNotJSON):
+		WriteFailure(w, http.StatusBadRequest, "%s", err)
+	case errors.Is(err, ErrNoBucket):
+		WriteError(w, http.StatusServiceUnavailable, "%s", err)
+	case errors.Is(err, ErrQuota):
+		WriteError(w, http.StatusInsufficientStorage, "%s", err)
+	default:
+		WriteError(w, http.StatusInternalServerError, "%s", err)
+	}
+}
+
+// DeleteNamespace deletes all pairs for a user.
+func DeleteNamespace(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	user := r.PathValue("user")
+	if !validPath(w, user) {
+		return
+	}
+
+	size, err := DeleteUser(RequestDB(r), user)
+	switch {
+	case err != nil:
+		writeWriteError(w, r, err)
+	default:
+		WriteHTTP(w, http.StatusOK, "%d", size)
+	}
+}
+
+// DeleteValue deletes an existing pair.
+func DeleteValue(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	user := r.PathValue("user")
+	name := r.PathValue("name")
+	if !validPath(w, user, name) {
+		return
+	}
+
+	if _, ok := lookupValue(w, r, user, name); !ok {
+		return
+	}
+
+	err := DeletePair(RequestDB(r), user, name)
+	switch {
+	case err != nil:
+		writeWriteError(w, r, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetBackup returns a consistent snapshot of the entire database file as an
+// attachment.
+func GetBackup(w http.ResponseWriter, r *http.Request) {
+	defer holdStream(r)()
+	db := RequestDB(r)
+	disp := fmt.Sprintf("attachment; filename=%q", backupName(db.Path(), time.Now()))
+	streamResponse(w)
+	err := db.View(func(tx *bbolt.Tx) error {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", disp)
+		w.Header().Set("Content-Length", strconv.FormatInt(tx.Size(), 10))
+		w.WriteHeader(http.StatusOK)
+		_, err := tx.WriteTo(w)
+		return err
+	})
+
+	if err != nil {
+		slog.Error("backup failed", "error", err)
+	}
+}
+
+// GetConfig returns the active non-secret server settings as JSON.
+func GetConfig(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, map[string]any{
+		"addr":        Addr,
+		"api_version": APIVersion,
+		"bucket":      string(rootName()),
+		"max_value":   MaxValue,
+		"read_only":   ReadOnly,
+		"version":     Version,
+	})
+}
+
+// GetCount returns the total number of pairs for all users.
+func GetCount(w http.ResponseWriter, r *http.Request) {
+	size, err := CountKeys(RequestDB(r))
+	switch {
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, "%s", err)
+	case WantsJSON(r):
+		WriteJSON(w, http.StatusOK, map[string]any{"count": size})
+	default:
+		WriteHTTP(w, http.StatusOK, "%d", size)
+	}
+}
+
+// GetEvents streams all Events after the "after" query sequence number as
+// newline-delimited JSON.
+func GetEvents(w http.ResponseWriter, r *http.Request) {
+	var after uint64
+	if text := r.URL.Query().Get("after"); text != "" {
+		seq, err := strconv.ParseUint(text, 10, 64)
+		if err != nil {
+			WriteFailure(w, http.StatusBadRequest, "invalid after %q", text)
+			return
+		}
+
+		after = seq
+	}
+
+	defer holdStream(r)()
+	streamResponse(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	if err := ExportEvents(RequestDB(r), after, flushWriter{w}); err != nil {
+		slog.Error("events failed", "error", err)
+	}
+}
+
+// GetExport streams all pairs as newline-delimited JSON.
+func GetExport(w http.ResponseWriter, r *http.Request) {
+	defer holdStream(r)()
+	streamResponse(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	if err := ExportPairs(RequestDB(r), flushWriter{w}); err != nil {
+		slog.Error("export failed", "error", err)
+	}
+}
+
+// GetFind returns the users and names of existing pairs whose values begin with the
+// "value" query string, joined by KeySep, using the value index and limited by an
+// optional "limit" query string. Plain text users and names are escaped with
+// EscapeName.
+func GetFind(w http.ResponseWriter, r *http.Request) {
+	var limit = MaxList
+	if !IndexValues {
+		WriteFailure(w, http.StatusNotFound, "value index is not enabled")
+		return
+	}
+
+	qury := r.URL.Query()
+	prefix := qury.Get("value")
+	if prefix == "" {
+		WriteFailure(w, http.StatusBadRequest, "missing value")
+		return
+	}
+
+	if text := qury.Get("limit"); text != "" {
+		size, err := strconv.Atoi(text)
+		if err != nil || size <= 0 {
+			WriteFailure(w, http.StatusBadRequest, "invalid limit %q", text)
+			return
+		}
+
+		limit = min(size, MaxList)
+	}
+
+	recs, err := FindPairs(RequestDB(r), prefix, limit)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	pkeys := make([]string, len(recs))
+	elems := make([]string, len(recs))
+	for i, rec := range recs {
+		pkeys[i] = rec.User + KeySep + rec.Name
+		elems[i] = EscapeName(rec.User) + KeySep + EscapeName(rec.Name)
+	}
+
+	switch {
+	case WantsJSON(r):
+		WriteJSON(w, http.StatusOK, map[string]any{"pairs": pkeys})
+	case len(elems) == 0:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+	default:
+		WriteHTTP(w, http.StatusOK, "%s", strings.Join(elems, "\n"))
+	}
+}
+
+// GetHealth returns the health of the database connection.
+func GetHealth(w http.ResponseWriter, r *http.Request) {
+	err := RequestDB(r).View(func(tx *bbolt.Tx) error {
+		tx.Size()
+		return nil
+	})
+
+	if err != nil {
+		WriteError(w, http.StatusServiceUnavailable, "%s", err)
+		return
+	}
+
+	WriteHTTP(w, http.StatusOK, "ok")
+}
+
+// GetIndex returns the index page, with the value of the private IndexName pair if it
+// exists, or IndexMessage if it is set, or the server version, uptime and bucket name.
+func GetIndex(w http.ResponseWriter, r *http.Request) {
+	if db := RequestDB(r); db != nil {
+		pval, ok, err := GetPairContext(r.Context(), db, IndexName, IndexName)
+		if err == nil && ok {
+			WriteHTTP(w, http.StatusOK, "%s", strings.TrimSuffix(pval, "\n"))
+			return
+		}
+	}
+
+	if IndexMessage != "" {
+		WriteHTTP(w, http.StatusOK, "%s", IndexMessage)
+		return
+	}
+
+	upt := time.Since(startTime).Round(time.Second)
+	WriteHTTP(w, http.StatusOK, "Gesedels %s.\nUptime: %s.\nBucket: %s.", Version, upt, rootName())
+}
+
+// GetMetrics returns request counts and database statistics in the Prometheus text
+// exposition format.
+func GetMetrics(w http.ResponseWriter, r *http.Request) {
+	size, err := CountKeys(RequestDB(r))
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	var meths []string
+	for meth := range MethodRequests {
+		meths = append(meths, meth)
+	}
+
+	sort.Strings(meths)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "# HELP gesedels_requests_total Total number of served requests.\n")
+	fmt.Fprintf(w, "# TYPE gesedels_requests_total counter\n")
+	fmt.Fprintf(w, "gesedels_requests_total %d\n", Requests.Load())
+	fmt.Fprintf(w, "# HELP gesedels_method_requests_total Number of served requests by method.\n")
+	fmt.Fprintf(w, "# TYPE gesedels_method_requests_total counter\n")
+	for _, meth := range meths {
+		fmt.Fprintf(w, "gesedels_method_requests_total{method=%q} %d\n", meth, MethodRequests[meth].Load())
+	}
+
+	fmt.Fprintf(w, "# HELP gesedels_keys Number of stored pairs.\n")
+	fmt.Fprintf(w, "# TYPE gesedels_keys gauge\n")
+	fmt.Fprintf(w, "gesedels_keys %d\n", size)
+	fmt.Fprintf(w, "# HELP gesedels_free_pages Number of free database pages.\n")
+	fmt.Fprintf(w, "# TYPE gesedels_free_pages gauge\n")
+	fmt.Fprintf(w, "gesedels_free_pages %d\n", RequestDB(r).Stats().FreePageN)
+}
+
+// GetNamespace returns the names of all existing pairs for a user, filtered by an
+// optional "prefix" query string and paginated by optional "after" and "limit" query
+// strings, with the next "after" name in the "X-Next-Cursor" header. Plain text names
+// are escaped with EscapeName. With a true "values" query string, it instead streams
+// every pair for the user as newline-delimited JSON Records, not a JSON array.
+func GetNamespace(w http.ResponseWriter, r *http.Request) {
+	var limit = MaxList
+	user := r.PathValue("user")
+	if !validPath(w, user) {
+		return
+	}
+
+	qury := r.URL.Query()
+	if text := qury.Get("values"); text != "" {
+		values, err := strconv.ParseBool(text)
+		switch {
+		case err != nil:
+			WriteFailure(w, http.StatusBadRequest, "invalid values %q", text)
+			return
+		case values:
+			defer holdStream(r)()
+			streamResponse(w)
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			if err := ExportUser(RequestDB(r), user, flushWriter{w}); err != nil {
+				slog.Error("export failed", "user", user, "error", err)
+			}
+
+			return
+		}
+	}
+
+	if text := qury.Get("limit"); text != "" {
+		size, err := strconv.Atoi(text)
+		if err != nil || size <= 0 {
+			WriteFailure(w, http.StatusBadRequest, "invalid limit %q", text)
+			return
+		}
+
+		limit = min(size, MaxList)
+	}
+
+	names, next, err := SearchPairsContext(r.Context(), RequestDB(r), user, qury.Get("prefix"), qury.Get("after"), limit)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+
+	switch {
+	case WantsJSON(r):
+		WriteJSON(w, http.StatusOK, map[string]any{"names": append([]string{}, names...), "next": next})
+	case len(names) == 0:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+	default:
+		WriteHTTP(w, http.StatusOK, "%s", JoinNames(names))
+	}
+}
+
+// GetOpenAPI returns the OpenAPI document describing the server endpoints.
+func GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(OpenAPI)
+}
+
+// GetStats returns the page, transaction and bucket statistics of the database as
+// JSON.
+func GetStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := DBStats(RequestDB(r))
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, stats)
+}
+
+// GetUsage returns the number of pairs and stored bytes for a user as JSON.
+func GetUsage(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	if !validPath(w, user) {
+		return
+	}
+
+	usage, err := UserUsage(RequestDB(r), user)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, usage)
+}
+
+// GetUserCount returns the number of unexpired pairs for a user.
+func GetUserCount(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	if !validPath(w, user) {
+		return
+	}
+
+	size, err := CountPairs(RequestDB(r), user)
+	switch {
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, "%s", err)
+	case WantsJSON(r):
+		WriteJSON(w, http.StatusOK, map[string]any{"count": size})
+	default:
+		WriteHTTP(w, http.StatusOK, "%d", size)
+	}
+}
+
+// GetUsers returns the names of all users with pairs, escaped with EscapeName in
+// plain text.
+func GetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := ListUsers(RequestDB(r))
+	switch {
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, "%s", err)
+	case WantsJSON(r):
+		WriteJSON(w, http.StatusOK, map[string]any{"users": append([]string{}, users...)})
+	case len(users) == 0:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+	default:
+		WriteHTTP(w, http.StatusOK, "%s", JoinNames(users))
+	}
+}
+
+// GetValue returns the value of an existing pair, with its references expanded if the
+// "expand" query is true and reduced to the JSON field at its dotted "path" query if
+// set, or a 304 response if it matches the Request's "If-None-Match" header or has
+// not been modified since its "If-Modified-Since" header. HEAD requests are served by
+// HeadValue.
+func GetValue(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		HeadValue(w, r)
+		return
+	}
+
+	user := r.PathValue("user")
+	name := r.PathValue("name")
+	if !validPath(w, user, name) {
+		return
+	}
+
+	vval, ok := lookupValue(w, r, user, name)
+	if !ok {
+		return
+	}
+
+	if query := r.URL.Query(); vval.Gzip && (query.Has("expand") || query.Has("path")) {
+		plain, err := GunzipValue(vval)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+
+		vval = plain
+	}
+
+	if expand, _ := strconv.ParseBool(r.URL.Query().Get("expand")); expand && !vval.Raw {
+		var scope string
+		if HostRouting {
+			scope = TenantFromHost(r.Host) + "."
+		}
+
+		text, err := expandValue(RequestDB(r), scope, user, string(vval.Data), ExpandDepth, make(map[string]bool))
+		switch {
+		case errors.Is(err, ErrLoop):
+			WriteError(w, http.StatusLoopDetected, "%s", err)
+			return
+		case errors.Is(err, ErrMissingRef):
+			WriteFailure(w, http.StatusUnprocessableEntity, "%s", err)
+			return
+		case err != nil:
+			WriteError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+
+		vval.Data = []byte(text)
+	}
+
+	if path := r.URL.Query().Get("path"); path != "" {
+		data, err := ExtractPath(vval.Data, path)
+		switch {
+		case errors.Is(err, ErrNotJSON):
+			WriteFailure(w, http.StatusConflict, "pair %s/%s is not valid json", user, name)
+			return
+		case errors.Is(err, ErrNoPath):
+			WriteFailure(w, http.StatusNotFound, "path %q does not exist", path)
+			return
+		case err != nil:
+			WriteError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+
+		vval = Value{Data: append(data, '\n'), Modified: vval.Modified, Type: "application/json"}
+	}
+
+	etag := PairETag(vval.Data)
+	modt := vval.Modified.Truncate(time.Second)
+	w.Header().Set("ETag", etag)
+	if !modt.IsZero() {
+		w.Header().Set("Last-Modified", modt.UTC().Format(http.TimeFormat))
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	fresh := err == nil && !modt.IsZero() && !modt.After(since)
+	if none := r.Header.Get("If-None-Match"); none != "" {
+		fresh = matchETag(none, etag)
+	}
+
+	if fresh {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeValue(w, r, vval)
+}
+
+// GetWatch returns the value of a pair once its entity tag no longer matches the
+// Request's "If-None-Match" header, or a 404 response once it is deleted, waiting up
+// to WatchTimeout for a change before returning a 304 response. It holds reloadMutex
+// only while looking up the pair, not while waiting.
+func GetWatch(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	name := r.PathValue("name")
+	if !validPath(w, user, name) {
+		return
+	}
+
+	releaseReload(r)
+	none := r.Header.Get("If-None-Match")
+	timer := time.NewTimer(WatchTimeout)
+	defer timer.Stop()
+
+	// Extend the write deadline past WriteTimeout to cover the wait.
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(WatchTimeout + WriteTimeout))
+
+	stop := func() {}
+	defer func() { stop() }()
+	for {
+		var wait <-chan struct{}
+		stop()
+		wait, stop = WatchPair(user, name)
+		reloadMutex.RLock()
+		vval, ok, err := GetPairValueContext(r.Context(), RequestDB(r), user, name)
+		reloadMutex.RUnlock()
+		switch {
+		case err != nil:
+			WriteError(w, http.StatusInternalServerError, "%s", err)
+			return
+		case ok && !matchETag(none, PairETag(vval.Data)):
+			w.Header().Set("ETag", PairETag(vval.Data))
+			writeValue(w, r, vval)
+			return
+		case !ok && none != "":
+			WriteFailure(w, http.StatusNotFound, "pair %s/%s does not exist", user, name)
+			return
+		}
+
+		select {
+		case <-wait:
+		case <-timer.C:
+			if ok {
+				w.Header().Set("ETag", PairETag(vval.Data))
+			}
+
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// HeadValue returns the headers of an existing pair without its value.
+func HeadValue(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	name := r.PathValue("name")
+	if !validPath(w, user, name) {
+		return
+	}
+
+	vval, ok := lookupValue(w, r, user, name)
+	if !ok {
+		return
+	}
+
+	if !vval.Modified.IsZero() {
+		modt := vval.Modified.Truncate(time.Second)
+		w.Header().Set("Last-Modified", modt.UTC().Format(http.TimeFormat))
+	}
+
+	w.Header().Set("ETag", PairETag(vval.Data))
+	if !vval.Gzip {
+		w.Header().Set("Content-Length", strconv.Itoa(len(vval.Data)))
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// PatchValue applies the request body as a JSON merge patch to the value of an
+// existing pair.
+func PatchValue(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	user := r.PathValue("user")
+	name := r.PathValue("name")
+	if !validPath(w, user, name) {
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	if !json.Valid([]byte(body)) {
+		WriteFailure(w, http.StatusBadRequest, "invalid json patch")
+		return
+	}
+
+	ok, err := PatchPair(RequestDB(r), user, name, []byte(body))
+	switch {
+	case errors.Is(err, ErrNotJSON):
+		WriteFailure(w, http.StatusConflict, "pair %s/%s is not valid json", user, name)
+	case err != nil:
+		writeWriteError(w, r, err)
+	case !ok:
+		WriteFailure(w, http.StatusNotFound, "pair %s/%s does not exist", user, name)
+	default:
+		WriteHTTP(w, http.StatusOK, "Patched.")
+	}
+}
+
+// PostAppend appends the request body to the value of a new or existing pair.
+func PostAppend(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	user := r.PathValue("user")
+	name := r.PathValue("name")
+	if !validPath(w, user, name) {
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	err := AppendPair(RequestDB(r), user, name, body)
+	switch {
+	case err != nil:
+		writeWriteError(w, r, err)
+	default:
+		WriteHTTP(w, http.StatusOK, "Appended.")
+	}
+}
+
+// PostCompact compacts DB into a new file and swaps it in, reclaiming free pages, and
+// returns the file sizes before and after. It releases its HoldReload lock and holds
+// streamMutex and reloadMutex for writing, so it waits for in-flight requests.
+// If DryRun is set it returns without touching the file.
+func PostCompact(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	if DB == nil {
+		WriteFailure(w, http.StatusBadRequest, "cannot compact a directory of stores")
+		return
+	}
+
+	if DryRun {
+		WriteHTTP(w, http.StatusOK, "Compaction skipped in dry run.")
+		return
+	}
+
+	releaseReload(r)
+	streamMutex.Lock()
+	defer streamMutex.Unlock()
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	before, after, err := ShrinkDB()
+	switch {
+	case isDiskFull(err):
+		slog.Error("disk full", "path", DB.Path(), "error", err)
+		WriteError(w, http.StatusInsufficientStorage, "disk is full")
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, "%s", err)
+	case WantsJSON(r):
+		WriteJSON(w, http.StatusOK, map[string]any{"before": before, "after": after})
+	default:
+		WriteHTTP(w, http.StatusOK, "Compacted %d bytes to %d bytes.", before, after)
+	}
+}
+
+// PostCopy copies an existing pair to the name in the request body, replacing an
+// existing pair only if the "overwrite" query is true.
+func PostCopy(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	user := r.PathValue("user")
+	name := r.PathValue("name")
+	if !validPath(w, user, name) {
+		return
+	}
+
+	body, ok := readBody(w, r)
+	dest := strings.TrimSpace(body)
+	if !ok || !validPath(w, dest) {
+		return
+	}
+
+	over, _ := strconv.ParseBool(r.URL.Query().Get("overwrite"))
+	ok, err := CopyPair(RequestDB(r), user, name, dest, over)
+	switch {
+	case errors.Is(err, ErrPairExists):
+		WriteFailure(w, http.StatusConflict, "pair %s/%s already exists", user, dest)
+	case err != nil:
+		writeWriteError(w, r, err)
+	case !ok:
+		WriteFailure(w, http.StatusNotFound, "pair %s/%s does not exist", user, name)
+	default:
+		WriteHTTP(w, http.StatusCreated, "Copied.")
+	}
+}
+
+// PostCopyAcross copies an existing pair to a new user and name, both given in a JSON
+// request body of the form {"from":{"user","name"},"to":{"user","name"}}.
+func PostCopyAcross(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	var body struct {
+		From struct{ User, Name string } `json:"from"`
+		To   struct{ User, Name string } `json:"to"`
+	}
+
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	from, to := body.From, body.To
+	if !validPath(w, from.User, from.Name, to.User, to.Name) {
+		return
+	}
+
+	ok, err := CopyAcross(RequestDB(r), from.User, from.Name, to.User, to.Name)
+	switch {
+	case errors.Is(err, ErrPairExists):
+		WriteFailure(w, http.StatusConflict, "pair %s/%s already exists", to.User, to.Name)
+	case err != nil:
+		writeWriteError(w, r, err)
+	case !ok:
+		WriteFailure(w, http.StatusNotFound, "pair %s/%s does not exist", from.User, from.Name)
+	default:
+		WriteHTTP(w, http.StatusCreated, "Copied.")
+	}
+}
+
+// PostGetSet sets the request body as the value of a new or existing pair and returns
+// its previous value, in a single transaction.
+func PostGetSet(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	user := r.PathValue("user")
+	name := r.PathValue("name")
+	if !validPath(w, user, name) {
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	prev, ok, err := GetSetPair(RequestDB(r), user, name, body)
+	switch {
+	case err != nil:
+		writeWriteError(w, r, err)
+	case !ok:
+		WriteHTTP(w, http.StatusCreated, "Created.")
+	default:
+		writeValue(w, r, Value{Data: []byte(prev)})
+	}
+}
+
+// PostImport imports newline-delimited JSON pairs from the request body.
+func PostImport(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	var mbe *http.MaxBytesError
+	done, skip, err := ImportPairs(RequestDB(r), http.MaxBytesReader(w, r.Body, int64(MaxBody)))
+	switch {
+	case errors.As(err, &mbe):
+		WriteFailure(w, http.StatusRequestEntityTooLarge, "body is over limit of %d bytes (imported %d, skipped %d)", MaxBody, done, skip)
+	case isDiskFull(err):
+		slog.Error("disk full", "path", RequestDB(r).Path(), "error", err)
+		WriteError(w, http.StatusInsufficientStorage, "disk is full (imported %d, skipped %d)", done, skip)
+	case errors.Is(err, ErrPrivate):
+		WriteFailure(w, http.StatusForbidden, "%s (imported %d, skipped %d)", err, done, skip)
+	case errors.Is(err, ErrNoBucket):
+		WriteError(w, http.StatusServiceUnavailable, "%s (imported %d, skipped %d)", err, done, skip)
+	case errors.Is(err, ErrQuota):
+		WriteError(w, http.StatusInsufficientStorage, "%s (imported %d, skipped %d)", err, done, skip)
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, "%s (imported %d, skipped %d)", err, done, skip)
+	default:
+		WriteHTTP(w, http.StatusOK, "imported %d, skipped %d", done, skip)
+	}
+}
+
+// PostIncr adds the integer in the request body, or one if the body is empty, to the
+// integer value of a new or existing pair.
+func PostIncr(w http.ResponseWriter, r *http.Request) {
+	var delta int64 = 1
+	var mbe *http.MaxBytesError
+	if !writable(w) {
+		return
+	}
+
+	user := r.PathValue("user")
+	name := r.PathValue("name")
+	if !validPath(w, user, name) {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(MaxBody)))
+	switch {
+	case errors.As(err, &mbe):
+		WriteFailure(w, http.StatusRequestEntityTooLarge, "body is over limit of %d bytes", MaxBody)
+		return
+	case err != nil:
+		WriteFailure(w, http.StatusBadRequest, "cannot read body: %s", err)
+		return
+	}
+
+	if text := strings.TrimSpace(string(body)); text != "" {
+		delta, err = strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			WriteFailure(w, http.StatusBadRequest, "invalid delta %q", text)
+			return
+		}
+	}
+
+	numb, err := IncrPair(RequestDB(r), user, name, delta)
+	switch {
+	case errors.Is(err, ErrNotInteger):
+		WriteFailure(w, http.StatusConflict, "pair %s/%s is not an integer", user, name)
+	case errors.Is(err, ErrOverflow):
+		WriteFailure(w, http.StatusConflict, "pair %s/%s would overflow", user, name)
+	case err != nil:
+		writeWriteError(w, r, err)
+	default:
+		WriteHTTP(w, http.StatusOK, "%d", numb)
+	}
+}
+
+// PostInit creates the root bucket, so writes can succeed when StrictBucket is set.
+func PostInit(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	ok, err := InitBucket(RequestDB(r))
+	switch {
+	case err != nil:
+		writeWriteError(w, r, err)
+	case ok:
+		WriteHTTP(w, http.StatusCreated, "Created.")
+	default:
+		WriteHTTP(w, http.StatusOK, "Exists.")
+	}
+}
+
+// PostMget returns the values of the pairs named in a JSON array for a user as a
+// JSON object, with null values for missing pairs.
+func PostMget(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	if !validPath(w, user) {
+		return
+	}
+
+	var names []string
+	if !decodeBody(w, r, &names) {
+		return
+	}
+
+	if len(names) > MaxList {
+		WriteFailure(w, http.StatusBadRequest, "cannot get more than %d pairs", MaxList)
+		return
+	}
+
+	if !validPath(w, names...) {
+		return
+	}
+
+	pairs, err := GetPairs(RequestDB(r), user, names)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, pairs)
+}
+
+// PostNamespace sets the values of multiple pairs for a user from a JSON object.
+func PostNamespace(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	user := r.PathValue("user")
+	if !validPath(w, user) {
+		return
+	}
+
+	var pairs map[string]string
+	if !decodeBody(w, r, &pairs) {
+		return
+	}
+
+	for name, pval := range pairs {
+		if !validPath(w, name) {
+			return
+		}
+
+		if strings.TrimSpace(pval) == "" {
+			WriteFailure(w, http.StatusBadRequest, "value for %q is empty", name)
+			return
+		}
+
+		if len(PairValue(pval)) > MaxValue {
+			WriteFailure(w, http.StatusRequestEntityTooLarge, "value for %q is too large", name)
+			return
+		}
+	}
+
+	err := SetPairs(RequestDB(r), user, pairs)
+	switch {
+	case err != nil:
+		writeWriteError(w, r, err)
+	default:
+		WriteHTTP(w, http.StatusOK, "%d", len(pairs))
+	}
+}
+
+// PostPop deletes an existing pair and returns its value, in a single transaction.
+func PostPop(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	user := r.PathValue("user")
+	name := r.PathValue("name")
+	if !validPath(w, user, name) {
+		return
+	}
+
+	pval, ok, err := PopPair(RequestDB(r), user, name)
+	switch {
+	case err != nil:
+		writeWriteError(w, r, err)
+	case !ok:
+		WriteFailure(w, http.StatusNotFound, "pair %s/%s does not exist", user, name)
+	default:
+		writeValue(w, r, Value{Data: []byte(pval)})
+	}
+}
+
+// PostRename moves an existing pair to the name in the request body, replacing an
+// existing pair only if the "overwrite" query is true.
+func PostRename(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	user := r.PathValue("user")
+	name := r.PathValue("name")
+	if !validPath(w, user, name) {
+		return
+	}
+
+	body, ok := readBody(w, r)
+	dest := strings.TrimSpace(body)
+	if !ok || !validPath(w, dest) {
+		return
+	}
+
+	over, _ := strconv.ParseBool(r.URL.Query().Get("overwrite"))
+	ok, err := RenamePair(RequestDB(r), user, name, dest, over)
+	switch {
+	case errors.Is(err, ErrPairExists):
+		WriteFailure(w, http.StatusConflict, "pair %s/%s already exists", user, dest)
+	case err != nil:
+		writeWriteError(w, r, err)
+	case !ok:
+		WriteFailure(w, http.StatusNotFound, "pair %s/%s does not exist", user, name)
+	default:
+		WriteHTTP(w, http.StatusOK, "Renamed.")
+	}
+}
+
+// PostTouch sets the expiry of an existing pair to an optional "ttl" query duration
+// from now, or removes its expiry if no duration is given.
+func PostTouch(w http.ResponseWriter, r *http.Request) {
+	var ttl time.Duration
+	if !writable(w) {
+		return
+	}
+
+	user := r.PathValue("user")
+	name := r.PathValue("name")
+	if !validPath(w, user, name) {
+		return
+	}
+
+	if text := r.URL.Query().Get("ttl"); text != "" {
+		dura, err := time.ParseDuration(text)
+		if err != nil || dura <= 0 {
+			WriteFailure(w, http.StatusBadRequest, "invalid ttl %q", text)
+			return
+		}
+
+		ttl = dura
+	}
+
+	ok, err := TouchPair(RequestDB(r), user, name, ttl)
+	switch {
+	case err != nil:
+		writeWriteError(w, r, err)
+	case !ok:
+		WriteFailure(w, http.StatusNotFound, "pair %s/%s does not exist", user, name)
+	default:
+		WriteHTTP(w, http.StatusOK, "Touched.")
+	}
+}
+
+// PostTx applies a JSON array of set and delete operations in a single transaction.
+func PostTx(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	var ops []TxOp
+	if !decodeBody(w, r, &ops) {
+		return
+	}
+
+	for _, op := range ops {
+		if IsPrivate(op.User) || IsPrivate(op.Name) {
+			WriteFailure(w, http.StatusForbidden, "pair %s/%s is private", op.User, op.Name)
+			return
+		}
+	}
+
+	var txe *TxError
+	err := ApplyTx(RequestDB(r), ops)
+	switch {
+	case errors.As(err, &txe):
+		WriteFailure(w, http.StatusBadRequest, "%s", err)
+	case err != nil:
+		writeWriteError(w, r, err)
+	default:
+		WriteHTTP(w, http.StatusOK, "%d", len(ops))
+	}
+}
+
+// PutValue sets the value of a new or existing pair from the request body or an empty
+// body's "value" query, stored compressed for a "Content-Encoding: gzip" body and
+// expiring after an optional "ttl" query duration, or only if its current value
+// matches an "If-Match" header or it does not exist for an "If-None-Match: *" header.
+// If RequireJSON is set, the body must be a valid JSON document.
+func PutValue(w http.ResponseWriter, r *http.Request) {
+	if !writable(w) {
+		return
+	}
+
+	if RequireJSON && !SendsJSON(r) {
+		WriteFailure(w, http.StatusUnsupportedMediaType, "content type must be application/json")
+		return
+	}
+
+	var ttl time.Duration
+	user := r.PathValue("user")
+	name := r.PathValue("name")
+	if !validPath(w, user, name) {
+		return
+	}
+
+	if text := r.URL.Query().Get("ttl"); text != "" {
+		dura, err := time.ParseDuration(text)
+		if err != nil || dura <= 0 {
+			WriteFailure(w, http.StatusBadRequest, "invalid ttl %q", text)
+			return
+		}
+
+		ttl = dura
+	}
+
+	body, ok := readValue(w, r)
+	if !ok || RequireJSON && !validJSON(w, r, body) {
+		return
+	}
+
+	if r.Header.Get("If-Match") != "" {
+		putSwap(w, r, user, name, body, ttl)
+		return
+	}
+
+	if r.Header.Get("If-None-Match") != "" {
+		putCreate(w, r, user, name, body, ttl)
+		return
+	}
+
+	_, ok, err := GetPairContext(r.Context(), RequestDB(r), user, name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	vval := Value{Data: PairValue(body), Type: ValueType(r)}
+	if SendsRaw(r) {
+		vval = Value{Data: []byte(body), Raw: true}
+	}
+
+	if SendsGzip(r) {
+		vval.Data, vval.Gzip = []byte(body), true
+	}
+
+	if ttl != 0 {
+		vval.Expiry = time.Now().Add(ttl)
+	}
+
+	err = SetPairValue(RequestDB(r), user, name, vval)
+	switch {
+	case errors.Is(err, ErrNotGzip):
+		WriteFailure(w, http.StatusBadRequest, "invalid gzip body")
+	case err != nil:
+		writeWriteError(w, r, err)
+	case ok:
+		WriteHTTP(w, http.StatusOK, "Updated.")
+	default:
+		WriteHTTP(w, http.StatusCreated, "Created.")
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////////////
+//                      part seven · server middleware functions                     //
+///////////////////////////////////////////////////////////////////////////////////////
+
+// ctxKey is the type of request context keys.
+type ctxKey string
+
+// limitBucket is a token bucket for the request rate of a client.
+type limitBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// jsonWriter is a ResponseWriter for a Request that accepts JSON responses.
+type jsonWriter struct {
+	http.ResponseWriter
+}
+
+// Unwrap returns the jsonWriter's underlying ResponseWriter.
+func (w jsonWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusWriter is a ResponseWriter that records its response status code.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+// Unwrap returns the statusWriter's underlying ResponseWriter.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// WriteHeader records and writes a response status code.
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// releaseReload releases the reloadMutex read lock held for a Request by HoldReload,
+// if any.
+func releaseReload(r *http.Request) {
+	if release, ok := r.Context().Value(ctxKey("reload")).(func()); ok {
+		release()
+	}
+}
+
+// validID returns true if a request ID string is short and contains only letters,
+// digits, dashes, underscores and dots.
+func validID(id string) bool {
+	return id != "" && len(id) <= 128 && strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.") == ""
+}
+
+// Stores is a cache of lazily-opened database connections for the database files in
+// a directory.
+type Stores struct {
+	Dir   string
+	mutex sync.Mutex
+	conns map[string]*bbolt.DB
+}
+
+// Close closes all of the Stores' open database connections.
+func (s *Stores) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var errs []error
+	for name, db := range s.conns {
+		errs = append(errs, stopDB(db))
+		delete(s.conns, name)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Open returns the database connection for a store name, opening it if necessary,
+// and false if the store's database file does not exist.
+func (s *Stores) Open(name string) (*bbolt.DB, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if db, ok := s.conns[name]; ok {
+		return db, true, nil
+	}
+
+	if !ValidName(name) || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return nil, false, nil
+	}
+
+	path := filepath.Join(s.Dir, name+".db")
+	if _, err := os.Stat(path); err != nil {
+		return nil, false, nil
+	}
+
+	db, err := OpenDB(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := startDB(db); err != nil {
+		stopDB(db)
+		return nil, false, err
+	}
+
+	if s.conns == nil {
+		s.conns = make(map[string]*bbolt.DB)
+	}
+
+	s.conns[name] = db
+	return db, true, nil
+}
+
+// CORS wraps a Handler to allow cross-origin requests from CORSOrigin, answering
+// preflight requests directly. An empty CORSOrigin adds no headers.
+func CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if CORSOrigin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", CORSOrigin)
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified, X-Next-Cursor")
+		if CORSOrigin != "*" {
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match, If-Modified-Since")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// FollowUpstream wraps a Handler to proxy requests other than GET, HEAD and OPTIONS
+// to an Upstream URL, deleting the local copy of any pair or user in the request path
+// after a successful write so it is read through again, under the request host's
+// tenant if HostRouting is set.
+func FollowUpstream(upstream *url.URL, next http.Handler) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		proxy.ServeHTTP(sw, r)
+		if sw.code >= 300 {
+			return
+		}
+
+		elems := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+		user, uerr := url.PathUnescape(elems[0])
+		tenant := TenantFromHost(r.Host)
+		switch {
+		case uerr != nil || !ValidName(user) || strings.HasPrefix(user, "_"):
+			return
+		case HostRouting && tenant == "":
+			return
+		case HostRouting:
+			user = tenant + "." + user
+		}
+
+		if len(elems) == 1 {
+			DeleteUser(RequestDB(r), user)
+		} else if name, err := url.PathUnescape(elems[1]); err == nil && ValidName(name) {
+			DeletePair(RequestDB(r), user, name)
+		}
+	})
+}
+
+// HoldReload wraps a Handler to hold reloadMutex for reading during each request, so
+// Reload waits for in-flight requests to finish on the old settings and database.
+// Handlers that block for long release it early with releaseReload.
+func HoldReload(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reloadMutex.RLock()
+		release := sync.OnceFunc(reloadMutex.RUnlock)
+		defer release()
+
+		ctx := context.WithValue(r.Context(), ctxKey("reload"), release)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// LogRequests wraps a Handler to log each request and count it in Requests and
+// MethodRequests, excluding requests to "/metrics".
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		strt := time.Now()
+		sw := &statusWriter{w, http.StatusOK}
+		next.ServeHTTP(sw, r)
+		attrs := []any{"method", r.Method, "path", r.URL.Path, "status", sw.code, "duration", time.Since(strt)}
+		if id := RequestID(r.Context()); id != "" {
+			attrs = append(attrs, "id", id)
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case sw.code >= 500:
+			level = slog.LevelError
+		case sw.code >= 400:
+			level = slog.LevelWarn
+		}
+
+		slog.Log(r.Context(), level, "request", attrs...)
+
+		if r.URL.Path != "/metrics" {
+			Requests.Add(1)
+			if ctr, ok := MethodRequests[r.Method]; ok {
+				ctr.Add(1)
+			} else {
+				MethodRequests["OTHER"].Add(1)
+			}
+		}
+	})
+}
+
+// Negotiate wraps a Handler to write JSON responses to Requests that accept them.
+func Negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if WantsJSON(r) {
+			w = jsonWriter{w}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimit wraps a Handler to limit each client to a number of requests per second,
+// with bursts of up to the same number. A limit of zero or less disables limiting.
+func RateLimit(rps int, next http.Handler) http.Handler {
+	if rps <= 0 {
+		return next
+	}
+
+	var mutex sync.Mutex
+	var swept time.Time
+	bucks := make(map[string]*limitBucket)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := ClientAddr(r)
+		now := time.Now()
+		mutex.Lock()
+
+		// Remove buckets idle long enough to have refilled, once a minute.
+		if now.Sub(swept) > time.Minute {
+			for host, buck := range bucks {
+				if now.Sub(buck.last) > time.Second {
+					delete(bucks, host)
+				}
+			}
+
+			swept = now
+		}
+
+		buck, ok := bucks[addr]
+		if !ok {
+			buck = &limitBucket{float64(rps), now}
+			bucks[addr] = buck
+		}
+
+		buck.tokens = min(float64(rps), buck.tokens+now.Sub(buck.last).Seconds()*float64(rps))
+		buck.last = now
+		okay := buck.tokens >= 1
+		if okay {
+			buck.tokens--
+		}
+
+		mutex.Unlock()
+		if !okay {
+			w.Header().Set("Retry-After", "1")
+			WriteFailure(w, http.StatusTooManyRequests, "too many requests")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RejectTenants wraps a HandlerFunc for an endpoint that reaches pairs of all users to
+// reject requests from a host with a tenant if HostRouting is set, so tenants cannot
+// reach each other's pairs through it.
+func RejectTenants(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if HostRouting && TenantFromHost(r.Host) != "" {
+			WriteFailure(w, http.StatusForbidden, "endpoint is not available to tenant hosts")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// RequestDB returns the database connection for a Request's store, or DB if the
+// Request has no store.
+func RequestDB(r *http.Request) *bbolt.DB {
+	if db, ok := r.Context().Value(ctxKey("db")).(*bbolt.DB); ok {
+		return db
+	}
+
+	return DB
+}
+
+// RequestID returns the request ID stored in a Context, or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey("id")).(string)
+	return id
+}
+
+// RequireAuth wraps a HandlerFunc to require Basic Auth with a password matching
+// Token, if Token is set.
+func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if Token != "" {
+			_, pass, _ := r.BasicAuth()
+			if subtle.ConstantTimeCompare([]byte(pass), []byte(Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="gesedels"`)
+				WriteFailure(w, http.StatusUnauthorized, "invalid credentials")
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// RouteStores wraps a Handler to serve each request with the database connection for
+// the store named by the first path segment, with that segment removed.
+func RouteStores(stores *Stores, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		db, ok, err := stores.Open(name)
+		switch {
+		case err != nil:
+			WriteError(w, http.StatusInternalServerError, "%s", err)
+		case !ok:
+			WriteFailure(w, http.StatusNotFound, "store %q does not exist", name)
+		default:
+			ctx := context.WithValue(r.Context(), ctxKey("db"), db)
+			http.StripPrefix("/"+name, next).ServeHTTP(w, r.WithContext(ctx))
+		}
+	})
+}
+
+// RouteTenants wraps a HandlerFunc to prefix its user path value with the tenant
+// from the request host and a dot, if HostRouting is set. Hosts without a tenant
+// are rejected, so tenants cannot reach each other through dotted path users.
+func RouteTenants(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if HostRouting {
+			tenant := TenantFromHost(r.Host)
+			if tenant == "" {
+				WriteFailure(w, http.StatusBadRequest, "host %q has no tenant", r.Host)
+				return
+			}
+
+			r.SetPathValue("user", tenant+"."+r.PathValue("user"))
+		}
+
+		next(w, r)
+	}
+}
+
+// TraceRequests wraps a Handler to give each request an ID, taken from a valid
+// "X-Request-ID" header or randomly generated, and echo it in the response.
+func TraceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validID(id) {
+			id = rand.Text()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), ctxKey("id"), id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// TrimSlash wraps a Handler to serve requests for paths with a trailing slash at their
+// CanonicalPath, or to redirect them there with a 308 response if redirect is set.
+func TrimSlash(redirect bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := CanonicalPath(r.URL.Path)
+		if path == r.URL.Path {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		addr := *r.URL
+		addr.Path = path
+		addr.RawPath = CanonicalPath(r.URL.RawPath)
+		if redirect {
+			http.Redirect(w, r, addr.RequestURI(), http.StatusPermanentRedirect)
+			return
+		}
+
+		r2 := *r
+		r2.URL = &addr
+		next.ServeHTTP(w, &r2)
+	})
+}
+
+///////////////////////////////////////////////////////////////////////////////////////
+//                        part eight · main runtime functions                        //
+///////////////////////////////////////////////////////////////////////////////////////
+
+// Config is a set of server options loaded from a JSON config file, where zero
+// fields are unset.
+type Config struct {
+	Addr     string `json:"addr"`
+	Path     string `json:"path"`
+	Bucket   string `json:"bucket"`
+	Token    string `json:"token"`
+	MaxValue int    `json:"max_value"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// ApplyConfig sets each flag in a FlagSet that was not explicitly set to the value
+// of its non-zero field in a Config.
+func ApplyConfig(fset *flag.FlagSet, conf Config) error {
+	seen := make(map[string]bool)
+	fset.Visit(func(flg *flag.Flag) {
+		seen[flg.Name] = true
+	})
+
+	opts := map[string]string{
+		"addr":      conf.Addr,
+		"path":      conf.Path,
+		"bucket":    conf.Bucket,
+		"token":     conf.Token,
+		"max-value": "",
+		"read-only": "",
+	}
+
+	if conf.MaxValue != 0 {
+		opts["max-value"] = strconv.Itoa(conf.MaxValue)
+	}
+
+	if conf.ReadOnly {
+		opts["read-only"] = "true"
+	}
+
+	for name, text := range opts {
+		if text != "" && !seen[name] {
+			if err := fset.Set(name, text); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Listen returns a Listener on a TCP address or a "unix:" socket path, limited to
+// MaxConns simultaneous connections if set, serving TLS with a certificate and key
+// file if both are set, or an error if only one is set. A stale socket file is
+// replaced, and the socket file is removed on close.
+func Listen(addr, cert, key string) (net.Listener, error) {
+	if (cert == "") != (key == "") {
+		return nil, errors.New("tls cert and key must be set together")
+	}
+
+	lis, err := listenAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if MaxConns > 0 {
+		lis = netutil.LimitListener(lis, MaxConns)
+	}
+
+	if cert == "" {
+		return lis, nil
+	}
+
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		lis.Close()
+		return nil, err
+	}
+
+	return tls.NewListener(lis, &tls.Config{Certificates: []tls.Certificate{pair}}), nil
+}
+
+// LoadConfig returns a Config from a JSON config file.
+func LoadConfig(path string) (Config, error) {
+	var conf Config
+	file, err := os.Open(path)
+	if err != nil {
+		return conf, err
+	}
+
+	defer file.Close()
+	dec := json.NewDecoder(file)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&conf); err != nil {
+		return conf, fmt.Errorf("cannot load config %q: %w", path, err)
+	}
+
+	return conf, nil
+}
+
+// NewLogger returns a Logger writing to a Writer at a level ("debug", "info",
+// "warn" or "error") in a format ("text" or "json").
+func NewLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+// NewMux returns a ServeMux with all server endpoints registered.
+func NewMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /", GetIndex)
+	mux.HandleFunc("GET /healthz", GetHealth)
+	mux.HandleFunc("GET /metrics", GetMetrics)
+	mux.HandleFunc("GET /_backup", RejectTenants(RequireAuth(GetBackup)))
+	mux.HandleFunc("GET /_config", RequireAuth(GetConfig))
+	mux.HandleFunc("POST /_copy", RejectTenants(RequireAuth(PostCopyAcross)))
+	mux.HandleFunc("POST /_compact", RequireAuth(PostCompact))
+	mux.HandleFunc("GET /_count", RejectTenants(GetCount))
+	mux.HandleFunc("GET /_events", RejectTenants(GetEvents))
+	mux.HandleFunc("GET /_export", RejectTenants(GetExport))
+	mux.HandleFunc("GET /_find", RejectTenants(GetFind))
+	mux.HandleFunc("POST /_import", RejectTenants(RequireAuth(PostImport)))
+	mux.HandleFunc("POST /_init", RequireAuth(PostInit))
+	mux.HandleFunc("GET /_openapi.json", GetOpenAPI)
+	mux.HandleFunc("GET /_stats", RequireAuth(GetStats))
+	mux.HandleFunc("POST /_tx", RejectTenants(RequireAuth(PostTx)))
+	mux.HandleFunc("GET /_users", RejectTenants(RequireAuth(GetUsers)))
+	mux.HandleFunc("GET /{user}", RouteTenants(GetNamespace))
+	mux.HandleFunc("GET /{user}/_count", RouteTenants(GetUserCount))
+	mux.HandleFunc("GET /{user}/_usage", RouteTenants(GetUsage))
+	mux.HandleFunc("POST /{user}", RouteTenants(RequireAuth(PostNamespace)))
+	mux.HandleFunc("POST /{user}/_mget", RouteTenants(PostMget))
+	mux.HandleFunc("DELETE /{user}", RouteTenants(RequireAuth(DeleteNamespace)))
+	mux.HandleFunc("GET /{user}/{name}", RouteTenants(GetValue))
+	mux.HandleFunc("PUT /{user}/{name}", RouteTenants(RequireAuth(PutValue)))
+	mux.HandleFunc("PATCH /{user}/{name}", RouteTenants(RequireAuth(PatchValue)))
+	mux.HandleFunc("DELETE /{user}/{name}", RouteTenants(RequireAuth(DeleteValue)))
+	mux.HandleFunc("POST /{user}/{name}/incr", RouteTenants(RequireAuth(PostIncr)))
+	mux.HandleFunc("POST /{user}/{name}/append", RouteTenants(RequireAuth(PostAppend)))
+	mux.HandleFunc("POST /{user}/{name}/getset", RouteTenants(RequireAuth(PostGetSet)))
+	mux.HandleFunc("POST /{user}/{name}/pop", RouteTenants(RequireAuth(PostPop)))
+	mux.HandleFunc("POST /{user}/{name}/rename", RouteTenants(RequireAuth(PostRename)))
+	mux.HandleFunc("POST /{user}/{name}/copy", RouteTenants(RequireAuth(PostCopy)))
+	mux.HandleFunc("POST /{user}/{name}/touch", RouteTenants(RequireAuth(PostTouch)))
+	mux.HandleFunc("GET /{user}/{name}/watch", RouteTenants(GetWatch))
+
+	return mux
+}
+
+// NewServer returns a Server for a Handler on an address with the global ReadTimeout,
+// WriteTimeout, IdleTimeout and MaxHeader, also serving HTTP/2 over plaintext
+// connections, with prior knowledge or an "Upgrade: h2c" request, if plain is set.
+func NewServer(addr string, handler http.Handler, plain bool) *http.Server {
+	srv := &http.Server{
+		Addr:           addr,
+		Handler:        handler,
+		ReadTimeout:    ReadTimeout,
+		WriteTimeout:   WriteTimeout,
+		IdleTimeout:    IdleTimeout,
+		MaxHeaderBytes: MaxHeader,
+	}
+
+	if plain {
+		srv.Handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: IdleTimeout})
+	}
+
+	return srv
+}
+
+// Reload re-reads a config file, if set, into the flags of a FlagSet not set on the
+// command line, then waits for in-flight requests before applying the token, maximum
+// value size and read-only settings, reopening DB at its path and closing any open
+// Stores to be reopened on demand. Changes to the address or bucket are logged and
+// ignored, as they need a restart.
+func Reload(fset *flag.FlagSet, cli map[string]bool, config string, stores *Stores) error {
+	if config != "" {
+		conf, err := LoadConfig(config)
+		if err != nil {
+			return err
+		}
+
+		fresh := flag.NewFlagSet(fset.Name(), flag.ContinueOnError)
+		fset.VisitAll(func(flg *flag.Flag) {
+			fresh.Var(flg.Value, flg.Name, flg.Usage)
+			if cli[flg.Name] {
+				fresh.Set(flg.Name, flg.Value.String())
+			}
+		})
+
+		if err := ApplyConfig(fresh, conf); err != nil {
+			return err
+		}
+	}
+
+	get := func(name string) any {
+		return fset.Lookup(name).Value.(flag.Getter).Get()
+	}
+
+	if addr := get("addr").(string); addr != Addr {
+		slog.Warn("address change needs a restart", "addr", addr)
+	}
+
+	if bucket := get("bucket").(string); bucket != string(Bucket) {
+		slog.Warn("bucket change needs a restart", "bucket", bucket)
+	}
+
+	if version := get("api-version").(string); version != APIVersion {
+		slog.Warn("api version change needs a restart", "api_version", version)
+	}
+
+	streamMutex.Lock()
+	defer streamMutex.Unlock()
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	Token = get("token").(string)
+	MaxValue = get("max-value").(int)
+	ReadOnly = get("read-only").(bool)
+	if err := stores.Close(); err != nil {
+		return err
+	}
+
+	if path := get("path").(string); DB != nil && path != MemoryPath {
+		if err := ReopenDB(path); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("server reloaded")
+	return nil
+}
+
+// RunBackup runs the "backup" subcommand, writing a consistent snapshot of a
+// database to a destination path.
+func RunBackup(args []string, r io.Reader, w io.Writer) (bool, error) {
+	fset := flag.NewFlagSet("backup", flag.ContinueOnError)
+	path := fset.String("path", DefaultPath, "set database path")
+	if err := fset.Parse(args); err != nil {
+		return false, err
+	}
+
+	if fset.NArg() != 1 {
+		return false, errors.New("backup requires 1 argument")
+	}
+
+	db, err := commandDB(*path, true)
+	if err != nil {
+		return false, err
+	}
+
+	defer db.Close()
+	return true, BackupFile(db, fset.Arg(0))
+}
+
+// RunCompact runs the "compact" subcommand, writing a compacted copy of a database
+// file to a new destination path and reporting the file sizes before and after.
+func RunCompact(args []string, r io.Reader, w io.Writer) (bool, error) {
+	if len(args) != 2 {
+		return false, errors.New("compact requires 2 arguments")
+	}
+
+	if _, err := os.Stat(args[1]); err == nil {
+		return false, fmt.Errorf("destination %q already exists", args[1])
+	}
+
+	db, err := commandDB(args[0], true)
+	if err != nil {
+		return false, err
+	}
+
+	defer db.Close()
+	before, after, err := CompactDB(db, args[1])
+	if err != nil {
+		return false, err
+	}
+
+	fmt.Fprintf(w, "Compacted %d bytes to %d bytes.\n", before, after)
+	return true, nil
+}
+
+// RunDelete runs the "delete" subcommand, deleting a pair from a database and
+// returning false if it did not exist.
+func RunDelete(args []string, r io.Reader, w io.Writer) (bool, error) {
+	db, elems, err := openCommand("delete", args, 2, false)
+	if err != nil {
+		return false, err
+	}
+
+	defer db.Close()
+	_, ok, err := GetPair(db, elems[0], elems[1])
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return true, DeletePair(db, elems[0], elems[1])
+}
+
+// RunDump runs the "dump" subcommand, writing all unexpired public pairs in a database
+// file to a Writer as newline-delimited JSON.
+func RunDump(args []string, r io.Reader, w io.Writer) (bool, error) {
+	if len(args) != 1 {
+		return false, errors.New("dump requires 1 argument")
+	}
+
+	db, err := commandDB(args[0], true)
+	if err != nil {
+		return false, err
+	}
+
+	defer db.Close()
+	return true, ExportPairs(db, w)
+}
+
+// RunGet runs the "get" subcommand, writing the value of a pair in a database to a
+// Writer and returning false if it does not exist.
+func RunGet(args []string, r io.Reader, w io.Writer) (bool, error) {
+	db, elems, err := openCommand("get", args, 2, true)
+	if err != nil {
+		return false, err
+	}
+
+	defer db.Close()
+	pval, ok, err := GetPair(db, elems[0], elems[1])
+	if err != nil || !ok {
+		return false, err
+	}
+
+	_, err = io.WriteString(w, pval)
+	return true, err
+}
+
+// RunLoad runs the "load" subcommand, setting pairs in a database file from
+// newline-delimited JSON in a Reader and writing the import counts to a Writer.
+func RunLoad(args []string, r io.Reader, w io.Writer) (bool, error) {
+	if len(args) != 1 {
+		return false, errors.New("load requires 1 argument")
+	}
+
+	db, err := commandDB(args[0], false)
+	if err != nil {
+		return false, err
+	}
+
+	defer db.Close()
+	done, skip, err := ImportPairs(db, r)
+	fmt.Fprintf(w, "imported %d, skipped %d\n", done, skip)
+	return true, err
+}
+
+// RunRepl runs the "repl" subcommand, reading get, set, del, list and exit commands
+// for a database file line by line from a Reader and writing a prompt and their
+// results to a Writer. A database opened with "--read-only", or that cannot be opened
+// for writing, is opened read-only with set and del disabled.
+func RunRepl(args []string, r io.Reader, w io.Writer) (bool, error) {
+	fset := flag.NewFlagSet("repl", flag.ContinueOnError)
+	readOnly := fset.Bool("read-only", false, "open database read-only")
+	if err := fset.Parse(args); err != nil {
+		return false, err
+	}
+
+	if fset.NArg() != 1 {
+		return false, errors.New("repl requires 1 argument")
+	}
+
+	path := fset.Arg(0)
+	db, err := commandDB(path, *readOnly)
+	if errors.Is(err, os.ErrPermission) {
+		db, err = commandDB(path, true)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	defer db.Close()
+	scan := bufio.NewScanner(r)
+	for {
+		io.WriteString(w, "> ")
+		if !scan.Scan() || !replLine(db, scan.Text(), w) {
+			break
+		}
+	}
+
+	return true, scan.Err()
+}
+
+// RunSelftest runs the "selftest" subcommand, setting, getting and deleting a pair in a
+// temporary database and writing the outcome of each step to a Writer.
+func RunSelftest(args []string, r io.Reader, w io.Writer) (bool, error) {
+	if len(args) != 0 {
+		return false, errors.New("selftest requires 0 arguments")
+	}
+
+	db, err := OpenDB(MemoryPath)
+	if err != nil {
+		return false, err
+	}
+
+	defer db.Close()
+	want := string(PairValue("Self-test."))
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"set", func() error {
+			return SetPair(db, "selftest", "pair", "Self-test.")
+		}},
+		{"get", func() error {
+			pval, ok, err := GetPair(db, "selftest", "pair")
+			switch {
+			case err != nil:
+				return err
+			case !ok:
+				return ErrNotFound
+			case pval != want:
+				return fmt.Errorf("value is %q, not %q", pval, want)
+			}
+
+			return nil
+		}},
+		{"delete", func() error {
+			return DeletePair(db, "selftest", "pair")
+		}},
+		{"verify", func() error {
+			_, ok, err := GetPair(db, "selftest", "pair")
+			if err == nil && ok {
+				err = ErrPairExists
+			}
+
+			return err
+		}},
+	}
+
+	for _, step := range steps {
+		if err := step.fn(); err != nil {
+			fmt.Fprintf(w, "%s: failed: %s\n", step.name, err)
+			return false, fmt.Errorf("selftest %s failed: %w", step.name, err)
+		}
+
+		fmt.Fprintf(w, "%s: ok\n", step.name)
+	}
+
+	return true, nil
+}
+
+// RunSet runs the "set" subcommand, setting the value of a new or existing pair in a
+// database.
+func RunSet(args []string, r io.Reader, w io.Writer) (bool, error) {
+	db, elems, err := openCommand("set", args, 3, false)
+	if err != nil {
+		return false, err
+	}
+
+	defer db.Close()
+	return true, SetPair(db, elems[0], elems[1], elems[2])
+}
+
+// Serve runs a Server on a Listener until a signal is received, then gracefully
+// shuts the Server down within ten seconds.
+func Serve(srv *http.Server, lis net.Listener, sigs <-chan os.Signal) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(lis)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-sigs:
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(ctx)
+	}
+}
+
+// commandDB returns a subcommand's database connection for a path like OpenDB, opened
+// read-only if set, or an error if the database is locked for over a second. Flat
+// pairs in a writable database are moved into user buckets, as on server startup, and
+// its value index is deleted, since subcommands do not maintain it.
+func commandDB(path string, readOnly bool) (*bbolt.DB, error) {
+	db, err := openDB(path, &bbolt.Options{ReadOnly: readOnly, Timeout: time.Second})
+	if err != nil || readOnly {
+		return db, err
+	}
+
+	if _, err := MigrateToBuckets(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(indexName()) == nil {
+			return nil
+		}
+
+		return tx.DeleteBucket(indexName())
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// listenAddr returns a plain Listener on a TCP address or a "unix:" socket path with
+// the permissions in SocketMode.
+func listenAddr(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, SocketMode); err != nil {
+		lis.Close()
+		return nil, err
+	}
+
+	return lis, nil
+}
+
+// openCommand parses the flags and a number of valid name arguments for a subcommand,
+// returning its database connection, opened read-only if set, and the arguments.
+func openCommand(name string, args []string, size int, readOnly bool) (*bbolt.DB, []string, error) {
+	fset := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := fset.String("path", DefaultPath, "set database path")
+	if err := fset.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	elems := fset.Args()
+	if len(elems) != size {
+		return nil, nil, fmt.Errorf("%s requires %d arguments", name, size)
+	}
+
+	for _, elem := range elems[:2] {
+		if !ValidName(elem) {
+			return nil, nil, fmt.Errorf("invalid name %q", elem)
+		}
+	}
+
+	db, err := commandDB(*path, readOnly)
+	return db, elems, err
+}
+
+// replLine runs one line of the "repl" subcommand against a database, writing its
+// result or error to a Writer, and returns false if the line is an exit command.
+func replLine(db *bbolt.DB, line string, w io.Writer) bool {
+	var elems []string
+	rest := strings.TrimSpace(line)
+	for rest != "" && len(elems) < 3 {
+		elem, tail, _ := strings.Cut(rest, " ")
+		elems = append(elems, elem)
+		rest = strings.TrimSpace(tail)
+	}
+
+	if rest != "" {
+		elems = append(elems, rest)
+	}
+
+	if len(elems) == 0 {
+		return true
+	}
+
+	usages := map[string]string{
+		"get":  "get user name",
+		"set":  "set user name value",
+		"del":  "del user name",
+		"list": "list user",
+		"exit": "exit",
+	}
+
+	cmd := elems[0]
+	usage, ok := usages[cmd]
+	switch {
+	case !ok:
+		fmt.Fprintf(w, "unknown command %q\n", cmd)
+		return true
+	case len(elems) != len(strings.Fields(usage)):
+		fmt.Fprintf(w, "usage: %s\n", usage)
+		return true
+	case cmd == "exit":
+		return false
+	case (cmd == "set" || cmd == "del") && db.IsReadOnly():
+		fmt.Fprintf(w, "%s is disabled for a read-only database\n", cmd)
+		return true
+	}
+
+	for _, elem := range elems[1:min(len(elems), 3)] {
+		if !ValidName(elem) {
+			fmt.Fprintf(w, "invalid name %q\n", elem)
+			return true
+		}
+	}
+
+	var err error
+	var okay = true
+	switch cmd {
+	case "get":
+		var pval string
+		if pval, okay, err = GetPair(db, elems[1], elems[2]); okay {
+			io.WriteString(w, pval)
+		}
+
+	case "set":
+		err = SetPair(db, elems[1], elems[2], elems[3])
+
+	case "del":
+		if _, okay, err = GetPair(db, elems[1], elems[2]); okay {
+			err = DeletePair(db, elems[1], elems[2])
+		}
+
+	case "list":
+		var names []string
+		names, _, err = ListPairs(db, elems[1], "", 0)
+		for _, name := range names {
+			fmt.Fprintln(w, name)
+		}
+	}
+
+	switch {
+	case err != nil:
+		fmt.Fprintf(w, "error: %s\n", err)
+	case !okay:
+		fmt.Fprintf(w, "pair %s/%s does not exist\n", elems[1], elems[2])
+	case cmd == "set" || cmd == "del":
+		io.WriteString(w, "ok\n")
+	}
+
+	return true
+}
+
+// run runs the Gesedels program with command-line arguments, dispatching to a
+// subcommand if one is given or running the server otherwise.
+func run(args []string) error {
+	cmds := map[string]func([]string, io.Reader, io.Writer) (bool, error){
+		"backup":   RunBackup,
+		"compact":  RunCompact,
+		"delete":   RunDelete,
+		"dump":     RunDump,
+		"get":      RunGet,
+		"load":     RunLoad,
+		"repl":     RunRepl,
+		"selftest": RunSelftest,
+		"set":      RunSet,
+	}
+
+	if len(args) > 0 && cmds[args[0]] != nil {
+		ok, err := cmds[args[0]](args[1:], os.Stdin, os.Stdout)
+		if err == nil && !ok {
+			err = ErrNotFound
+		}
+
+		return err
+	}
+
+	if len(args) > 0 && args[0] == "serve" {
+		args = args[1:]
+	}
+
+	return serve(args)
+}
+
+// serve runs the Gesedels server with command-line arguments until it is interrupted,
+// returning an error if it cannot start.
+func serve(args []string) error {
+	// Define and parse command-line functions.
+	fset := flag.NewFlagSet("gesedels", flag.ContinueOnError)
+	addr := fset.String("addr", "127.0.0.1:8080", "set server address or unix:path")
+	path := fset.String("path", DefaultPath, "set database path or "+MemoryPath)
+	token := fset.String("token", os.Getenv("GESEDELS_TOKEN"), "set write password")
+	maxName := fset.Int("max-name", MaxName, "set maximum name length")
+	bucket := fset.String("bucket", string(Bucket), "set database bucket name")
+	apiVersion := fset.String("api-version", "", "set API version prefixed to the bucket name, such as v1")
+	readOnly := fset.Bool("read-only", false, "reject all write requests")
+	minGzip := fset.Int("min-gzip", MinGzip, "set minimum compressed response size")
+	maxList := fset.Int("max-list", MaxList, "set maximum names per listing")
+	maxValue := fset.Int("max-value", MaxValue, "set maximum value size in bytes")
+	maxBody := fset.Int("max-body", MaxBody, "set maximum JSON or import request body size in bytes")
+	maxUserKeys := fset.Int("max-user-keys", 0, "set maximum pairs per user")
+	maxUserBytes := fset.Int("max-user-bytes", 0, "set maximum stored bytes per user")
+	dir := fset.String("dir", "", "serve every *.db file in directory instead of path")
+	queueSize := fset.Int("queue-size", QueueSize, "set maximum writes per batch")
+	queueDelay := fset.Duration("queue-delay", QueueDelay, "set maximum wait per batch")
+	config := fset.String("config", "", "set JSON config file path, re-read on SIGHUP")
+	tlsCert := fset.String("tls-cert", "", "set TLS certificate file path")
+	tlsKey := fset.String("tls-key", "", "set TLS private key file path")
+	check := fset.Bool("check", false, "refuse to start on database corruption")
+	socketMode := fset.String("socket-mode", "0660", "set unix socket file permissions")
+	dryRun := fset.Bool("dry-run", false, "validate write requests without committing them")
+	h2c := fset.Bool("h2c", false, "serve HTTP/2 over plaintext connections")
+	corsOrigin := fset.String("cors-origin", "", "set allowed cross-origin request origin")
+	noTrim := fset.Bool("no-trim", false, "store values without trimming whitespace")
+	rate := fset.Int("rate", 0, "set maximum requests per second per client")
+	trustProxy := fset.Bool("trust-proxy", false, "identify clients by X-Forwarded-For")
+	watchTimeout := fset.Duration("watch-timeout", WatchTimeout, "set maximum wait per watch request")
+	readTimeout := fset.Duration("read-timeout", ReadTimeout, "set maximum time to read a request")
+	writeTimeout := fset.Duration("write-timeout", WriteTimeout, "set maximum time to write a response")
+	idleTimeout := fset.Duration("idle-timeout", IdleTimeout, "set maximum idle connection time")
+	maxHeader := fset.Int("max-header", MaxHeader, "set maximum request header size in bytes")
+	maxConns := fset.Int("max-conns", 0, "set maximum simultaneous connections")
+	backupDir := fset.String("backup-dir", "", "set scheduled database snapshot directory")
+	backupInterval := fset.Duration("backup-interval", time.Hour, "set time between scheduled snapshots")
+	backupKeep := fset.Int("backup-keep", 24, "set number of scheduled snapshots to keep")
+	sweepInterval := fset.Duration("sweep-interval", 0, "set time between sweeps of expired pairs")
+	bloom := fset.Bool("bloom", false, "skip database lookups for definitely missing pairs")
+	caseSensitive := fset.Bool("case-sensitive", false, "store user and name keys without lowercasing (unsafe to switch on existing data)")
+	keySep := fset.String("key-sep", KeySep, "set quoted user and name key separator, such as \\x00")
+	upstreamURL := fset.String("upstream", "", "set server URL to read pairs through from and proxy writes to")
+	upstreamTTL := fset.Duration("upstream-ttl", UpstreamTTL, "set time pairs read from upstream are kept")
+	events := fset.Bool("events", false, "record pair sets and deletes for the events endpoint")
+	seed := fset.String("seed", "", "set newline-delimited JSON file of pairs to create on startup if missing")
+	requireJSON := fset.Bool("require-json", false, "reject writes of values that are not valid JSON")
+	indexMessage := fset.String("index-message", "", "set index page message, overridden by the "+IndexName+" pair")
+	strictBucket := fset.Bool("strict-bucket", false, "fail writes with 503 until the bucket is created by the init endpoint")
+	index := fset.Bool("index", false, "maintain value prefix index for the find endpoint (doubles write cost)")
+	webhook := fset.String("webhook", "", "set URL to post JSON pair sets and deletes to")
+	redirectSlash := fset.Bool("redirect-slash", false, "redirect paths with a trailing slash instead of trimming it")
+	hostRouting := fset.Bool("host-routing", false, "scope users under the tenant in the request host")
+	logLevel := fset.String("log-level", "info", "set minimum log level (debug, info, warn, error)")
+	logFormat := fset.String("log-format", "text", "set log output format (text, json)")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+
+	// Record flags set on the command line, which config files cannot override.
+	cli := make(map[string]bool)
+	fset.Visit(func(flg *flag.Flag) {
+		cli[flg.Name] = true
+	})
+
+	// Fill unset flags from config file.
+	if *config != "" {
+		conf, err := LoadConfig(*config)
+		if err != nil {
+			return err
+		}
+
+		if err := ApplyConfig(fset, conf); err != nil {
+			return err
+		}
+	}
+
+	// Validate flags before setting globals.
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	switch {
+	case err != nil:
+		return fmt.Errorf("invalid socket mode %q", *socketMode)
+	case *backupDir != "" && *backupInterval <= 0:
+		return errors.New("backup interval must be positive")
+	case *maxHeader <= 0:
+		return errors.New("max header must be positive")
+	case *sweepInterval < 0:
+		return errors.New("sweep interval cannot be negative")
+	case *seed != "" && (*dir != "" || *readOnly):
+		return errors.New("seed cannot be used with dir or read-only")
+	}
+
+	sep, err := strconv.Unquote(`"` + *keySep + `"`)
+	if err != nil || sep == "" {
+		return fmt.Errorf("invalid key separator %q", *keySep)
+	}
+
+	if strings.Contains(*apiVersion, sep) {
+		return fmt.Errorf("invalid api version %q", *apiVersion)
+	}
+
+	upstream, err := url.Parse(*upstreamURL)
+	switch {
+	case *upstreamURL == "":
+	case err != nil || upstream.Scheme == "" || upstream.Host == "":
+		return fmt.Errorf("invalid upstream url %q", *upstreamURL)
+	case *dir != "" || *readOnly:
+		return errors.New("upstream cannot be used with dir or read-only")
+	}
+
+	if hook, err := url.Parse(*webhook); *webhook != "" && (err != nil || hook.Scheme == "" || hook.Host == "") {
+		return fmt.Errorf("invalid webhook url %q", *webhook)
+	}
+
+	logger, err := NewLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(logger)
+	QueueSize = *queueSize
+	QueueDelay = *queueDelay
+	MaxValue = *maxValue
+	MaxUserKeys = *maxUserKeys
+	MaxUserBytes = *maxUserBytes
+	MaxList = *maxList
+	MinGzip = *minGzip
+	Bucket = []byte(*bucket)
+	APIVersion = *apiVersion
+	Token = *token
+	MaxName = *maxName
+	ReadOnly = *readOnly
+	Addr = *addr
+	SocketMode = os.FileMode(mode)
+	TrustProxy = *trustProxy
+	TrimValues = !*noTrim
+	CORSOrigin = *corsOrigin
+	DryRun = *dryRun
+	WatchTimeout = *watchTimeout
+	ReadTimeout = *readTimeout
+	WriteTimeout = *writeTimeout
+	IdleTimeout = *idleTimeout
+	MaxConns = *maxConns
+	MaxHeader = *maxHeader
+	MaxBody = *maxBody
+	HostRouting = *hostRouting
+	BloomFilter = *bloom
+	LowerKeys = !*caseSensitive
+	KeySep = sep
+	EventLog = *events
+	IndexValues = *index
+	StrictBucket = *strictBucket
+	IndexMessage = *indexMessage
+	RequireJSON = *requireJSON
+	Upstream = *upstreamURL
+	UpstreamTTL = *upstreamTTL
+
+	// Start webhook, closed after the databases so it receives their final writes.
+	if *webhook != "" {
+		Hook = NewWebhook(*webhook, HookBuffer)
+		defer func() {
+			Hook.Close()
+			Hook = nil
+		}()
+	}
+
+	// Connect to and set database, unless serving a directory of stores.
+	stores := &Stores{Dir: *dir}
+	defer stores.Close()
+	if *dir == "" {
+		db, err := OpenDB(*path)
+		if err != nil {
+			return err
+		}
+
+		DB = db
+		defer func() {
+			stopDB(DB)
+			DB = nil
+		}()
+
+		// Refuse to start on a corrupted database.
+		if *check {
+			if err := CheckDB(DB); err != nil {
+				return err
+			}
+		}
+
+		// Move any flat pairs into user buckets and start write queue and filter.
+		if err := startDB(DB); err != nil {
+			return err
+		}
+
+		// Create any pairs in the seed file that do not exist yet.
+		if *seed != "" {
+			file, err := os.Open(*seed)
+			if err != nil {
+				return err
+			}
+
+			done, skip, err := SeedPairs(DB, file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+
+			slog.Info("database seeded", "path", *seed, "seeded", done, "skipped", skip)
+		}
+
+		// Create backup directory for scheduled snapshots.
+		if *backupDir != "" {
+			if err := os.MkdirAll(*backupDir, 0755); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Start scheduled snapshots of DB, following it through reloads and compactions.
+	if *dir == "" && *backupDir != "" {
+		backups := NewBackups(nil, *backupDir, *backupInterval, *backupKeep)
+		defer backups.Close()
+	}
+
+	// Start scheduled sweeps of expired pairs in DB, unless it is read-only.
+	if *dir == "" && *sweepInterval > 0 && !ReadOnly {
+		sweeper := NewSweeper(nil, *sweepInterval)
+		defer sweeper.Close()
+	}
+
+	// Initialise mux and register endpoints.
+	mux := NewMux()
+
+	// Initialise server and listener.
+	var handler http.Handler = Negotiate(mux)
+	if *dir != "" {
+		handler = RouteStores(stores, handler)
+	}
+
+	if Upstream != "" {
+		handler = FollowUpstream(upstream, handler)
+	}
+
+	handler = TrimSlash(*redirectSlash, HoldReload(handler))
+	handler = TraceRequests(LogRequests(CORS(RateLimit(*rate, handler))))
+	srv := NewServer(*addr, handler, *h2c)
+	lis, err := Listen(*addr, *tlsCert, *tlsKey)
+	if err != nil {
+		return err
+	}
+
+	// Reload config and database on hangup until the server stops.
+	hups := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(hups, syscall.SIGHUP)
+	go func() {
+		defer close(done)
+		for range hups {
+			if err := Reload(fset, cli, *config, stores); err != nil {
+				slog.Error("reload failed", "error", err)
+			}
+		}
+	}()
+
+	defer func() {
+		signal.Stop(hups)
+		close(hups)
+		<-done
+	}()
+
+	// Run server until interrupted, then close databases.
+	slog.Info("server started", "addr", lis.Addr().String())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	return Serve(srv, lis, sigs)
+}
+
+// main runs the main Gesedels program, exiting with a concise error message if it
+// fails.
+func main() {
+	startTime = time.Now()
+	if err := run(os.Args[1:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "gesedels: %s\n", err)
+		os.Exit(1)
+	}
 }
@@ -5,11 +5,37 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
+	"log/slog"
+	"math"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"go.etcd.io/bbolt"
@@ -32,15 +58,53 @@ func getResponse(w *httptest.ResponseRecorder) (int, string) {
 	return rslt.StatusCode, string(body)
 }
 
+// mockGzip returns a string compressed with gzip.
+func mockGzip(text string) []byte {
+	buff := new(bytes.Buffer)
+	gzw := gzip.NewWriter(buff)
+	gzw.Write([]byte(text))
+	gzw.Close()
+	return buff.Bytes()
+}
+
+// mockServe returns the ResponseRecorder of a Request served by a HandlerFunc on a pattern.
+func mockServe(ptrn string, hand http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ptrn, hand)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+// mockCert returns the paths of a temporary self-signed TLS certificate and key file.
+func mockCert(t *testing.T) (string, string) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	cert, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	pkey, _ := x509.MarshalPKCS8PrivateKey(priv)
+	certPath := filepath.Join(t.TempDir(), "cert.pem")
+	keyPath := filepath.Join(t.TempDir(), "key.pem")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkey}), 0600)
+	return certPath, keyPath
+}
+
 // mockDB returns a temporary mock databae populated with mockPairs.
 func mockDB(t *testing.T) *bbolt.DB {
 	dest := filepath.Join(t.TempDir(), "test.db")
-	db, _ := bbolt.Open(dest, 0666, nil)
+	db, _ := OpenDB(dest)
 
 	db.Update(func(tx *bbolt.Tx) error {
-		buck, _ := tx.CreateBucket([]byte("main"))
+		root, _ := tx.CreateBucket(Bucket)
 		for pkey, pval := range mockPairs {
-			buck.Put([]byte(pkey), []byte(pval))
+			user, name, _ := strings.Cut(pkey, ":")
+			buck, _ := root.CreateBucketIfNotExists([]byte(user))
+			buck.Put([]byte(name), []byte(pval))
 		}
 
 		return nil
@@ -49,6 +113,17 @@ func mockDB(t *testing.T) *bbolt.DB {
 	return db
 }
 
+// mockFree writes and deletes many pairs in a database, leaving free pages.
+func mockFree(db *bbolt.DB) {
+	pairs := make(map[string]string)
+	for i := range 1000 {
+		pairs[fmt.Sprintf("name%d", i)] = strings.Repeat("x", 1000)
+	}
+
+	SetPairs(db, "1111", pairs)
+	DeleteUser(db, "1111")
+}
+
 ///////////////////////////////////////////////////////////////////////////////////////
 //                          part one · constants and globals                         //
 ///////////////////////////////////////////////////////////////////////////////////////
@@ -57,6 +132,37 @@ func mockDB(t *testing.T) *bbolt.DB {
 //                      part two · string sanitisation functions                     //
 ///////////////////////////////////////////////////////////////////////////////////////
 
+func TestCanonicalPath(t *testing.T) {
+	// success
+	for path, want := range map[string]string{
+		"":            "",
+		"/":           "/",
+		"//":          "/",
+		"/user":       "/user",
+		"/user/":      "/user",
+		"/user/name":  "/user/name",
+		"/user/name/": "/user/name",
+		"/user//":     "/user/",
+	} {
+		assert.Equal(t, want, CanonicalPath(path), path)
+	}
+}
+
+func TestEscapeName(t *testing.T) {
+	// success
+	for name, want := range map[string]string{
+		"name":    "name",
+		"a/b":     "a%2Fb",
+		"a b":     "a%20b",
+		"?#%":     "%3F%23%25",
+		"a\nb":    "a%0Ab",
+		"ünïcode": "%C3%BCn%C3%AFcode",
+	} {
+		text := EscapeName(name)
+		assert.Equal(t, want, text)
+	}
+}
+
 func TestIsPrivate(t *testing.T) {
 	// success - true
 	ok := IsPrivate("__test__")
@@ -69,121 +175,5940 @@ func TestIsPrivate(t *testing.T) {
 	}
 }
 
+func TestJoinNames(t *testing.T) {
+	// success
+	text := JoinNames([]string{"alpha", "a/b c"})
+	assert.Equal(t, "alpha\na%2Fb%20c", text)
+}
+
+func TestNameKey(t *testing.T) {
+	// success
+	nkey := NameKey("NAME")
+	assert.Equal(t, []byte("name"), nkey)
+
+	// success - case preserved
+	LowerKeys = false
+	defer func() { LowerKeys = true }()
+	nkey = NameKey("NAME")
+	assert.Equal(t, []byte("NAME"), nkey)
+}
+
 func TestPairKey(t *testing.T) {
 	// success
 	pkey := PairKey("USER", "NAME")
 	assert.Equal(t, []byte("user:name"), pkey)
+
+	// success - case preserved
+	LowerKeys = false
+	defer func() { LowerKeys = true }()
+	pkey = PairKey("USER", "NAME")
+	assert.Equal(t, []byte("USER:NAME"), pkey)
+
+	// success - custom separator
+	KeySep = "\x00"
+	defer func() { KeySep = ":" }()
+	pkey = PairKey("user", "a:b")
+	assert.Equal(t, []byte("user\x00a:b"), pkey)
 }
 
 func TestPairValue(t *testing.T) {
 	// success
 	pval := PairValue("\tValue.\n")
 	assert.Equal(t, []byte("Value.\n"), pval)
+
+	// success - trimming disabled
+	TrimValues = false
+	defer func() { TrimValues = true }()
+	pval = PairValue("\tValue.")
+	assert.Equal(t, []byte("\tValue."), pval)
+}
+
+func TestTenantFromHost(t *testing.T) {
+	// success
+	for host, want := range map[string]string{
+		"acme.gesedels.example":      "acme",
+		"ACME.gesedels.example:8080": "acme",
+		"acme.gesedels.example.":     "acme",
+		"gesedels.example":           "",
+		"localhost:8080":             "",
+		"127.0.0.1:8080":             "",
+		"[::1]:8080":                 "",
+		"":                           "",
+	} {
+		tenant := TenantFromHost(host)
+		assert.Equal(t, want, tenant, host)
+	}
+}
+
+func TestValidName(t *testing.T) {
+	// success - true
+	for _, name := range []string{"name", "__name__", strings.Repeat("a", MaxName)} {
+		ok := ValidName(name)
+		assert.True(t, ok)
+	}
+
+	// success - false
+	for _, name := range []string{"", "x:admin", ":", strings.Repeat("a", MaxName+1)} {
+		ok := ValidName(name)
+		assert.False(t, ok)
+	}
+
+	// success - custom separator
+	KeySep = "\x00"
+	defer func() { KeySep = ":" }()
+	assert.True(t, ValidName("x:admin"))
+	assert.False(t, ValidName("x\x00admin"))
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////
-//                      part three · database handling functions                     //
+//                       part three · value encoding functions                       //
 ///////////////////////////////////////////////////////////////////////////////////////
 
-func TestDeletePair(t *testing.T) {
+func TestDecodeValue(t *testing.T) {
+	// setup
+	expy := time.Unix(0, 1234567890)
+
+	// success - plain data
+	vval := DecodeValue([]byte("Value.\n"))
+	assert.Equal(t, Value{Data: []byte("Value.\n")}, vval)
+
+	// success - data with header
+	bytes := []byte{0x00, flagExpiry, 0, 0, 0, 0, 0x49, 0x96, 0x02, 0xd2, 'V', '.'}
+	vval = DecodeValue(bytes)
+	assert.Equal(t, []byte("V."), vval.Data)
+	assert.True(t, expy.Equal(vval.Expiry))
+
+	// success - data with full header
+	bytes = []byte{0x00, flagExpiry | flagModified, 0, 0, 0, 0, 0x49, 0x96, 0x02, 0xd2, 0, 0, 0, 0, 0x49, 0x96, 0x02, 0xd2, 'V'}
+	vval = DecodeValue(bytes)
+	assert.Equal(t, []byte("V"), vval.Data)
+	assert.True(t, expy.Equal(vval.Expiry))
+	assert.True(t, expy.Equal(vval.Modified))
+
+	// success - raw data
+	vval = DecodeValue([]byte{0x00, flagRaw, ' ', 'V'})
+	assert.Equal(t, Value{Data: []byte(" V"), Raw: true}, vval)
+
+	// success - data with content type
+	vval = DecodeValue([]byte{0x00, flagType, 3, 'a', '/', 'b', 'V'})
+	assert.Equal(t, Value{Data: []byte("V"), Type: "a/b"}, vval)
+
+	// success - gzip data
+	vval = DecodeValue([]byte{0x00, flagGzip, 'V'})
+	assert.Equal(t, Value{Data: []byte("V"), Gzip: true}, vval)
+
+	// success - malformed content type
+	vval = DecodeValue([]byte{0x00, flagType, 3, 'a'})
+	assert.Equal(t, Value{Data: []byte{0x00, flagType, 3, 'a'}}, vval)
+
+	// success - malformed header
+	vval = DecodeValue([]byte{0x00, flagExpiry, 'V'})
+	assert.Equal(t, []byte{0x00, flagExpiry, 'V'}, vval.Data)
+	assert.True(t, vval.Expiry.IsZero())
+}
+
+func TestEncodeValue(t *testing.T) {
+	// setup
+	expy := time.Unix(0, 1234567890)
+
+	// success - plain data
+	bytes := EncodeValue(Value{Data: []byte("Value.\n")})
+	assert.Equal(t, []byte("Value.\n"), bytes)
+
+	// success - data with header
+	bytes = EncodeValue(Value{Data: []byte("V."), Expiry: expy})
+	assert.Equal(t, []byte{0x00, flagExpiry, 0, 0, 0, 0, 0x49, 0x96, 0x02, 0xd2, 'V', '.'}, bytes)
+
+	// success - data with full header
+	bytes = EncodeValue(Value{Data: []byte("V"), Expiry: expy, Modified: expy})
+	assert.Equal(t, []byte{0x00, flagExpiry | flagModified, 0, 0, 0, 0, 0x49, 0x96, 0x02, 0xd2, 0, 0, 0, 0, 0x49, 0x96, 0x02, 0xd2, 'V'}, bytes)
+
+	// success - raw data
+	bytes = EncodeValue(Value{Data: []byte(" V"), Raw: true})
+	assert.Equal(t, []byte{0x00, flagRaw, ' ', 'V'}, bytes)
+
+	// success - data with content type
+	bytes = EncodeValue(Value{Data: []byte("V"), Type: "a/b"})
+	assert.Equal(t, []byte{0x00, flagType, 3, 'a', '/', 'b', 'V'}, bytes)
+
+	// success - gzip data
+	bytes = EncodeValue(Value{Data: []byte("V"), Gzip: true})
+	assert.Equal(t, []byte{0x00, flagGzip, 'V'}, bytes)
+
+	// success - data with long content type
+	bytes = EncodeValue(Value{Data: []byte("V"), Type: strings.Repeat("a", 256)})
+	assert.Equal(t, []byte("V"), bytes)
+
+	// success - data with leading magic byte
+	bytes = EncodeValue(Value{Data: []byte{0x00}})
+	assert.Equal(t, []byte{0x00, 0x00, 0x00}, bytes)
+	assert.Equal(t, []byte{0x00}, DecodeValue(bytes).Data)
+}
+
+func TestExtractPath(t *testing.T) {
+	// setup
+	doc := []byte(`{"a": {"b": [1, {"c": "<C>"}]}, "d": null}`)
+
+	// success - object field
+	data, err := ExtractPath(doc, "a.b")
+	assert.Equal(t, `[1,{"c":"<C>"}]`, string(data))
+	assert.NoError(t, err)
+
+	// success - array index
+	data, err = ExtractPath(doc, "a.b.1.c")
+	assert.Equal(t, `"<C>"`, string(data))
+	assert.NoError(t, err)
+
+	// success - null field
+	data, err = ExtractPath(doc, "d")
+	assert.Equal(t, "null", string(data))
+	assert.NoError(t, err)
+
+	// failure - missing field
+	_, err = ExtractPath(doc, "a.nope")
+	assert.ErrorIs(t, err, ErrNoPath)
+
+	// failure - invalid index
+	_, err = ExtractPath(doc, "a.b.2")
+	assert.ErrorIs(t, err, ErrNoPath)
+
+	// failure - path through scalar
+	_, err = ExtractPath(doc, "a.b.0.c")
+	assert.ErrorIs(t, err, ErrNoPath)
+
+	// failure - invalid json
+	_, err = ExtractPath([]byte("nope"), "a")
+	assert.ErrorIs(t, err, ErrNotJSON)
+}
+
+func TestGunzipValue(t *testing.T) {
+	// success - gzip value
+	vval, err := GunzipValue(Value{Data: mockGzip("Test.\n"), Gzip: true, Type: "a/b"})
+	assert.Equal(t, Value{Data: []byte("Test.\n"), Type: "a/b"}, vval)
+	assert.NoError(t, err)
+
+	// success - plain value
+	vval, err = GunzipValue(Value{Data: []byte("Test.\n")})
+	assert.Equal(t, Value{Data: []byte("Test.\n")}, vval)
+	assert.NoError(t, err)
+
+	// failure - invalid gzip
+	_, err = GunzipValue(Value{Data: []byte("nope"), Gzip: true})
+	assert.ErrorIs(t, err, ErrNotGzip)
+
+	// failure - truncated gzip
+	data := mockGzip("Test.\n")
+	_, err = GunzipValue(Value{Data: data[:len(data)-4], Gzip: true})
+	assert.ErrorIs(t, err, ErrNotGzip)
+
+	// failure - decompresses over MaxValue
+	data = mockGzip(strings.Repeat("0", MaxValue+1))
+	assert.Less(t, len(data), MaxValue/100)
+	_, err = GunzipValue(Value{Data: data, Gzip: true})
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestMergePatch(t *testing.T) {
+	// success - member replaced and added
+	data, err := MergePatch([]byte(`{"a":"b","c":1}`), []byte(`{"a":"z","d":2.50}`))
+	assert.JSONEq(t, `{"a":"z","c":1,"d":2.50}`, string(data))
+	assert.Contains(t, string(data), `2.50`)
+	assert.NoError(t, err)
+
+	// success - null deletes member
+	data, err = MergePatch([]byte(`{"a":"b","c":"d"}`), []byte(`{"a":null,"e":null}`))
+	assert.Equal(t, `{"c":"d"}`, string(data))
+	assert.NoError(t, err)
+
+	// success - nested objects
+	data, err = MergePatch(
+		[]byte(`{"a":{"b":"c","d":{"e":"f"}},"g":["h"]}`),
+		[]byte(`{"a":{"b":null,"d":{"i":"j"}},"g":{"k":"<l>"}}`),
+	)
+	assert.Equal(t, `{"a":{"d":{"e":"f","i":"j"}},"g":{"k":"<l>"}}`, string(data))
+	assert.NoError(t, err)
+
+	// success - non-object patch replaces document
+	data, err = MergePatch([]byte(`{"a":"b"}`), []byte(`["c"]`))
+	assert.Equal(t, `["c"]`, string(data))
+	assert.NoError(t, err)
+
+	// success - object patch replaces non-object document
+	data, err = MergePatch([]byte("1\n"), []byte(`{"a":"b","c":null}`))
+	assert.Equal(t, `{"a":"b"}`, string(data))
+	assert.NoError(t, err)
+
+	// failure - document is not JSON
+	data, err = MergePatch([]byte("Alpha.\n"), []byte(`{"a":"b"}`))
+	assert.Nil(t, data)
+	assert.ErrorIs(t, err, ErrNotJSON)
+
+	// failure - patch is not JSON
+	data, err = MergePatch([]byte(`{"a":"b"}`), []byte(`{"a"`))
+	assert.Nil(t, data)
+	assert.EqualError(t, err, "patch is not valid json")
+}
+
+func TestPairETag(t *testing.T) {
+	// success
+	etag := PairETag([]byte("Alpha.\n"))
+	assert.Regexp(t, `^W/"[0-9a-f]{16}"$`, etag)
+
+	// success - stable for equal data
+	assert.Equal(t, etag, PairETag([]byte("Alpha.\n")))
+
+	// success - different for different data
+	assert.NotEqual(t, etag, PairETag([]byte("Bravo.\n")))
+}
+
+func TestValidateJSON(t *testing.T) {
+	// success
+	err := ValidateJSON([]byte(`{"a": [1, 2]}`))
+	assert.NoError(t, err)
+
+	// failure - invalid json
+	for _, body := range []string{"", "Alpha.", `{"a": 1`, `{} {}`} {
+		err := ValidateJSON([]byte(body))
+		assert.ErrorIs(t, err, ErrNotJSON)
+	}
+}
+
+func TestValueExpired(t *testing.T) {
+	// success - true
+	ok := Value{Expiry: time.Now().Add(-time.Hour)}.Expired()
+	assert.True(t, ok)
+
+	// success - false
+	for _, expy := range []time.Time{{}, time.Now().Add(time.Hour)} {
+		ok := Value{Expiry: expy}.Expired()
+		assert.False(t, ok)
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////////////
+//                      part four · database handling functions                      //
+///////////////////////////////////////////////////////////////////////////////////////
+
+func TestBackupsClose(t *testing.T) {
 	// setup
 	db := mockDB(t)
+	backups := NewBackups(db, t.TempDir(), time.Hour, 1)
 
 	// success
-	err := DeletePair(db, "0000", "alpha")
+	backups.Close()
+	_, ok := <-backups.done
+	assert.False(t, ok)
+}
+
+func TestBloomAdd(t *testing.T) {
+	// setup
+	bloom := &Bloom{bits: make([]atomic.Uint64, 16)}
+
+	// success
+	bloom.Add([]byte("0000:test"))
+	assert.True(t, bloom.Has([]byte("0000:test")))
+}
+
+func TestBloomClose(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	bloom, _ := NewBloom(db)
+
+	// success
+	bloom.Close()
+	_, ok := Blooms.Load(db)
+	assert.False(t, ok)
+}
+
+func TestBloomHas(t *testing.T) {
+	// setup
+	bloom := &Bloom{bits: make([]atomic.Uint64, 16)}
+	bloom.Add([]byte("0000:alpha"))
+
+	// success - true
+	ok := bloom.Has([]byte("0000:alpha"))
+	assert.True(t, ok)
+
+	// success - false
+	ok = bloom.Has([]byte("0000:nope"))
+	assert.False(t, ok)
+}
+
+func TestQueueClose(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	queue := NewQueue(db, 10, time.Millisecond)
+
+	// success
+	queue.Close()
+	_, ok := Queues.Load(db)
+	assert.False(t, ok)
+	_, ok = <-queue.done
+	assert.False(t, ok)
+}
+
+func TestQueueSubmit(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	queue := NewQueue(db, 10, time.Millisecond)
+	defer queue.Close()
+
+	// success
+	err := queue.Submit(func(tx *bbolt.Tx) error { return deletePair(tx, "0000", "alpha") })
 	assert.NoError(t, err)
 
-	// success - check database
-	db.View(func(tx *bbolt.Tx) error {
-		buck := tx.Bucket([]byte("main"))
-		bytes := buck.Get([]byte("0000:alpha"))
-		assert.Nil(t, bytes)
-		return nil
-	})
+	// failure - batch error isolated to request
+	errc := make(chan error)
+	go func() { errc <- queue.Submit(func(tx *bbolt.Tx) error { return errors.New("error") }) }()
+	go func() { errc <- queue.Submit(func(tx *bbolt.Tx) error { return deletePair(tx, "0000", "bravo") }) }()
+	errs := []error{<-errc, <-errc}
+	assert.ElementsMatch(t, []error{nil, errors.New("error")}, errs)
+	_, ok, _ := GetPair(db, "0000", "bravo")
+	assert.False(t, ok)
 }
 
-func TestGetPair(t *testing.T) {
+func TestTxErrorError(t *testing.T) {
+	// success
+	err := &TxError{1, errors.New("error")}
+	assert.EqualError(t, err, "operation 1: error")
+}
+
+func TestTxErrorUnwrap(t *testing.T) {
+	// success
+	err := &TxError{1, ErrNotInteger}
+	assert.ErrorIs(t, err, ErrNotInteger)
+}
+
+func TestSweeperClose(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	sweeper := NewSweeper(db, time.Hour)
+
+	// success
+	sweeper.Close()
+	_, ok := <-sweeper.done
+	assert.False(t, ok)
+}
+
+func TestWebhookClose(t *testing.T) {
+	// setup
+	hook := NewWebhook("http://127.0.0.1:1", 10)
+
+	// success
+	hook.Close()
+	assert.Error(t, hook.ctx.Err())
+	_, ok := <-hook.done
+	assert.False(t, ok)
+}
+
+func TestWebhookSend(t *testing.T) {
+	// setup
+	HookBackoff = time.Millisecond
+	defer func() { HookBackoff = time.Second }()
+	fails := 1
+	bodies := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fails > 0 {
+			fails--
+			WriteError(w, http.StatusInternalServerError, "fail")
+			return
+		}
+
+		data, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		bodies <- string(data)
+	}))
+	defer srv.Close()
+	hook := NewWebhook(srv.URL, 10)
+	defer hook.Close()
+
+	// success - delivered after retry
+	hook.Send(Event{Time: 1000, Op: "set", User: "0000", Name: "alpha"})
+	assert.Equal(t, `{"time":1000,"op":"set","user":"0000","name":"alpha"}`, <-bodies)
+
+	// success - dropped when full
+	full := &Webhook{evnts: make(chan Event)}
+	assert.NotPanics(t, func() { full.Send(Event{Op: "set"}) })
+}
+
+func TestAppendPair(t *testing.T) {
 	// setup
 	db := mockDB(t)
 
 	// success - pair exists
-	pval, ok, err := GetPair(db, "0000", "alpha")
-	assert.Equal(t, "Alpha.\n", pval)
-	assert.True(t, ok)
+	err := AppendPair(db, "0000", "alpha", "Alpha 2.")
 	assert.NoError(t, err)
 
 	// success - pair does not exist
-	pval, ok, err = GetPair(db, "0000", "nope")
-	assert.Empty(t, pval)
-	assert.False(t, ok)
+	err = AppendPair(db, "0000", "test", "\tTest.\n")
 	assert.NoError(t, err)
+
+	// success - check database
+	for name, want := range map[string]string{"alpha": "Alpha.\nAlpha 2.\n", "test": "Test.\n"} {
+		pval, _, _ := GetPair(db, "0000", name)
+		assert.Equal(t, want, pval)
+	}
 }
 
-func TestSetPair(t *testing.T) {
+func TestApplyTx(t *testing.T) {
 	// setup
-	db := mockDB(t)
+	DB = mockDB(t)
 
 	// success
-	err := SetPair(db, "0000", "test", "Test.\n")
+	err := ApplyTx(DB, []TxOp{
+		{Op: "set", User: "0000", Name: "test", Value: "Test."},
+		{Op: "delete", User: "0000", Name: "alpha"},
+	})
 	assert.NoError(t, err)
 
 	// success - check database
-	db.View(func(tx *bbolt.Tx) error {
-		buck := tx.Bucket([]byte("main"))
-		bytes := buck.Get([]byte("0000:test"))
-		assert.Equal(t, []byte("Test.\n"), bytes)
-		return nil
+	pval, _, _ := GetPair(DB, "0000", "test")
+	assert.Equal(t, "Test.\n", pval)
+	_, ok, _ := GetPair(DB, "0000", "alpha")
+	assert.False(t, ok)
+
+	// failure - invalid operation
+	var txe *TxError
+	err = ApplyTx(DB, []TxOp{
+		{Op: "delete", User: "0000", Name: "bravo"},
+		{Op: "nope", User: "0000", Name: "test"},
 	})
-}
+	assert.ErrorAs(t, err, &txe)
+	assert.Equal(t, 1, txe.Index)
+	assert.EqualError(t, err, `operation 1: invalid op "nope"`)
 
-///////////////////////////////////////////////////////////////////////////////////////
-//                        part four · http response functions                        //
-///////////////////////////////////////////////////////////////////////////////////////
+	// success - check database
+	_, ok, _ = GetPair(DB, "0000", "bravo")
+	assert.True(t, ok)
 
-func TestWriteHTTP(t *testing.T) {
+	// failure - invalid name
+	err = ApplyTx(DB, []TxOp{{Op: "set", User: "0000", Name: "x:admin", Value: "Admin."}})
+	assert.EqualError(t, err, `operation 0: invalid name "x:admin"`)
+
+	// failure - empty value
+	err = ApplyTx(DB, []TxOp{{Op: "set", User: "0000", Name: "test"}})
+	assert.EqualError(t, err, "operation 0: value is empty")
+}
+
+func TestBackupDB(t *testing.T) {
 	// setup
-	w := httptest.NewRecorder()
+	db := mockDB(t)
+	dest := filepath.Join(t.TempDir(), "backup.db")
+	buff := new(bytes.Buffer)
 
 	// success
-	WriteHTTP(w, http.StatusOK, "%s", "test")
-	code, body := getResponse(w)
-	assert.Equal(t, http.StatusOK, code)
-	assert.Equal(t, "test\n", body)
+	err := BackupDB(db, buff)
+	assert.NoError(t, err)
+
+	// success - check backup
+	os.WriteFile(dest, buff.Bytes(), 0600)
+	back, _ := OpenDB(dest)
+	defer back.Close()
+	pval, _, _ := GetPair(back, "0000", "alpha")
+	assert.Equal(t, "Alpha.\n", pval)
+
+	// failure - database error
+	db.Close()
+	err = BackupDB(db, buff)
+	assert.Error(t, err)
 }
 
-func TestWriteError(t *testing.T) {
+func TestBackupFile(t *testing.T) {
 	// setup
-	w := httptest.NewRecorder()
+	db := mockDB(t)
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "backup.db")
+	os.WriteFile(dest, []byte("old"), 0600)
 
 	// success
-	WriteError(w, http.StatusInternalServerError, "%s", "test")
-	code, body := getResponse(w)
-	assert.Equal(t, http.StatusInternalServerError, code)
-	assert.Equal(t, "server error 500: test\n", body)
+	err := BackupFile(db, dest)
+	assert.NoError(t, err)
+
+	// success - check backup
+	back, _ := OpenDB(dest)
+	pval, _, _ := GetPair(back, "0000", "bravo")
+	assert.Equal(t, "Bravo.\n", pval)
+	back.Close()
+
+	// success - no temporary files left
+	elems, _ := os.ReadDir(dir)
+	assert.Len(t, elems, 1)
+
+	// failure - missing directory
+	err = BackupFile(db, filepath.Join(dir, "nope", "backup.db"))
+	assert.Error(t, err)
 }
 
-func TestWriteFailure(t *testing.T) {
+func TestCheckDB(t *testing.T) {
 	// setup
-	w := httptest.NewRecorder()
+	DB = mockDB(t)
 
 	// success
-	WriteFailure(w, http.StatusBadRequest, "%s", "test")
-	code, body := getResponse(w)
-	assert.Equal(t, http.StatusBadRequest, code)
-	assert.Equal(t, "client error 400: test\n", body)
-}
+	err := CheckDB(DB)
+	assert.NoError(t, err)
 
-///////////////////////////////////////////////////////////////////////////////////////
-//                       part five · server endpoint functions                       //
-///////////////////////////////////////////////////////////////////////////////////////
+	// failure - database error
+	DB.Close()
+	err = CheckDB(DB)
+	assert.Error(t, err)
+}
 
-func TestGetIndex(t *testing.T) {
+func TestCompactDB(t *testing.T) {
 	// setup
-	w := httptest.NewRecorder()
+	db := mockDB(t)
+	mockFree(db)
+	dest := filepath.Join(t.TempDir(), "compact.db")
 
 	// success
-	GetIndex(w, nil)
-	code, body := getResponse(w)
-	assert.Equal(t, http.StatusOK, code)
-	assert.NotEmpty(t, body)
+	before, after, err := CompactDB(db, dest)
+	assert.Less(t, after, before)
+	assert.NoError(t, err)
+
+	// success - check compacted database
+	comp, _ := OpenDB(dest)
+	pval, _, _ := GetPair(comp, "0000", "alpha")
+	assert.Equal(t, "Alpha.\n", pval)
+	comp.Close()
+
+	// failure - invalid destination
+	_, _, err = CompactDB(db, filepath.Join(dest, "nope"))
+	assert.Error(t, err)
+}
+
+func TestCopyAcross(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+
+	// success
+	ok, err := CopyAcross(DB, "0000", "alpha", "1111", "test")
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	pval, _, _ := GetPair(DB, "1111", "test")
+	assert.Equal(t, "Alpha.\n", pval)
+
+	// success - pair does not exist
+	ok, err = CopyAcross(DB, "0000", "nope", "1111", "nope")
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// failure - destination exists
+	ok, err = CopyAcross(DB, "0000", "bravo", "1111", "test")
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, ErrPairExists)
+	pval, _, _ = GetPair(DB, "1111", "test")
+	assert.Equal(t, "Alpha.\n", pval)
+}
+
+func TestCopyPair(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+
+	// success
+	ok, err := CopyPair(DB, "0000", "alpha", "test", false)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	pval, _, _ := GetPair(DB, "0000", "test")
+	assert.Equal(t, "Alpha.\n", pval)
+	pval, _, _ = GetPair(DB, "0000", "alpha")
+	assert.Equal(t, "Alpha.\n", pval)
+
+	// success - overwrite
+	ok, err = CopyPair(DB, "0000", "alpha", "bravo", true)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	pval, _, _ = GetPair(DB, "0000", "bravo")
+	assert.Equal(t, "Alpha.\n", pval)
+
+	// success - pair does not exist
+	ok, err = CopyPair(DB, "0000", "nope", "test", false)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// failure - destination exists
+	ok, err = CopyPair(DB, "0000", "alpha", "test", false)
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, ErrPairExists)
+}
+
+func TestCountKeys(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPair(db, "1111", "test", "Test.\n")
+
+	// success
+	size, err := CountKeys(db)
+	assert.Equal(t, 3, size)
+	assert.NoError(t, err)
+}
+
+func TestCountPairs(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	SetPairTTL(DB, "0000", "temp", "Temp.", -time.Hour)
+
+	// success
+	size, err := CountPairs(DB, "0000")
+	assert.Equal(t, 2, size)
+	assert.NoError(t, err)
+
+	// success - user does not exist
+	size, err = CountPairs(DB, "nope")
+	assert.Zero(t, size)
+	assert.NoError(t, err)
+}
+
+func TestCreatePair(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPairTTL(db, "0000", "temp", "Temp.", -time.Hour)
+
+	// success - pair created
+	ok, err := CreatePair(db, "0000", "test", "Test.")
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	pval, _, _ := GetPair(db, "0000", "test")
+	assert.Equal(t, "Test.\n", pval)
+
+	// success - expired pair replaced
+	ok, err = CreatePair(db, "0000", "temp", "Test.")
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - pair exists
+	ok, err = CreatePair(db, "0000", "alpha", "Test.")
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	pval, _, _ = GetPair(db, "0000", "alpha")
+	assert.Equal(t, "Alpha.\n", pval)
+}
+
+func TestCreatePairValue(t *testing.T) {
+	// setup
+	db := mockDB(t)
+
+	// success - pair created
+	ok, err := CreatePairValue(db, "0000", "test", Value{Data: []byte(" Raw.\n"), Raw: true})
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	vval, _, _ := GetPairValue(db, "0000", "test")
+	assert.Equal(t, []byte(" Raw.\n"), vval.Data)
+	assert.True(t, vval.Raw)
+
+	// success - pair exists
+	ok, err = CreatePairValue(db, "0000", "alpha", Value{Data: []byte("Test.\n")})
+	assert.False(t, ok)
+	assert.NoError(t, err)
+}
+
+func TestDBStats(t *testing.T) {
+	// setup
+	db := mockDB(t)
+
+	// success
+	stats, err := DBStats(db)
+	assert.Positive(t, stats["size"])
+	assert.Contains(t, stats, "free_pages")
+	assert.Contains(t, stats, "tx_count")
+	assert.NoError(t, err)
+
+	// success - check bucket statistics
+	bucks := stats["buckets"].(map[string]any)
+	buck := bucks["main"].(map[string]any)
+	assert.Equal(t, 3, buck["keys"])
+	assert.Equal(t, 1, buck["inline_buckets"])
+
+	// failure - database error
+	db.Close()
+	_, err = DBStats(db)
+	assert.Error(t, err)
+}
+
+func TestDeletePair(t *testing.T) {
+	// setup
+	db := mockDB(t)
+
+	// success
+	err := DeletePair(db, "0000", "alpha")
+	assert.NoError(t, err)
+
+	// success - check database
+	db.View(func(tx *bbolt.Tx) error {
+		buck := tx.Bucket(Bucket).Bucket([]byte("0000"))
+		bytes := buck.Get([]byte("alpha"))
+		assert.Nil(t, bytes)
+		return nil
+	})
+
+	// success - empty user bucket deleted
+	err = DeletePair(db, "0000", "bravo")
+	assert.NoError(t, err)
+	db.View(func(tx *bbolt.Tx) error {
+		buck := tx.Bucket(Bucket).Bucket([]byte("0000"))
+		assert.Nil(t, buck)
+		return nil
+	})
+}
+
+func TestDeleteUser(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPair(db, "1111", "test", "Test.\n")
+
+	// success
+	size, err := DeleteUser(db, "0000")
+	assert.Equal(t, 2, size)
+	assert.NoError(t, err)
+
+	// success - check database
+	names, _, _ := ListPairs(db, "0000", "", 0)
+	assert.Empty(t, names)
+	names, _, _ = ListPairs(db, "1111", "", 0)
+	assert.Equal(t, []string{"test"}, names)
+
+	// success - user does not exist
+	size, err = DeleteUser(db, "2222")
+	assert.Zero(t, size)
+	assert.NoError(t, err)
+
+	// failure - empty user
+	size, err = DeleteUser(db, "")
+	assert.Zero(t, size)
+	assert.EqualError(t, err, "cannot delete empty user")
+}
+
+func TestExpandValue(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	SetPair(DB, "0000", "nest", "${alpha} and ${1111:ref}")
+	SetPair(DB, "1111", "ref", "${deep}")
+	SetPair(DB, "1111", "deep", "Deep.")
+	SetPair(DB, "0000", "self", "${self}")
+	SetPair(DB, "0000", "loop1", "${loop2}")
+	SetPair(DB, "0000", "loop2", "${loop1}")
+	SetPair(DB, "0000", "twice", "${alpha}${alpha}")
+
+	// success - no references
+	text, err := ExpandValue(DB, "0000", "Plain $text {}", ExpandDepth)
+	assert.Equal(t, "Plain $text {}", text)
+	assert.NoError(t, err)
+
+	// success - local reference
+	text, err = ExpandValue(DB, "0000", "<${alpha}>", ExpandDepth)
+	assert.Equal(t, "<Alpha.>", text)
+	assert.NoError(t, err)
+
+	// success - user reference
+	text, err = ExpandValue(DB, "1111", "<${0000:bravo}>", ExpandDepth)
+	assert.Equal(t, "<Bravo.>", text)
+	assert.NoError(t, err)
+
+	// success - nested references relative to their own user
+	text, err = ExpandValue(DB, "0000", "${nest}", ExpandDepth)
+	assert.Equal(t, "Alpha. and Deep.", text)
+	assert.NoError(t, err)
+
+	// success - repeated references
+	text, err = ExpandValue(DB, "0000", "${twice}", ExpandDepth)
+	assert.Equal(t, "Alpha.Alpha.", text)
+	assert.NoError(t, err)
+
+	// failure - missing reference
+	_, err = ExpandValue(DB, "0000", "${nope}", ExpandDepth)
+	assert.ErrorIs(t, err, ErrMissingRef)
+	assert.EqualError(t, err, "reference does not exist: 0000:nope")
+
+	// failure - nested missing reference
+	SetPair(DB, "0000", "miss", "${1111:nope}")
+	_, err = ExpandValue(DB, "0000", "${miss}", ExpandDepth)
+	assert.ErrorIs(t, err, ErrMissingRef)
+
+	// failure - self reference
+	_, err = ExpandValue(DB, "0000", "${self}", ExpandDepth)
+	assert.ErrorIs(t, err, ErrLoop)
+
+	// failure - reference cycle
+	_, err = ExpandValue(DB, "0000", "${loop1}", ExpandDepth)
+	assert.ErrorIs(t, err, ErrLoop)
+
+	// failure - too deep
+	_, err = ExpandValue(DB, "0000", "${nest}", 1)
+	assert.ErrorIs(t, err, ErrLoop)
+
+	// failure - database error
+	DB.Close()
+	_, err = ExpandValue(DB, "0000", "${alpha}", ExpandDepth)
+	assert.Error(t, err)
+}
+
+func TestExportEvents(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	EventLog = true
+	defer func() { EventLog = false }()
+	SetPair(DB, "0000", "alpha", "Alpha.\n")
+	DeletePair(DB, "0000", "bravo")
+	buff := new(bytes.Buffer)
+
+	// success
+	err := ExportEvents(DB, 0, buff)
+	lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Regexp(t, `^{"seq":1,"time":\d+,"op":"set","user":"0000","name":"alpha"}$`, lines[0])
+	assert.Regexp(t, `^{"seq":2,"time":\d+,"op":"delete","user":"0000","name":"bravo"}$`, lines[1])
+	assert.NoError(t, err)
+
+	// success - after sequence
+	buff.Reset()
+	err = ExportEvents(DB, 1, buff)
+	assert.Contains(t, buff.String(), `"seq":2`)
+	assert.NotContains(t, buff.String(), `"seq":1`)
+	assert.NoError(t, err)
+
+	// success - no events bucket
+	buff.Reset()
+	err = ExportEvents(mockDB(t), 0, buff)
+	assert.Empty(t, buff.String())
+	assert.NoError(t, err)
+
+	// failure - database error
+	DB.Close()
+	err = ExportEvents(DB, 0, buff)
+	assert.Error(t, err)
+}
+
+func TestExportPairs(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPairTTL(db, "1111", "temp", "Temp.\n", -time.Hour)
+	buff := new(bytes.Buffer)
+
+	// success
+	err := ExportPairs(db, buff)
+	assert.Equal(t, strings.Join([]string{
+		`{"user":"0000","name":"alpha","value":"Alpha.\n"}`,
+		`{"user":"0000","name":"bravo","value":"Bravo.\n"}`,
+	}, "\n")+"\n", buff.String())
+	assert.NoError(t, err)
+
+	// success - modification times
+	buff.Reset()
+	SetPairValue(db, "1111", "test", Value{Data: []byte("Test.\n"), Modified: time.Unix(0, 1000)})
+	err = ExportPairs(db, buff)
+	assert.Contains(t, buff.String(), `{"user":"1111","name":"test","value":"Test.\n","modified":1000}`)
+	assert.NoError(t, err)
+}
+
+func TestExportUser(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPairTTL(db, "0000", "temp", "Temp.\n", -time.Hour)
+	SetPair(db, "1111", "test", "Test.\n")
+	buff := new(bytes.Buffer)
+
+	// success
+	err := ExportUser(db, "0000", buff)
+	assert.Equal(t, strings.Join([]string{
+		`{"user":"0000","name":"alpha","value":"Alpha.\n"}`,
+		`{"user":"0000","name":"bravo","value":"Bravo.\n"}`,
+	}, "\n")+"\n", buff.String())
+	assert.NoError(t, err)
+
+	// success - missing user
+	buff.Reset()
+	err = ExportUser(db, "nope", buff)
+	assert.Empty(t, buff.String())
+	assert.NoError(t, err)
+}
+
+func TestFindPairs(t *testing.T) {
+	// setup
+	defer func(flag bool) { IndexValues = flag }(IndexValues)
+	IndexValues = true
+	db := mockDB(t)
+	SyncIndex(db)
+
+	// success
+	recs, err := FindPairs(db, "Al", 0)
+	assert.Equal(t, []Record{{User: "0000", Name: "alpha"}}, recs)
+	assert.NoError(t, err)
+
+	// success - updated, renamed and deleted pairs
+	SetPair(db, "0000", "bravo", "Alpha two.")
+	SetPair(db, "1111", "charlie", "Alpha three.")
+	RenamePair(db, "0000", "alpha", "delta", false)
+	recs, _ = FindPairs(db, "Alpha", 0)
+	assert.Equal(t, []Record{
+		{User: "1111", Name: "charlie"},
+		{User: "0000", Name: "bravo"},
+		{User: "0000", Name: "delta"},
+	}, recs)
+
+	DeletePair(db, "0000", "bravo")
+	DeleteUser(db, "1111")
+	recs, _ = FindPairs(db, "Alpha", 0)
+	assert.Equal(t, []Record{{User: "0000", Name: "delta"}}, recs)
+	recs, _ = FindPairs(db, "Bravo", 0)
+	assert.Empty(t, recs)
+
+	// success - long prefix
+	long := strings.Repeat("x", IndexPrefix)
+	SetPair(db, "0000", "echo", long+"echo")
+	SetPair(db, "0000", "foxtrot", long+"foxtrot")
+	recs, _ = FindPairs(db, long+"fox", 0)
+	assert.Equal(t, []Record{{User: "0000", Name: "foxtrot"}}, recs)
+
+	// success - gzip and expired pairs
+	SetPairGzip(db, "0000", "golf", mockGzip("Golf."))
+	SetPairTTL(db, "0000", "hotel", "Golf hotel.", -time.Second)
+	recs, _ = FindPairs(db, "Golf", 0)
+	assert.Equal(t, []Record{{User: "0000", Name: "golf"}}, recs)
+
+	// success - limit
+	recs, _ = FindPairs(db, "x", 1)
+	assert.Len(t, recs, 1)
+
+	// failure - database error
+	db.Close()
+	_, err = FindPairs(db, "Al", 0)
+	assert.Error(t, err)
+}
+
+func TestFollowPair(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/0000/a%2Fb":
+			WriteHTTP(w, http.StatusOK, "Upstream.")
+		case "/0000/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"a":1}`))
+		case "/0000/fail":
+			WriteError(w, http.StatusInternalServerError, "fail")
+		default:
+			WriteFailure(w, http.StatusNotFound, "nope")
+		}
+	}))
+	defer srv.Close()
+	Upstream = srv.URL
+	defer func() { Upstream = "" }()
+
+	// success
+	vval, ok, err := FollowPair(context.Background(), db, "0000", "a/b")
+	assert.Equal(t, []byte("Upstream.\n"), vval.Data)
+	assert.WithinDuration(t, time.Now().Add(UpstreamTTL), vval.Expiry, time.Minute)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	pval, ok, _ := GetPair(db, "0000", "a/b")
+	assert.Equal(t, "Upstream.\n", pval)
+	assert.True(t, ok)
+
+	// success - content type kept
+	vval, ok, err = FollowPair(context.Background(), db, "0000", "json")
+	assert.Equal(t, "application/json", vval.Type)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - pair does not exist upstream
+	vval, ok, err = FollowPair(context.Background(), db, "0000", "nope")
+	assert.Zero(t, vval)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// failure - upstream error
+	_, _, err = FollowPair(context.Background(), db, "0000", "fail")
+	assert.EqualError(t, err, "upstream returned 500 Internal Server Error")
+}
+
+func TestGetPair(t *testing.T) {
+	// setup
+	db := mockDB(t)
+
+	// success - pair exists
+	pval, ok, err := GetPair(db, "0000", "alpha")
+	assert.Equal(t, "Alpha.\n", pval)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - pair does not exist
+	pval, ok, err = GetPair(db, "0000", "nope")
+	assert.Empty(t, pval)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// success - lowercase keys merge cases
+	SetPair(db, "1111", "Name", "Upper.")
+	SetPair(db, "1111", "name", "Lower.")
+	pval, _, _ = GetPair(db, "1111", "NAME")
+	assert.Equal(t, "Lower.\n", pval)
+
+	// success - case-sensitive keys keep cases apart
+	LowerKeys = false
+	SetPair(db, "2222", "Name", "Upper.")
+	SetPair(db, "2222", "name", "Lower.")
+	upper, _, _ := GetPair(db, "2222", "Name")
+	lower, _, _ := GetPair(db, "2222", "name")
+	_, ok, _ = GetPair(db, "2222", "NAME")
+	LowerKeys = true
+	assert.Equal(t, "Upper.\n", upper)
+	assert.Equal(t, "Lower.\n", lower)
+	assert.False(t, ok)
+
+	// success - pair has expired
+	SetPairTTL(db, "0000", "alpha", "Alpha.\n", -time.Hour)
+	pval, ok, err = GetPair(db, "0000", "alpha")
+	assert.Empty(t, pval)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	db.View(func(tx *bbolt.Tx) error {
+		bytes := tx.Bucket(Bucket).Bucket([]byte("0000")).Get([]byte("alpha"))
+		assert.Nil(t, bytes)
+		return nil
+	})
+}
+
+func TestGetPairContext(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// success
+	pval, ok, err := GetPairContext(ctx, DB, "0000", "alpha")
+	assert.Equal(t, "Alpha.\n", pval)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// failure - context cancelled
+	cancel()
+	pval, ok, err = GetPairContext(ctx, DB, "0000", "alpha")
+	assert.Empty(t, pval)
+	assert.False(t, ok)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestGetPairValue(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPair(db, "0000", "test", "Test.\n")
+
+	// success - pair exists
+	vval, ok, err := GetPairValue(db, "0000", "test")
+	assert.Equal(t, []byte("Test.\n"), vval.Data)
+	assert.WithinDuration(t, time.Now(), vval.Modified, time.Minute)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - pair does not exist
+	vval, ok, err = GetPairValue(db, "0000", "nope")
+	assert.Zero(t, vval)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// success - pair missing from bloom
+	bloom := &Bloom{db: db, bits: make([]atomic.Uint64, 16)}
+	Blooms.Store(db, bloom)
+	defer bloom.Close()
+	vval, ok, err = GetPairValue(db, "0000", "test")
+	assert.Zero(t, vval)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// success - pair in bloom
+	bloom.Add(PairKey("0000", "test"))
+	vval, ok, err = GetPairValue(db, "0000", "test")
+	assert.Equal(t, []byte("Test.\n"), vval.Data)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	Blooms.Delete(db)
+
+	// success - expired pair kept in dry run
+	defer func() { DryRun = false }()
+	DryRun = true
+	db.Update(func(tx *bbolt.Tx) error {
+		vval := Value{Data: []byte("Expired.\n"), Expiry: time.Now().Add(-time.Second)}
+		return putPair(tx, "0000", "expired", vval)
+	})
+
+	vval, ok, err = GetPairValue(db, "0000", "expired")
+	assert.Zero(t, vval)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+	db.View(func(tx *bbolt.Tx) error {
+		assert.NotNil(t, userBucket(tx, "0000").Get(NameKey("expired")))
+		return nil
+	})
+}
+
+func TestGetPairValueContext(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// success
+	vval, ok, err := GetPairValueContext(ctx, DB, "0000", "alpha")
+	assert.Equal(t, []byte("Alpha.\n"), vval.Data)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// failure - context done while waiting on writer
+	SetPairTTL(DB, "0000", "temp", "Temp.", time.Nanosecond)
+	tx, _ := DB.Begin(true)
+	_, _, err = GetPairValueContext(ctx, DB, "0000", "temp")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	tx.Rollback()
+}
+
+func TestGetPairs(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPairTTL(db, "0000", "temp", "Temp.", -time.Hour)
+
+	// success
+	pairs, err := GetPairs(db, "0000", []string{"alpha", "BRAVO", "temp", "nope"})
+	assert.Len(t, pairs, 4)
+	assert.Equal(t, "Alpha.\n", *pairs["alpha"])
+	assert.Equal(t, "Bravo.\n", *pairs["BRAVO"])
+	assert.Nil(t, pairs["temp"])
+	assert.Nil(t, pairs["nope"])
+	assert.NoError(t, err)
+
+	// success - missing user
+	pairs, err = GetPairs(db, "nope", []string{"alpha"})
+	assert.Equal(t, map[string]*string{"alpha": nil}, pairs)
+	assert.NoError(t, err)
+}
+
+func TestGetSetPair(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPairTTL(db, "0000", "temp", "Temp.", -time.Hour)
+	SetPairGzip(db, "0000", "gzip", mockGzip("Gzip.\n"))
+
+	// success - existing pair
+	prev, ok, err := GetSetPair(db, "0000", "alpha", "Alpha 2.")
+	assert.Equal(t, "Alpha.\n", prev)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	pval, _, _ := GetPair(db, "0000", "alpha")
+	assert.Equal(t, "Alpha 2.\n", pval)
+
+	// success - new and expired pairs
+	prev, ok, err = GetSetPair(db, "0000", "temp", "Temp 2.")
+	assert.Empty(t, prev)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// success - gzip pair
+	prev, ok, err = GetSetPair(db, "0000", "gzip", "Gzip 2.")
+	assert.Equal(t, "Gzip.\n", prev)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// failure - value too large
+	_, _, err = GetSetPair(db, "0000", "alpha", strings.Repeat("a", MaxValue+1))
+	assert.ErrorIs(t, err, ErrTooLarge)
+	pval, _, _ = GetPair(db, "0000", "alpha")
+	assert.Equal(t, "Alpha 2.\n", pval)
+}
+
+func TestImportPairs(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	buff := new(bytes.Buffer)
+	ExportPairs(db, buff)
+	buff.WriteString("nope\n\n")
+	buff.WriteString(`{"user":"x:admin","name":"test","value":"Test."}` + "\n")
+	buff.WriteString(`{"user":"1111","name":"test","value":"Test."}`)
+	dest, _ := OpenDB(filepath.Join(t.TempDir(), "dest.db"))
+
+	// success
+	done, skip, err := ImportPairs(dest, buff)
+	assert.Equal(t, 3, done)
+	assert.Equal(t, 2, skip)
+	assert.NoError(t, err)
+
+	// success - check database
+	for pkey, want := range map[string]string{
+		"0000:alpha": "Alpha.\n",
+		"0000:bravo": "Bravo.\n",
+		"1111:test":  "Test.\n",
+	} {
+		user, name, _ := strings.Cut(pkey, ":")
+		pval, _, _ := GetPair(dest, user, name)
+		assert.Equal(t, want, pval)
+	}
+
+	// success - many batches
+	buff.Reset()
+	for i := range BatchSize + 1 {
+		fmt.Fprintf(buff, `{"user":"2222","name":"%d","value":"%d"}`+"\n", i, i)
+	}
+
+	done, skip, err = ImportPairs(dest, buff)
+	assert.Equal(t, BatchSize+1, done)
+	assert.Zero(t, skip)
+	assert.NoError(t, err)
+
+	// success - merged records
+	MergePair(dest, "3333", "new", "Local.", 2000)
+	MergePair(dest, "3333", "old", "Local.", 2000)
+	buff.Reset()
+	buff.WriteString(`{"user":"3333","name":"new","value":"Remote.","modified":3000}` + "\n")
+	buff.WriteString(`{"user":"3333","name":"old","value":"Remote.","modified":1000}` + "\n")
+	done, skip, err = ImportPairs(dest, buff)
+	assert.Equal(t, 1, done)
+	assert.Equal(t, 1, skip)
+	assert.NoError(t, err)
+
+	pval, _, _ := GetPair(dest, "3333", "new")
+	assert.Equal(t, "Remote.\n", pval)
+	pval, _, _ = GetPair(dest, "3333", "old")
+	assert.Equal(t, "Local.\n", pval)
+}
+
+func TestIncrPair(t *testing.T) {
+	// setup
+	db := mockDB(t)
+
+	// success - pair does not exist
+	numb, err := IncrPair(db, "0000", "count", 2)
+	assert.Equal(t, int64(2), numb)
+	assert.NoError(t, err)
+
+	// success - pair exists
+	numb, err = IncrPair(db, "0000", "count", -3)
+	assert.Equal(t, int64(-1), numb)
+	assert.NoError(t, err)
+
+	// success - check database
+	pval, _, _ := GetPair(db, "0000", "count")
+	assert.Equal(t, "-1\n", pval)
+
+	// failure - pair is not an integer
+	numb, err = IncrPair(db, "0000", "alpha", 1)
+	assert.Zero(t, numb)
+	assert.ErrorIs(t, err, ErrNotInteger)
+
+	// failure - integer overflow
+	IncrPair(db, "0000", "large", math.MaxInt64)
+	numb, err = IncrPair(db, "0000", "large", 1)
+	assert.Zero(t, numb)
+	assert.ErrorIs(t, err, ErrOverflow)
+
+	// failure - integer underflow
+	IncrPair(db, "0000", "small", math.MinInt64)
+	numb, err = IncrPair(db, "0000", "small", -1)
+	assert.Zero(t, numb)
+	assert.ErrorIs(t, err, ErrOverflow)
+
+	// success - check database
+	pval, _, _ = GetPair(db, "0000", "large")
+	assert.Equal(t, "9223372036854775807\n", pval)
+}
+
+func TestInitBucket(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	defer func(flag bool) { StrictBucket = flag }(StrictBucket)
+	defer func() { Bucket = []byte("main") }()
+	StrictBucket = true
+
+	// success - bucket exists
+	okay, err := InitBucket(db)
+	assert.False(t, okay)
+	assert.NoError(t, err)
+
+	// failure - strict bucket does not exist
+	Bucket = []byte("test")
+	err = SetPair(db, "0000", "test", "Test.\n")
+	assert.EqualError(t, err, "bucket does not exist: test")
+	assert.ErrorIs(t, err, ErrNoBucket)
+
+	// success - bucket created
+	okay, err = InitBucket(db)
+	assert.True(t, okay)
+	assert.NoError(t, err)
+
+	// success - strict bucket exists
+	err = SetPair(db, "0000", "test", "Test.\n")
+	assert.NoError(t, err)
+}
+
+func TestIsDiskFull(t *testing.T) {
+	// success - wrapped error
+	err := &os.PathError{Op: "write", Path: "test.db", Err: syscall.ENOSPC}
+	assert.True(t, isDiskFull(fmt.Errorf("cannot commit: %w", err)))
+
+	// success - formatted error
+	assert.True(t, isDiskFull(fmt.Errorf("cannot commit: %s", err)))
+
+	// failure - other error
+	assert.False(t, isDiskFull(errors.New("nope")))
+	assert.False(t, isDiskFull(nil))
+}
+
+func TestListPairs(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPair(db, "0000", "charlie", "Charlie.\n")
+
+	// success - pairs exist
+	names, next, err := ListPairs(db, "0000", "", 0)
+	assert.Equal(t, []string{"alpha", "bravo", "charlie"}, names)
+	assert.Empty(t, next)
+	assert.NoError(t, err)
+
+	// success - pairs with limit
+	names, next, err = ListPairs(db, "0000", "", 2)
+	assert.Equal(t, []string{"alpha", "bravo"}, names)
+	assert.Equal(t, "bravo", next)
+	assert.NoError(t, err)
+
+	// success - pairs after name
+	names, next, err = ListPairs(db, "0000", "bravo", 2)
+	assert.Equal(t, []string{"charlie"}, names)
+	assert.Empty(t, next)
+	assert.NoError(t, err)
+
+	// success - expired pairs excluded
+	SetPairTTL(db, "0000", "bravo", "Bravo.\n", -time.Hour)
+	names, _, err = ListPairs(db, "0000", "", 0)
+	assert.Equal(t, []string{"alpha", "charlie"}, names)
+	assert.NoError(t, err)
+
+	// success - no pairs exist
+	names, next, err = ListPairs(db, "1111", "", 0)
+	assert.Empty(t, names)
+	assert.Empty(t, next)
+	assert.NoError(t, err)
+}
+
+func TestListUsers(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+
+	// success
+	users, err := ListUsers(DB)
+	assert.Equal(t, []string{"0000"}, users)
+	assert.NoError(t, err)
+
+	// success - empty database
+	DB.Update(func(tx *bbolt.Tx) error { return tx.DeleteBucket(Bucket) })
+	users, err = ListUsers(DB)
+	assert.Empty(t, users)
+	assert.NoError(t, err)
+
+	// failure - database error
+	DB.Close()
+	_, err = ListUsers(DB)
+	assert.Error(t, err)
+}
+
+func TestLogEvent(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	EventLog = true
+	defer func() { EventLog = false }()
+	getEvents := func() []Event {
+		var evnts []Event
+		DB.View(func(tx *bbolt.Tx) error {
+			buck := tx.Bucket(eventsName())
+			if buck == nil {
+				return nil
+			}
+
+			return buck.ForEach(func(_, data []byte) error {
+				var evnt Event
+				json.Unmarshal(data, &evnt)
+				evnts = append(evnts, evnt)
+				return nil
+			})
+		})
+
+		return evnts
+	}
+
+	// success
+	err := DB.Update(func(tx *bbolt.Tx) error { return LogEvent(tx, "set", "0000", "ALPHA") })
+	evnts := getEvents()
+	assert.Len(t, evnts, 1)
+	assert.Equal(t, uint64(1), evnts[0].Seq)
+	assert.Equal(t, "set", evnts[0].Op)
+	assert.Equal(t, "0000", evnts[0].User)
+	assert.Equal(t, "alpha", evnts[0].Name)
+	assert.NotZero(t, evnts[0].Time)
+	assert.NoError(t, err)
+
+	// success - rolled back with transaction
+	DB.Update(func(tx *bbolt.Tx) error {
+		LogEvent(tx, "set", "0000", "bravo")
+		return ErrQuota
+	})
+	assert.Len(t, getEvents(), 1)
+
+	// success - pair mutations
+	RenamePair(DB, "0000", "bravo", "charlie", false)
+	DeleteUser(DB, "0000")
+	evnts = getEvents()
+	assert.Len(t, evnts, 5)
+	assert.Equal(t, "set", evnts[1].Op)
+	assert.Equal(t, "charlie", evnts[1].Name)
+	assert.Equal(t, "delete", evnts[2].Op)
+	assert.Equal(t, "bravo", evnts[2].Name)
+	assert.Equal(t, "delete", evnts[3].Op)
+	assert.Equal(t, "delete", evnts[4].Op)
+
+	// success - disabled
+	EventLog = false
+	SetPair(DB, "0000", "delta", "Delta.\n")
+	assert.Len(t, getEvents(), 5)
+
+	// success - sent to webhook on commit
+	bodies := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		bodies <- string(data)
+	}))
+	defer srv.Close()
+	Hook = NewWebhook(srv.URL, 10)
+	defer func() {
+		Hook.Close()
+		Hook = nil
+	}()
+
+	SetPair(DB, "0000", "ECHO", "Echo.\n")
+	assert.Regexp(t, `^{"time":\d+,"op":"set","user":"0000","name":"echo"}$`, <-bodies)
+
+	// success - not sent on rollback
+	DB.Update(func(tx *bbolt.Tx) error {
+		LogEvent(tx, "delete", "0000", "echo")
+		return ErrQuota
+	})
+	DeletePair(DB, "0000", "echo")
+	assert.Contains(t, <-bodies, `"op":"delete"`)
+	assert.Empty(t, bodies)
+}
+
+func TestMergePair(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	MergePair(db, "0000", "test", "Test.", 2000)
+
+	// success - pair does not exist
+	vval, _, _ := GetPairValue(db, "0000", "test")
+	assert.Equal(t, "Test.\n", string(vval.Data))
+	assert.Equal(t, int64(2000), vval.Modified.UnixNano())
+
+	// success - newer timestamp
+	err := MergePair(db, "0000", "test", "Newer.", 3000)
+	vval, _, _ = GetPairValue(db, "0000", "test")
+	assert.Equal(t, "Newer.\n", string(vval.Data))
+	assert.Equal(t, int64(3000), vval.Modified.UnixNano())
+	assert.NoError(t, err)
+
+	// success - older timestamp
+	err = MergePair(db, "0000", "test", "Older.", 1000)
+	vval, _, _ = GetPairValue(db, "0000", "test")
+	assert.Equal(t, "Newer.\n", string(vval.Data))
+	assert.NoError(t, err)
+
+	// success - equal timestamp
+	err = MergePair(db, "0000", "test", "Equal.", 3000)
+	vval, _, _ = GetPairValue(db, "0000", "test")
+	assert.Equal(t, "Newer.\n", string(vval.Data))
+	assert.NoError(t, err)
+
+	// success - plain value without timestamp
+	err = MergePair(db, "0000", "alpha", "Test.", 1000)
+	pval, _, _ := GetPair(db, "0000", "alpha")
+	assert.Equal(t, "Test.\n", pval)
+	assert.NoError(t, err)
+
+	// success - expired value
+	SetPairValue(db, "0000", "temp", Value{
+		Data: []byte("Temp.\n"), Expiry: time.Now().Add(-time.Hour), Modified: time.Unix(0, 5000),
+	})
+	err = MergePair(db, "0000", "temp", "Test.", 1000)
+	pval, _, _ = GetPair(db, "0000", "temp")
+	assert.Equal(t, "Test.\n", pval)
+	assert.NoError(t, err)
+}
+
+func TestMigrateToBuckets(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	db.Update(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(Bucket)
+		root.Put([]byte("0000:charlie"), []byte("Charlie.\n"))
+		root.Put([]byte("1111:delta"), []byte("Delta.\n"))
+		return nil
+	})
+
+	// success
+	size, err := MigrateToBuckets(db)
+	assert.Equal(t, 2, size)
+	assert.NoError(t, err)
+
+	// success - check database
+	for pkey, want := range map[string]string{
+		"0000:alpha":   "Alpha.\n",
+		"0000:charlie": "Charlie.\n",
+		"1111:delta":   "Delta.\n",
+	} {
+		user, name, _ := strings.Cut(pkey, ":")
+		pval, ok, _ := GetPair(db, user, name)
+		assert.Equal(t, want, pval)
+		assert.True(t, ok)
+	}
+
+	db.View(func(tx *bbolt.Tx) error {
+		bytes := tx.Bucket(Bucket).Get([]byte("0000:charlie"))
+		assert.Nil(t, bytes)
+		return nil
+	})
+
+	// success - nothing to migrate
+	size, err = MigrateToBuckets(db)
+	assert.Zero(t, size)
+	assert.NoError(t, err)
+
+	// success - custom separator
+	KeySep = "\x00"
+	defer func() { KeySep = ":" }()
+	db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(Bucket).Put([]byte("2222\x00a:b"), []byte("Colon.\n"))
+	})
+
+	size, err = MigrateToBuckets(db)
+	assert.Equal(t, 1, size)
+	assert.NoError(t, err)
+	pval, ok, _ := GetPair(db, "2222", "a:b")
+	assert.Equal(t, "Colon.\n", pval)
+	assert.True(t, ok)
+}
+
+func TestMigrateVersion(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPairTTL(db, "0000", "temp", "Temp.", -time.Hour)
+	SetPairGzip(db, "1111", "gzip", mockGzip("Gzip.\n"))
+	upper := func(data []byte) []byte { return bytes.ToUpper(data) }
+	defer func() { APIVersion = "" }()
+
+	// success
+	size, err := MigrateVersion(db, "", "v2", upper)
+	assert.Equal(t, 3, size)
+	assert.NoError(t, err)
+
+	// success - check database
+	APIVersion = "v2"
+	for pkey, want := range map[string]string{
+		"0000:alpha": "ALPHA.\n",
+		"0000:bravo": "BRAVO.\n",
+		"1111:gzip":  "GZIP.\n",
+	} {
+		user, name, _ := strings.Cut(pkey, ":")
+		pval, _, _ := GetPair(db, user, name)
+		assert.Equal(t, want, pval)
+	}
+
+	_, ok, _ := GetPair(db, "0000", "temp")
+	assert.False(t, ok)
+	usage, _ := UserUsage(db, "0000")
+	assert.Equal(t, 2, usage.Keys)
+
+	// success - unversioned pairs unchanged
+	APIVersion = ""
+	pval, _, _ := GetPair(db, "0000", "alpha")
+	assert.Equal(t, "Alpha.\n", pval)
+
+	// success - nil transform and missing version
+	size, err = MigrateVersion(db, "v2", "v3", nil)
+	assert.Equal(t, 3, size)
+	assert.NoError(t, err)
+	size, err = MigrateVersion(db, "nope", "v3", nil)
+	assert.Zero(t, size)
+	assert.NoError(t, err)
+
+	// failure - same version
+	_, err = MigrateVersion(db, "v2", "v2", nil)
+	assert.EqualError(t, err, `cannot migrate version "v2" to itself`)
+}
+
+func TestNewBackups(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	dir := t.TempDir()
+
+	// success
+	backups := NewBackups(db, dir, 10*time.Millisecond, 1)
+	assert.Equal(t, db, backups.db)
+	assert.Equal(t, dir, backups.dir)
+	assert.Equal(t, 1, backups.keep)
+
+	// success - snapshots written and pruned
+	time.Sleep(50 * time.Millisecond)
+	backups.Close()
+	elems, _ := os.ReadDir(dir)
+	assert.Len(t, elems, 1)
+	assert.Regexp(t, `^test-\d{8}T\d{6}Z\.db$`, elems[0].Name())
+
+	// success - snapshots of global database
+	DB = mockDB(t)
+	dir = t.TempDir()
+	backups = NewBackups(nil, dir, 10*time.Millisecond, 1)
+	time.Sleep(50 * time.Millisecond)
+	backups.Close()
+	elems, _ = os.ReadDir(dir)
+	assert.Len(t, elems, 1)
+}
+
+func TestNewBloom(t *testing.T) {
+	// setup
+	db := mockDB(t)
+
+	// success
+	bloom, err := NewBloom(db)
+	defer bloom.Close()
+	assert.Equal(t, db, bloom.db)
+	assert.Len(t, bloom.bits, BloomBits*1024/64)
+	assert.True(t, bloom.Has(PairKey("0000", "alpha")))
+	assert.True(t, bloom.Has(PairKey("0000", "bravo")))
+	assert.False(t, bloom.Has(PairKey("0000", "nope")))
+	assert.NoError(t, err)
+
+	// success - written pairs added
+	SetPair(db, "0000", "Test", "Test.")
+	RenamePair(db, "0000", "alpha", "moved", false)
+	assert.True(t, bloom.Has(PairKey("0000", "test")))
+	assert.True(t, bloom.Has(PairKey("0000", "moved")))
+	pval, ok, _ := GetPair(db, "0000", "moved")
+	assert.Equal(t, "Alpha.\n", pval)
+	assert.True(t, ok)
+
+	// failure - database error
+	db.Close()
+	_, err = NewBloom(db)
+	assert.Error(t, err)
+}
+
+func TestNewQueue(t *testing.T) {
+	// setup
+	db := mockDB(t)
+
+	// success
+	queue := NewQueue(db, 10, time.Millisecond)
+	defer queue.Close()
+	assert.Equal(t, db, queue.db)
+	assert.Equal(t, 10, cap(queue.reqs))
+
+	// success - writes submitted to queue
+	err := SetPair(db, "0000", "alpha", "Test.")
+	assert.NoError(t, err)
+	pval, _, _ := GetPair(db, "0000", "alpha")
+	assert.Equal(t, "Test.\n", pval)
+}
+
+func TestNewSweeper(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	past := time.Now().Add(-time.Second)
+	SetPairValue(db, "0000", "expired", Value{Data: []byte("Expired.\n"), Expiry: past})
+
+	// success
+	sweeper := NewSweeper(db, 10*time.Millisecond)
+	assert.Equal(t, db, sweeper.db)
+
+	// success - expired pairs deleted
+	time.Sleep(50 * time.Millisecond)
+	sweeper.Close()
+	size, _ := CountKeys(db)
+	assert.Equal(t, 2, size)
+
+	// success - sweeps of global database
+	DB = mockDB(t)
+	SetPairValue(DB, "0000", "expired", Value{Data: []byte("Expired.\n"), Expiry: past})
+	sweeper = NewSweeper(nil, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	sweeper.Close()
+	size, _ = CountKeys(DB)
+	assert.Equal(t, 2, size)
+}
+
+func TestNewWebhook(t *testing.T) {
+	// success
+	hook := NewWebhook("http://127.0.0.1:1", 10)
+	defer hook.Close()
+	assert.Equal(t, "http://127.0.0.1:1", hook.url)
+	assert.Equal(t, 10, cap(hook.evnts))
+	assert.NoError(t, hook.ctx.Err())
+}
+
+func TestOpenDB(t *testing.T) {
+	// success - file database
+	dest := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenDB(dest)
+	assert.Equal(t, dest, db.Path())
+	assert.NoError(t, err)
+	db.Close()
+
+	// success - memory database
+	db, err = OpenDB(MemoryPath)
+	assert.NoFileExists(t, db.Path())
+	assert.NoError(t, err)
+
+	// success - check database
+	err = SetPair(db, "0000", "test", "Test.\n")
+	assert.NoError(t, err)
+	pval, _, _ := GetPair(db, "0000", "test")
+	assert.Equal(t, "Test.\n", pval)
+	db.Close()
+}
+
+func TestPatchPair(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPair(db, "0000", "json", `{"a":"b","c":{"d":"e"}}`)
+
+	// success
+	ok, err := PatchPair(db, "0000", "json", []byte(`{"c":{"d":null,"f":"g"}}`))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	pval, _, _ := GetPair(db, "0000", "json")
+	assert.Equal(t, `{"a":"b","c":{"f":"g"}}`+"\n", pval)
+
+	// success - pair does not exist
+	ok, err = PatchPair(db, "0000", "nope", []byte(`{"a":"b"}`))
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// failure - pair is not JSON
+	ok, err = PatchPair(db, "0000", "alpha", []byte(`{"a":"b"}`))
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, ErrNotJSON)
+
+	// success - check database
+	pval, _, _ = GetPair(db, "0000", "alpha")
+	assert.Equal(t, "Alpha.\n", pval)
+}
+
+func TestPopPair(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPairTTL(db, "0000", "temp", "Temp.", -time.Hour)
+	SetPairGzip(db, "0000", "gzip", mockGzip("Gzip.\n"))
+
+	// success
+	pval, ok, err := PopPair(db, "0000", "alpha")
+	assert.Equal(t, "Alpha.\n", pval)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	_, ok, _ = GetPair(db, "0000", "alpha")
+	assert.False(t, ok)
+
+	// success - gzip pair
+	pval, ok, err = PopPair(db, "0000", "gzip")
+	assert.Equal(t, "Gzip.\n", pval)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - missing and expired pairs
+	for _, name := range []string{"alpha", "temp"} {
+		pval, ok, err = PopPair(db, "0000", name)
+		assert.Empty(t, pval)
+		assert.False(t, ok)
+		assert.NoError(t, err)
+	}
+
+	// success - missing user
+	_, ok, err = PopPair(db, "nope", "alpha")
+	assert.False(t, ok)
+	assert.NoError(t, err)
+}
+
+func TestPruneBackups(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for _, name := range []string{
+		"test-20260101T000000Z.db", "test-20260102T000000Z.db", "test-20260103T000000Z.db",
+		"test-nope.db", "test-20260101T000000Z.db.1234.tmp", "other-20260101T000000Z.db",
+	} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0600)
+	}
+
+	names := func() []string {
+		var names []string
+		elems, _ := os.ReadDir(dir)
+		for _, elem := range elems {
+			names = append(names, elem.Name())
+		}
+
+		return names
+	}
+
+	// success - nothing pruned
+	err := PruneBackups(dir, "test.db", 0)
+	assert.Len(t, names(), 6)
+	assert.NoError(t, err)
+
+	// success
+	err = PruneBackups(dir, "/path/to/test.db", 2)
+	assert.Equal(t, []string{
+		"other-20260101T000000Z.db", "test-20260101T000000Z.db.1234.tmp",
+		"test-20260102T000000Z.db", "test-20260103T000000Z.db", "test-nope.db",
+	}, names())
+	assert.NoError(t, err)
+
+	// failure - missing directory
+	err = PruneBackups(filepath.Join(dir, "nope"), "test.db", 1)
+	assert.Error(t, err)
+}
+
+func TestRenamePair(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+
+	// success
+	ok, err := RenamePair(DB, "0000", "alpha", "test", false)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	pval, _, _ := GetPair(DB, "0000", "test")
+	assert.Equal(t, "Alpha.\n", pval)
+	_, ok, _ = GetPair(DB, "0000", "alpha")
+	assert.False(t, ok)
+
+	// success - same name
+	ok, err = RenamePair(DB, "0000", "test", "TEST", false)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	_, ok, _ = GetPair(DB, "0000", "test")
+	assert.True(t, ok)
+
+	// success - overwrite
+	ok, err = RenamePair(DB, "0000", "test", "bravo", true)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	pval, _, _ = GetPair(DB, "0000", "bravo")
+	assert.Equal(t, "Alpha.\n", pval)
+
+	// success - pair does not exist
+	ok, err = RenamePair(DB, "0000", "nope", "test", false)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// failure - destination exists
+	SetPair(DB, "0000", "test", "Test.")
+	ok, err = RenamePair(DB, "0000", "test", "bravo", false)
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, ErrPairExists)
+}
+
+func TestReopenDB(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	DB, _ = OpenDB(filepath.Join(dir, "old.db"))
+	NewQueue(DB, QueueSize, QueueDelay)
+	prev := DB
+
+	// success
+	err := ReopenDB(filepath.Join(dir, "new.db"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, prev, DB)
+	assert.Equal(t, filepath.Join(dir, "new.db"), DB.Path())
+	_, ok := Queues.Load(prev)
+	assert.False(t, ok)
+	_, ok = Queues.Load(DB)
+	assert.True(t, ok)
+
+	// failure - previous path reopened
+	err = ReopenDB(filepath.Join(dir, "nope", "nope.db"))
+	assert.Error(t, err)
+	assert.Equal(t, filepath.Join(dir, "new.db"), DB.Path())
+	err = SetPair(DB, "0000", "test", "Test.")
+	assert.NoError(t, err)
+	stopDB(DB)
+}
+
+func TestShrinkDB(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	mockFree(DB)
+	path := DB.Path()
+	prev := DB
+
+	// success
+	before, after, err := ShrinkDB()
+	assert.Less(t, after, before)
+	assert.NoError(t, err)
+	assert.NotEqual(t, prev, DB)
+	assert.Equal(t, path, DB.Path())
+
+	// success - check database
+	info, _ := os.Stat(path)
+	assert.Equal(t, after, info.Size())
+	pval, _, _ := GetPair(DB, "0000", "alpha")
+	assert.Equal(t, "Alpha.\n", pval)
+	elems, _ := os.ReadDir(filepath.Dir(path))
+	assert.Len(t, elems, 1)
+	stopDB(DB)
+}
+
+func TestSearchPairs(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPairs(db, "0000", map[string]string{"config/db/host": "Host.", "config/db/port": "Port."})
+
+	// success - prefix matches
+	names, next, err := SearchPairs(db, "0000", "config/db/", "", 0)
+	assert.Equal(t, []string{"config/db/host", "config/db/port"}, names)
+	assert.Empty(t, next)
+	assert.NoError(t, err)
+
+	// success - prefix matches after name
+	names, next, err = SearchPairs(db, "0000", "config/db/", "alpha", 1)
+	assert.Equal(t, []string{"config/db/host"}, names)
+	assert.Equal(t, "config/db/host", next)
+	assert.NoError(t, err)
+
+	// success - prefix does not match
+	names, next, err = SearchPairs(db, "0000", "nope", "", 0)
+	assert.Empty(t, names)
+	assert.Empty(t, next)
+	assert.NoError(t, err)
+
+	// success - empty prefix
+	names, _, err = SearchPairs(db, "0000", "", "", 0)
+	want, _, _ := ListPairs(db, "0000", "", 0)
+	assert.Equal(t, want, names)
+	assert.Len(t, names, 4)
+	assert.NoError(t, err)
+}
+
+func TestSearchPairsContext(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// success
+	names, next, err := SearchPairsContext(ctx, DB, "0000", "a", "", 0)
+	assert.Equal(t, []string{"alpha"}, names)
+	assert.Empty(t, next)
+	assert.NoError(t, err)
+
+	// failure - context cancelled
+	cancel()
+	names, _, err = SearchPairsContext(ctx, DB, "0000", "a", "", 0)
+	assert.Nil(t, names)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSeedPairs(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPairTTL(db, "0000", "temp", "Temp.", -time.Hour)
+	buff := bytes.NewBufferString("nope\n")
+	buff.WriteString(`{"user":"0000","name":"alpha","value":"Seed."}` + "\n")
+	buff.WriteString(`{"user":"0000","name":"temp","value":"Seed."}` + "\n")
+	buff.WriteString(`{"user":"1111","name":"test","value":"Seed."}`)
+
+	// success
+	done, skip, err := SeedPairs(db, buff)
+	assert.Equal(t, 2, done)
+	assert.Equal(t, 2, skip)
+	assert.NoError(t, err)
+
+	// success - check database
+	for pkey, want := range map[string]string{
+		"0000:alpha": "Alpha.\n",
+		"0000:temp":  "Seed.\n",
+		"1111:test":  "Seed.\n",
+	} {
+		user, name, _ := strings.Cut(pkey, ":")
+		pval, _, _ := GetPair(db, user, name)
+		assert.Equal(t, want, pval)
+	}
+
+	// failure - database error
+	db.Close()
+	buff = bytes.NewBufferString(`{"user":"2222","name":"test","value":"Seed."}`)
+	_, _, err = SeedPairs(db, buff)
+	assert.Error(t, err)
+}
+
+func TestSetPair(t *testing.T) {
+	// setup
+	db := mockDB(t)
+
+	// success
+	err := SetPair(db, "0000", "test", "Test.\n")
+	assert.NoError(t, err)
+
+	// success - check database
+	db.View(func(tx *bbolt.Tx) error {
+		buck := tx.Bucket(Bucket).Bucket([]byte("0000"))
+		vval := DecodeValue(buck.Get([]byte("test")))
+		assert.Equal(t, []byte("Test.\n"), vval.Data)
+		assert.WithinDuration(t, time.Now(), vval.Modified, time.Minute)
+		return nil
+	})
+
+	// failure - value too large
+	err = SetPair(db, "0000", "test", strings.Repeat("a", MaxValue+1))
+	assert.EqualError(t, err, fmt.Sprintf("value is too large: %d bytes is over limit of %d", MaxValue+2, MaxValue))
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	// failure - not json with RequireJSON
+	RequireJSON = true
+	err = SetPair(db, "0000", "test", "Test.\n")
+	RequireJSON = false
+	assert.ErrorIs(t, err, ErrNotJSON)
+
+	// success - custom bucket
+	Bucket = []byte("test")
+	defer func() { Bucket = []byte("main") }()
+	err = SetPair(db, "0000", "test", "Test 2.\n")
+	assert.NoError(t, err)
+
+	// success - check database
+	db.View(func(tx *bbolt.Tx) error {
+		buck := tx.Bucket([]byte("test")).Bucket([]byte("0000"))
+		vval := DecodeValue(buck.Get([]byte("test")))
+		assert.Equal(t, []byte("Test 2.\n"), vval.Data)
+		return nil
+	})
+
+	// failure - read-only database
+	path := db.Path()
+	db.Close()
+	db, _ = bbolt.Open(path, 0666, &bbolt.Options{ReadOnly: true})
+	defer db.Close()
+	err = SetPair(db, "0000", "test", "Test 3.\n")
+	assert.ErrorIs(t, err, ErrReadOnly)
+}
+
+func TestSetPairRaw(t *testing.T) {
+	// setup
+	db := mockDB(t)
+
+	// success
+	err := SetPairRaw(db, "0000", "test", []byte(" \x00Test. "))
+	assert.NoError(t, err)
+
+	// success - check database
+	vval, _, _ := GetPairValue(db, "0000", "test")
+	assert.Equal(t, []byte(" \x00Test. "), vval.Data)
+	assert.True(t, vval.Raw)
+}
+
+func TestSetPairGzip(t *testing.T) {
+	// setup
+	db := mockDB(t)
+
+	// success
+	err := SetPairGzip(db, "0000", "test", mockGzip("Test.\n"))
+	assert.NoError(t, err)
+
+	// success - stored compressed
+	vval, _, _ := GetPairValue(db, "0000", "test")
+	assert.Equal(t, mockGzip("Test.\n"), vval.Data)
+	assert.True(t, vval.Gzip)
+
+	// success - read decompressed
+	pval, ok, err := GetPair(db, "0000", "test")
+	assert.Equal(t, "Test.\n", pval)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - appended decompressed
+	err = AppendPair(db, "0000", "test", "More.")
+	assert.NoError(t, err)
+	vval, _, _ = GetPairValue(db, "0000", "test")
+	assert.Equal(t, "Test.\nMore.\n", string(vval.Data))
+	assert.False(t, vval.Gzip)
+
+	// failure - invalid gzip
+	err = SetPairGzip(db, "0000", "test", []byte("nope"))
+	assert.ErrorIs(t, err, ErrNotGzip)
+}
+
+func TestSetPairs(t *testing.T) {
+	// setup
+	db := mockDB(t)
+
+	// success
+	err := SetPairs(db, "0000", map[string]string{"alpha": "Alpha 2.", "test": "Test.\n"})
+	assert.NoError(t, err)
+
+	// success - check database
+	for name, want := range map[string]string{"alpha": "Alpha 2.\n", "test": "Test.\n"} {
+		pval, _, _ := GetPair(db, "0000", name)
+		assert.Equal(t, want, pval)
+	}
+}
+
+func TestSetPairTTL(t *testing.T) {
+	// setup
+	db := mockDB(t)
+
+	// success
+	err := SetPairTTL(db, "0000", "test", "Test.\n", time.Hour)
+	assert.NoError(t, err)
+
+	// success - check database
+	db.View(func(tx *bbolt.Tx) error {
+		bytes := tx.Bucket(Bucket).Bucket([]byte("0000")).Get([]byte("test"))
+		vval := DecodeValue(bytes)
+		assert.Equal(t, []byte("Test.\n"), vval.Data)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), vval.Expiry, time.Minute)
+		return nil
+	})
+}
+
+func TestSetPairValue(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	expy := time.Now().Add(time.Hour)
+
+	// success
+	err := SetPairValue(db, "0000", "test", Value{Data: []byte("Test."), Expiry: expy})
+	assert.NoError(t, err)
+
+	// success - check database
+	vval, _, _ := GetPairValue(db, "0000", "test")
+	assert.Equal(t, []byte("Test."), vval.Data)
+	assert.True(t, expy.Equal(vval.Expiry))
+}
+
+func TestSnapshotDB(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	dir := t.TempDir()
+
+	// success
+	dest, err := SnapshotDB(db, dir)
+	assert.Equal(t, dir, filepath.Dir(dest))
+	assert.Regexp(t, `^test-\d{8}T\d{6}Z\.db$`, filepath.Base(dest))
+	assert.NoError(t, err)
+
+	// success - check snapshot
+	back, _ := OpenDB(dest)
+	defer back.Close()
+	pval, _, _ := GetPair(back, "0000", "alpha")
+	assert.Equal(t, "Alpha.\n", pval)
+}
+
+func TestSwapPair(t *testing.T) {
+	// setup
+	db := mockDB(t)
+
+	// success - value matches
+	ok, err := SwapPair(db, "0000", "alpha", "Alpha.", "Alpha 2.")
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	pval, _, _ := GetPair(db, "0000", "alpha")
+	assert.Equal(t, "Alpha 2.\n", pval)
+
+	// success - value does not match
+	ok, err = SwapPair(db, "0000", "alpha", "Alpha.", "Alpha 3.")
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// success - pair does not exist
+	ok, err = SwapPair(db, "0000", "nope", "", "Nope.")
+	assert.False(t, ok)
+	assert.NoError(t, err)
+}
+
+func TestSwapPairFunc(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	gzip := func(vval Value) bool { return vval.Gzip }
+
+	// success - function matches
+	SetPairValue(db, "0000", "zip", Value{Data: mockGzip("Zip."), Gzip: true})
+	ok, err := SwapPairFunc(db, "0000", "zip", gzip, Value{Data: []byte("Zip 2.\n")})
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	pval, _, _ := GetPair(db, "0000", "zip")
+	assert.Equal(t, "Zip 2.\n", pval)
+
+	// success - function does not match
+	ok, err = SwapPairFunc(db, "0000", "zip", gzip, Value{Data: []byte("Zip 3.\n")})
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// success - pair does not exist
+	ok, err = SwapPairFunc(db, "0000", "nope", gzip, Value{Data: []byte("Nope.\n")})
+	assert.False(t, ok)
+	assert.NoError(t, err)
+}
+
+func TestSwapPairValue(t *testing.T) {
+	// setup
+	db := mockDB(t)
+
+	// success - value matches
+	ok, err := SwapPairValue(db, "0000", "alpha", "Alpha.", Value{Data: []byte(" Raw.\n"), Raw: true})
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	vval, _, _ := GetPairValue(db, "0000", "alpha")
+	assert.Equal(t, []byte(" Raw.\n"), vval.Data)
+	assert.True(t, vval.Raw)
+
+	// success - value does not match
+	ok, err = SwapPairValue(db, "0000", "alpha", "Alpha.", Value{Data: []byte("Alpha 3.\n")})
+	assert.False(t, ok)
+	assert.NoError(t, err)
+}
+
+func TestSweepPairs(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	past := time.Now().Add(-time.Second)
+	db.Update(func(tx *bbolt.Tx) error {
+		for _, user := range []string{"0000", "1111"} {
+			for i := range BatchSize {
+				vval := Value{Data: []byte("Expired.\n"), Expiry: past}
+				putPair(tx, user, fmt.Sprintf("expired%d", i), vval)
+			}
+		}
+
+		return nil
+	})
+
+	// success - dry run
+	DryRun = true
+	size, err := SweepPairs(db)
+	DryRun = false
+	assert.Equal(t, 2*BatchSize, size)
+	assert.NoError(t, err)
+	users, _ := ListUsers(db)
+	assert.Equal(t, []string{"0000", "1111"}, users)
+
+	// success
+	size, err = SweepPairs(db)
+	assert.Equal(t, 2*BatchSize, size)
+	assert.NoError(t, err)
+
+	// success - check database
+	users, _ = ListUsers(db)
+	assert.Equal(t, []string{"0000"}, users)
+	pval, _, _ := GetPair(db, "0000", "alpha")
+	assert.Equal(t, "Alpha.\n", pval)
+
+	// success - no expired pairs
+	size, err = SweepPairs(db)
+	assert.Zero(t, size)
+	assert.NoError(t, err)
+
+	// failure - database error
+	db.Close()
+	_, err = SweepPairs(db)
+	assert.Error(t, err)
+}
+
+func TestSyncIndex(t *testing.T) {
+	// setup
+	defer func(flag bool) { IndexValues = flag }(IndexValues)
+	IndexValues = true
+	db := mockDB(t)
+
+	// success - index built
+	size, err := SyncIndex(db)
+	assert.Equal(t, 2, size)
+	assert.NoError(t, err)
+	recs, _ := FindPairs(db, "Bravo", 0)
+	assert.Equal(t, []Record{{User: "0000", Name: "bravo"}}, recs)
+
+	// success - index exists
+	size, err = SyncIndex(db)
+	assert.Zero(t, size)
+	assert.NoError(t, err)
+
+	// success - index deleted
+	IndexValues = false
+	size, err = SyncIndex(db)
+	assert.Zero(t, size)
+	assert.NoError(t, err)
+	db.View(func(tx *bbolt.Tx) error {
+		assert.Nil(t, tx.Bucket(indexName()))
+		return nil
+	})
+
+	// failure - database error
+	db.Close()
+	_, err = SyncIndex(db)
+	assert.Error(t, err)
+}
+
+func TestTouchPair(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	SetPairTTL(db, "0000", "temp", "Temp.", time.Minute)
+	SetPairTTL(db, "0000", "gone", "Gone.", -time.Minute)
+
+	// success - expiry added
+	ok, err := TouchPair(db, "0000", "alpha", time.Hour)
+	vval, _, _ := GetPairValue(db, "0000", "alpha")
+	assert.True(t, ok)
+	assert.Equal(t, "Alpha.\n", string(vval.Data))
+	assert.WithinDuration(t, time.Now().Add(time.Hour), vval.Expiry, time.Second)
+	assert.NoError(t, err)
+
+	// success - expiry refreshed
+	before, _, _ := GetPairValue(db, "0000", "temp")
+	ok, err = TouchPair(db, "0000", "temp", time.Hour)
+	vval, _, _ = GetPairValue(db, "0000", "temp")
+	assert.True(t, ok)
+	assert.Equal(t, "Temp.\n", string(vval.Data))
+	assert.True(t, vval.Modified.Equal(before.Modified))
+	assert.WithinDuration(t, time.Now().Add(time.Hour), vval.Expiry, time.Second)
+	assert.NoError(t, err)
+
+	// success - expiry removed
+	ok, err = TouchPair(db, "0000", "temp", 0)
+	vval, _, _ = GetPairValue(db, "0000", "temp")
+	assert.True(t, ok)
+	assert.Zero(t, vval.Expiry)
+	assert.NoError(t, err)
+
+	// success - pair does not exist
+	ok, err = TouchPair(db, "0000", "nope", time.Hour)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// success - pair has expired
+	ok, err = TouchPair(db, "0000", "gone", time.Hour)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+}
+
+func TestUserUsage(t *testing.T) {
+	// setup
+	db := mockDB(t)
+	rescan := func(user string) Usage {
+		db.Update(func(tx *bbolt.Tx) error {
+			return tx.DeleteBucket(usageName())
+		})
+
+		usage, _ := UserUsage(db, user)
+		return usage
+	}
+
+	// success - counted from pairs
+	usage, err := UserUsage(db, "0000")
+	assert.Equal(t, Usage{Keys: 2, Bytes: 14}, usage)
+	assert.NoError(t, err)
+
+	// success - pair created
+	SetPair(db, "0000", "test", "Test.")
+	usage, _ = UserUsage(db, "0000")
+	assert.Equal(t, Usage{Keys: 3, Bytes: 30}, usage)
+	assert.Equal(t, usage, rescan("0000"))
+
+	// success - pair updated
+	SetPair(db, "0000", "alpha", "Alpha 2.")
+	usage, _ = UserUsage(db, "0000")
+	assert.Equal(t, Usage{Keys: 3, Bytes: 42}, usage)
+	assert.Equal(t, usage, rescan("0000"))
+
+	// success - pair renamed over another
+	RenamePair(db, "0000", "test", "bravo", true)
+	usage, _ = UserUsage(db, "0000")
+	assert.Equal(t, Usage{Keys: 2, Bytes: 19 + 16}, usage)
+	assert.Equal(t, usage, rescan("0000"))
+
+	// success - pair deleted
+	DeletePair(db, "0000", "bravo")
+	usage, _ = UserUsage(db, "0000")
+	assert.Equal(t, Usage{Keys: 1, Bytes: 19}, usage)
+	assert.Equal(t, usage, rescan("0000"))
+
+	// success - user deleted
+	SetPair(db, "0000", "test", "Test.")
+	DeleteUser(db, "0000")
+	usage, _ = UserUsage(db, "0000")
+	assert.Zero(t, usage)
+
+	// failure - over key quota
+	MaxUserKeys = 1
+	defer func() { MaxUserKeys = 0 }()
+	assert.NoError(t, SetPair(db, "1111", "alpha", "Alpha."))
+	assert.NoError(t, SetPair(db, "1111", "alpha", "Alpha 2."))
+	err = SetPair(db, "1111", "bravo", "Bravo.")
+	assert.ErrorIs(t, err, ErrQuota)
+	assert.EqualError(t, err, "user quota exceeded: user 1111 is over limit of 1 pairs")
+	MaxUserKeys = 0
+
+	// failure - over byte quota
+	MaxUserBytes = 36
+	defer func() { MaxUserBytes = 0 }()
+	assert.NoError(t, SetPair(db, "1111", "bravo", "Bravo."))
+	err = SetPair(db, "1111", "bravo", "Bravo 2.")
+	assert.ErrorIs(t, err, ErrQuota)
+	assert.EqualError(t, err, "user quota exceeded: user 1111 is over limit of 36 bytes")
+
+	// success - shrinking over quota
+	MaxUserBytes = 1
+	assert.NoError(t, SetPair(db, "1111", "alpha", "A."))
+	assert.NoError(t, DeletePair(db, "1111", "alpha"))
+
+	// failure - database error
+	db.Close()
+	_, err = UserUsage(db, "0000")
+	assert.Error(t, err)
+}
+
+func TestWatchPair(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	closed := func(wait <-chan struct{}) bool {
+		select {
+		case <-wait:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// success - shared channel
+	wait, stop := WatchPair("0000", "alpha")
+	same, stop2 := WatchPair("0000", "ALPHA")
+	assert.Equal(t, wait, same)
+	assert.False(t, closed(wait))
+
+	// success - kept until every caller stops
+	stop2()
+	stop2()
+	_, ok := Watchers.Load("0000:alpha")
+	assert.True(t, ok)
+	stop()
+	_, ok = Watchers.Load("0000:alpha")
+	assert.False(t, ok)
+
+	// success - closed on set
+	wait, stop = WatchPair("0000", "alpha")
+	defer stop()
+	SetPair(DB, "0000", "alpha", "Test.")
+	assert.True(t, closed(wait))
+
+	// success - closed on delete
+	wait, stop = WatchPair("0000", "alpha")
+	defer stop()
+	DeletePair(DB, "0000", "alpha")
+	assert.True(t, closed(wait))
+
+	// success - closed on user delete
+	wait, stop = WatchPair("0000", "bravo")
+	defer stop()
+	DeleteUser(DB, "0000")
+	assert.True(t, closed(wait))
+
+	// success - closed on rename
+	SetPair(DB, "1111", "charlie", "Test.")
+	wait, stop = WatchPair("1111", "charlie")
+	defer stop()
+	RenamePair(DB, "1111", "charlie", "delta", false)
+	assert.True(t, closed(wait))
+
+	// success - not closed on other pairs
+	wait, stop = WatchPair("1111", "delta")
+	defer stop()
+	SetPair(DB, "1111", "echo", "Test.")
+	assert.False(t, closed(wait))
+
+	// success - not closed on dry run
+	DryRun = true
+	defer func() { DryRun = false }()
+	SetPair(DB, "1111", "delta", "Test.")
+	assert.False(t, closed(wait))
+}
+
+func BenchmarkSetPair(b *testing.B) {
+	// setup
+	db, _ := OpenDB(filepath.Join(b.TempDir(), "bench.db"))
+	defer db.Close()
+	var n atomic.Int64
+
+	// success
+	b.SetParallelism(64)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			SetPair(db, "0000", fmt.Sprintf("name%d", n.Add(1)), "Test.")
+		}
+	})
+}
+
+func BenchmarkSetPairQueue(b *testing.B) {
+	// setup
+	db, _ := OpenDB(filepath.Join(b.TempDir(), "bench.db"))
+	defer db.Close()
+	queue := NewQueue(db, QueueSize, QueueDelay)
+	defer queue.Close()
+	var n atomic.Int64
+
+	// success
+	b.SetParallelism(64)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			SetPair(db, "0000", fmt.Sprintf("name%d", n.Add(1)), "Test.")
+		}
+	})
+}
+
+func BenchmarkGetPairMissing(b *testing.B) {
+	// setup
+	db, _ := OpenDB(filepath.Join(b.TempDir(), "bench.db"))
+	defer db.Close()
+	for n := range 1000 {
+		SetPair(db, "0000", fmt.Sprintf("name%d", n), "Test.")
+	}
+
+	// success
+	b.ResetTimer()
+	for n := range b.N {
+		GetPair(db, "0000", fmt.Sprintf("nope%d", n))
+	}
+}
+
+func BenchmarkGetPairMissingBloom(b *testing.B) {
+	// setup
+	db, _ := OpenDB(filepath.Join(b.TempDir(), "bench.db"))
+	defer db.Close()
+	for n := range 1000 {
+		SetPair(db, "0000", fmt.Sprintf("name%d", n), "Test.")
+	}
+
+	bloom, _ := NewBloom(db)
+	defer bloom.Close()
+
+	// success
+	b.ResetTimer()
+	for n := range b.N {
+		GetPair(db, "0000", fmt.Sprintf("nope%d", n))
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////////////
+//                        part five · http response functions                        //
+///////////////////////////////////////////////////////////////////////////////////////
+
+func TestAcceptsGzip(t *testing.T) {
+	// setup
+	r := httptest.NewRequest("GET", "/", nil)
+
+	// success - true
+	for _, acpt := range []string{"gzip", "deflate, gzip;q=0.5"} {
+		r.Header.Set("Accept-Encoding", acpt)
+		ok := AcceptsGzip(r)
+		assert.True(t, ok)
+	}
+
+	// success - false
+	for _, acpt := range []string{"", "deflate", "gzip;q=0"} {
+		r.Header.Set("Accept-Encoding", acpt)
+		ok := AcceptsGzip(r)
+		assert.False(t, ok)
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	// setup
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "1.2.3.4:5678"
+	r.Header.Set("X-Forwarded-For", "5.6.7.8, 9.9.9.9")
+
+	// success - remote address
+	addr := ClientAddr(r)
+	assert.Equal(t, "1.2.3.4", addr)
+
+	// success - trusted proxy
+	TrustProxy = true
+	defer func() { TrustProxy = false }()
+	addr = ClientAddr(r)
+	assert.Equal(t, "9.9.9.9", addr)
+}
+
+func TestSendsGzip(t *testing.T) {
+	// setup
+	r := httptest.NewRequest("PUT", "/", nil)
+
+	// success - true
+	r.Header.Set("Content-Encoding", "GZIP")
+	ok := SendsGzip(r)
+	assert.True(t, ok)
+
+	// success - false
+	for _, code := range []string{"", "identity", "br"} {
+		r.Header.Set("Content-Encoding", code)
+		ok := SendsGzip(r)
+		assert.False(t, ok)
+	}
+}
+
+func TestSendsJSON(t *testing.T) {
+	// setup
+	r := httptest.NewRequest("PUT", "/", nil)
+
+	// success - true
+	for _, mime := range []string{"application/json", "Application/JSON; charset=utf-8"} {
+		r.Header.Set("Content-Type", mime)
+		ok := SendsJSON(r)
+		assert.True(t, ok)
+	}
+
+	// success - false
+	for _, mime := range []string{"", "text/plain"} {
+		r.Header.Set("Content-Type", mime)
+		ok := SendsJSON(r)
+		assert.False(t, ok)
+	}
+}
+
+func TestSendsRaw(t *testing.T) {
+	// setup
+	r := httptest.NewRequest("PUT", "/", nil)
+
+	// success - true
+	r.Header.Set("Content-Type", "application/octet-stream")
+	ok := SendsRaw(r)
+	assert.True(t, ok)
+
+	// success - false
+	for _, mime := range []string{"", "text/plain"} {
+		r.Header.Set("Content-Type", mime)
+		ok := SendsRaw(r)
+		assert.False(t, ok)
+	}
+}
+
+func TestValueType(t *testing.T) {
+	// setup
+	r := httptest.NewRequest("PUT", "/", nil)
+
+	// success - stored types
+	for mime, want := range map[string]string{
+		"application/json":                "application/json",
+		"Application/JSON; Charset=UTF-8": "application/json; charset=UTF-8",
+		"image/png":                       "image/png",
+	} {
+		r.Header.Set("Content-Type", mime)
+		ctype := ValueType(r)
+		assert.Equal(t, want, ctype)
+	}
+
+	// success - unstored types
+	for _, mime := range []string{
+		"", "text/plain", "application/x-www-form-urlencoded",
+		"application/octet-stream", "nope", "a/" + strings.Repeat("b", 254),
+	} {
+		r.Header.Set("Content-Type", mime)
+		ctype := ValueType(r)
+		assert.Empty(t, ctype)
+	}
+}
+
+func TestWantsJSON(t *testing.T) {
+	// setup
+	r := httptest.NewRequest("GET", "/", nil)
+
+	// success - true
+	for _, acpt := range []string{"application/json", "text/plain, application/json;q=0.9"} {
+		r.Header.Set("Accept", acpt)
+		ok := WantsJSON(r)
+		assert.True(t, ok)
+	}
+
+	// success - false
+	for _, acpt := range []string{"", "*/*", "text/plain"} {
+		r.Header.Set("Accept", acpt)
+		ok := WantsJSON(r)
+		assert.False(t, ok)
+	}
+}
+
+func TestWriteHTTP(t *testing.T) {
+	// setup
+	w := httptest.NewRecorder()
+
+	// success
+	WriteHTTP(w, http.StatusOK, "%s", "test")
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "test\n", body)
+
+	// success - json
+	w = httptest.NewRecorder()
+	WriteHTTP(jsonWriter{w}, http.StatusOK, "%s", "test")
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"value":"test"}`+"\n", body)
+}
+
+func TestWriteJSON(t *testing.T) {
+	// setup
+	w := httptest.NewRecorder()
+
+	// success
+	WriteJSON(w, http.StatusOK, []string{"test"})
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `["test"]`+"\n", body)
+}
+
+func TestWriteCompressed(t *testing.T) {
+	// setup
+	long := strings.Repeat("a", MinGzip)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	// success - short body
+	w := httptest.NewRecorder()
+	WriteCompressed(w, r, http.StatusOK, []byte("test\n"))
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "5", w.Header().Get("Content-Length"))
+	assert.Equal(t, "test\n", body)
+
+	// success - long body
+	w = httptest.NewRecorder()
+	WriteCompressed(w, r, http.StatusOK, []byte(long))
+	code, _ = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	// success - check body
+	gzr, _ := gzip.NewReader(w.Body)
+	data, _ := io.ReadAll(gzr)
+	assert.Equal(t, long, string(data))
+
+	// success - long body already encoded
+	w = httptest.NewRecorder()
+	w.Header().Set("Content-Encoding", "gzip")
+	WriteCompressed(w, r, http.StatusOK, mockGzip(long))
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, string(mockGzip(long)), body)
+
+	// success - long body without gzip
+	r.Header.Del("Accept-Encoding")
+	w = httptest.NewRecorder()
+	WriteCompressed(w, r, http.StatusOK, []byte(long))
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, long, body)
+}
+
+func TestWriteError(t *testing.T) {
+	// setup
+	w := httptest.NewRecorder()
+
+	// success
+	WriteError(w, http.StatusInternalServerError, "%s", "test")
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, "server error 500: test\n", body)
+
+	// success - json
+	w = httptest.NewRecorder()
+	WriteError(jsonWriter{w}, http.StatusInternalServerError, "%s", "test")
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, `{"code":500,"error":"test"}`+"\n", body)
+}
+
+func TestWriteFailure(t *testing.T) {
+	// setup
+	w := httptest.NewRecorder()
+
+	// success
+	WriteFailure(w, http.StatusBadRequest, "%s", "test")
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: test\n", body)
+
+	// success - json
+	w = httptest.NewRecorder()
+	WriteFailure(jsonWriter{w}, http.StatusBadRequest, "%s", "test")
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, `{"code":400,"error":"test"}`+"\n", body)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////
+//                        part six · server endpoint functions                       //
+///////////////////////////////////////////////////////////////////////////////////////
+
+func TestDeleteNamespace(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "DELETE /{user}"
+
+	// success
+	r := httptest.NewRequest("DELETE", "/0000", nil)
+	code, body := getResponse(mockServe(ptrn, DeleteNamespace, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "2\n", body)
+
+	// success - check database
+	names, _, _ := ListPairs(DB, "0000", "", 0)
+	assert.Empty(t, names)
+
+	// failure - invalid user
+	r = httptest.NewRequest("DELETE", "/x:admin", nil)
+	code, _ = getResponse(mockServe(ptrn, DeleteNamespace, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestDeleteValue(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "DELETE /{user}/{name}"
+
+	// success
+	r := httptest.NewRequest("DELETE", "/0000/alpha", nil)
+	code, body := getResponse(mockServe(ptrn, DeleteValue, r))
+	assert.Equal(t, http.StatusNoContent, code)
+	assert.Empty(t, body)
+
+	// success - check database
+	_, ok, _ := GetPair(DB, "0000", "alpha")
+	assert.False(t, ok)
+
+	// failure - pair does not exist
+	r = httptest.NewRequest("DELETE", "/0000/nope", nil)
+	code, body = getResponse(mockServe(ptrn, DeleteValue, r))
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "client error 404: pair 0000/nope does not exist\n", body)
+
+	// failure - private name
+	SetPair(DB, "0000", "__secret__", "Secret.\n")
+	r = httptest.NewRequest("DELETE", "/0000/__secret__", nil)
+	code, body = getResponse(mockServe(ptrn, DeleteValue, r))
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "client error 403: name \"__secret__\" is private\n", body)
+
+	// success - check database
+	_, ok, _ = GetPair(DB, "0000", "__secret__")
+	assert.True(t, ok)
+
+	// failure - database error
+	DB.Close()
+	r = httptest.NewRequest("DELETE", "/0000/bravo", nil)
+	code, _ = getResponse(mockServe(ptrn, DeleteValue, r))
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestGetBackup(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	dest := filepath.Join(t.TempDir(), "backup.db")
+
+	// success
+	w := httptest.NewRecorder()
+	GetBackup(w, httptest.NewRequest("GET", "/_backup", nil))
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+	assert.Regexp(t, `^attachment; filename="test-\d{8}T\d{6}Z\.db"$`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, strconv.Itoa(len(body)), w.Header().Get("Content-Length"))
+
+	// success - check backup
+	os.WriteFile(dest, []byte(body), 0600)
+	back, _ := OpenDB(dest)
+	defer back.Close()
+	pval, _, _ := GetPair(back, "0000", "alpha")
+	assert.Equal(t, "Alpha.\n", pval)
+
+	// success - not cut off by write timeout
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(GetBackup))
+	srv.Config.WriteTimeout = time.Nanosecond
+	srv.Start()
+	defer srv.Close()
+	rslt, err := http.Get(srv.URL)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(rslt.Body)
+	rslt.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(body), data)
+}
+
+func TestGetConfig(t *testing.T) {
+	// setup
+	Addr = "127.0.0.1:8080"
+	Token = "token"
+	defer func() { Addr, Token = "", "" }()
+
+	// success
+	w := httptest.NewRecorder()
+	GetConfig(w, httptest.NewRequest("GET", "/_config", nil))
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"addr": "127.0.0.1:8080", "api_version": "", "bucket": "main", "max_value": 1048576,
+		"read_only": false, "version": "0.0.0"
+	}`, body)
+	assert.NotContains(t, body, "token")
+
+	// success - api version
+	APIVersion = "v1"
+	defer func() { APIVersion = "" }()
+	w = httptest.NewRecorder()
+	GetConfig(w, httptest.NewRequest("GET", "/_config", nil))
+	_, body = getResponse(w)
+	assert.Contains(t, body, `"bucket":"v1:main"`)
+}
+
+func TestGetCount(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+
+	// success
+	r := httptest.NewRequest("GET", "/_count", nil)
+	code, body := getResponse(mockServe("GET /_count", GetCount, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "2\n", body)
+
+	// success - json
+	r.Header.Set("Accept", "application/json")
+	code, body = getResponse(mockServe("GET /_count", GetCount, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"count":2}`+"\n", body)
+}
+
+func TestGetEvents(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	EventLog = true
+	defer func() { EventLog = false }()
+	SetPair(DB, "0000", "alpha", "Alpha.\n")
+	SetPair(DB, "0000", "bravo", "Bravo.\n")
+
+	// success
+	w := httptest.NewRecorder()
+	GetEvents(w, httptest.NewRequest("GET", "/_events", nil))
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, 2, strings.Count(body, "\n"))
+	assert.True(t, w.Flushed)
+
+	// success - after sequence
+	w = httptest.NewRecorder()
+	GetEvents(w, httptest.NewRequest("GET", "/_events?after=1", nil))
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, strings.Count(body, "\n"))
+	assert.Contains(t, body, `"name":"bravo"`)
+
+	// failure - invalid after
+	w = httptest.NewRecorder()
+	GetEvents(w, httptest.NewRequest("GET", "/_events?after=nope", nil))
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: invalid after \"nope\"\n", body)
+}
+
+func TestGetFind(t *testing.T) {
+	// setup
+	defer func(flag bool) { IndexValues = flag }(IndexValues)
+	IndexValues = true
+	DB = mockDB(t)
+	SyncIndex(DB)
+	SetPair(DB, "0000", "a b", "Alpha two.")
+	ptrn := "GET /_find"
+
+	// success
+	r := httptest.NewRequest("GET", "/_find?value=Alpha", nil)
+	code, body := getResponse(mockServe(ptrn, GetFind, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "0000:a%20b\n0000:alpha\n", body)
+
+	// success - json
+	r = httptest.NewRequest("GET", "/_find?value=Alpha&limit=1", nil)
+	r.Header.Set("Accept", "application/json")
+	code, body = getResponse(mockServe(ptrn, GetFind, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"pairs": ["0000:a b"]}`, body)
+
+	// success - private pairs excluded
+	SetPair(DB, "0000", "__alpha__", "Alpha secret.")
+	SetPair(DB, "__user__", "alpha", "Alpha secret.")
+	r = httptest.NewRequest("GET", "/_find?value=Alpha", nil)
+	code, body = getResponse(mockServe(ptrn, GetFind, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "0000:a%20b\n0000:alpha\n", body)
+
+	// success - no pairs
+	r = httptest.NewRequest("GET", "/_find?value=Nope", nil)
+	code, body = getResponse(mockServe(ptrn, GetFind, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, body)
+
+	// failure - missing value
+	r = httptest.NewRequest("GET", "/_find", nil)
+	code, _ = getResponse(mockServe(ptrn, GetFind, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - invalid limit
+	r = httptest.NewRequest("GET", "/_find?value=Alpha&limit=0", nil)
+	code, _ = getResponse(mockServe(ptrn, GetFind, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - index not enabled
+	IndexValues = false
+	r = httptest.NewRequest("GET", "/_find?value=Alpha", nil)
+	code, _ = getResponse(mockServe(ptrn, GetFind, r))
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestGetExport(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+
+	// success
+	w := httptest.NewRecorder()
+	GetExport(w, httptest.NewRequest("GET", "/", nil))
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, 2, strings.Count(body, "\n"))
+	assert.True(t, w.Flushed)
+
+	// success - private pairs excluded
+	SetPair(DB, "0000", "__secret__", "Secret.")
+	SetPair(DB, "__user__", "name", "Secret.")
+	w = httptest.NewRecorder()
+	GetExport(w, httptest.NewRequest("GET", "/", nil))
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, strings.Count(body, "\n"))
+	assert.NotContains(t, body, "Secret.")
+}
+
+func TestGetHealth(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+
+	// success
+	w := httptest.NewRecorder()
+	GetHealth(w, httptest.NewRequest("GET", "/", nil))
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok\n", body)
+
+	// failure - database error
+	DB.Close()
+	w = httptest.NewRecorder()
+	GetHealth(w, httptest.NewRequest("GET", "/", nil))
+	code, _ = getResponse(w)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
+
+func TestGetIndex(t *testing.T) {
+	// setup
+	w := httptest.NewRecorder()
+	defer func(strt time.Time) { startTime = strt }(startTime)
+	startTime = time.Now().Add(-time.Minute)
+
+	// success
+	GetIndex(w, httptest.NewRequest("GET", "/", nil))
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "Gesedels "+Version+".\n")
+	assert.Contains(t, body, "Uptime: 1m0s.\n")
+	assert.Contains(t, body, "Bucket: main.\n")
+
+	// setup - index message
+	DB = mockDB(t)
+	defer func(text string) { IndexMessage = text }(IndexMessage)
+	IndexMessage = "Hello."
+
+	// success - index message
+	w = httptest.NewRecorder()
+	GetIndex(w, httptest.NewRequest("GET", "/", nil))
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Hello.\n", body)
+
+	// success - index pair
+	SetPair(DB, IndexName, IndexName, "Welcome.\n")
+	w = httptest.NewRecorder()
+	GetIndex(w, httptest.NewRequest("GET", "/", nil))
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Welcome.\n", body)
+}
+
+func TestGetMetrics(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	Requests.Store(3)
+	MethodRequests["GET"].Store(2)
+	defer Requests.Store(0)
+	defer MethodRequests["GET"].Store(0)
+
+	// success
+	w := httptest.NewRecorder()
+	GetMetrics(w, httptest.NewRequest("GET", "/", nil))
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "gesedels_requests_total 3\n")
+	assert.Contains(t, body, `gesedels_method_requests_total{method="GET"} 2`+"\n")
+	assert.Contains(t, body, "gesedels_keys 2\n")
+	assert.Contains(t, body, "gesedels_free_pages ")
+}
+
+func TestGetNamespace(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "GET /{user}"
+
+	// success - pairs exist
+	r := httptest.NewRequest("GET", "/0000", nil)
+	code, body := getResponse(mockServe(ptrn, GetNamespace, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "alpha\nbravo\n", body)
+
+	// success - pairs exist as json
+	r = httptest.NewRequest("GET", "/0000", nil)
+	r.Header.Set("Accept", "application/json")
+	code, body = getResponse(mockServe(ptrn, GetNamespace, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"names":["alpha","bravo"],"next":""}`+"\n", body)
+
+	// success - pairs with values
+	r = httptest.NewRequest("GET", "/0000?values=true", nil)
+	w := mockServe(ptrn, GetNamespace, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"user":"0000","name":"alpha","value":"Alpha.\n"}`+"\n"+
+		`{"user":"0000","name":"bravo","value":"Bravo.\n"}`+"\n", body)
+	assert.True(t, w.Flushed)
+
+	// success - pairs without values
+	r = httptest.NewRequest("GET", "/0000?values=false", nil)
+	code, body = getResponse(mockServe(ptrn, GetNamespace, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "alpha\nbravo\n", body)
+
+	// success - pairs with limit
+	r = httptest.NewRequest("GET", "/0000?limit=1", nil)
+	w = mockServe(ptrn, GetNamespace, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "alpha\n", body)
+	assert.Equal(t, "alpha", w.Header().Get("X-Next-Cursor"))
+
+	// success - pairs after name
+	r = httptest.NewRequest("GET", "/0000?after=alpha", nil)
+	code, body = getResponse(mockServe(ptrn, GetNamespace, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "bravo\n", body)
+
+	// success - pairs with prefix
+	r = httptest.NewRequest("GET", "/0000?prefix=b", nil)
+	code, body = getResponse(mockServe(ptrn, GetNamespace, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "bravo\n", body)
+
+	// success - private pairs excluded
+	SetPair(DB, "0000", "__secret__", "Secret.")
+	r = httptest.NewRequest("GET", "/0000", nil)
+	code, body = getResponse(mockServe(ptrn, GetNamespace, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "alpha\nbravo\n", body)
+	r = httptest.NewRequest("GET", "/0000?values=true", nil)
+	code, body = getResponse(mockServe(ptrn, GetNamespace, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "Secret.")
+
+	// success - escaped names
+	SetPair(DB, "2222", "a/b c", "Test.")
+	r = httptest.NewRequest("GET", "/2222", nil)
+	code, body = getResponse(mockServe(ptrn, GetNamespace, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "a%2Fb%20c\n", body)
+
+	// success - no pairs exist
+	r = httptest.NewRequest("GET", "/1111", nil)
+	code, body = getResponse(mockServe(ptrn, GetNamespace, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, body)
+
+	// failure - invalid user
+	r = httptest.NewRequest("GET", "/x:admin", nil)
+	code, _ = getResponse(mockServe(ptrn, GetNamespace, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - invalid limit
+	r = httptest.NewRequest("GET", "/0000?limit=nope", nil)
+	code, _ = getResponse(mockServe(ptrn, GetNamespace, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - invalid values
+	r = httptest.NewRequest("GET", "/0000?values=nope", nil)
+	code, _ = getResponse(mockServe(ptrn, GetNamespace, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - database error
+	DB.Close()
+	r = httptest.NewRequest("GET", "/0000", nil)
+	code, _ = getResponse(mockServe(ptrn, GetNamespace, r))
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestGetOpenAPI(t *testing.T) {
+	// success
+	w := httptest.NewRecorder()
+	GetOpenAPI(w, httptest.NewRequest("GET", "/_openapi.json", nil))
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	// success - valid document
+	var doc struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+
+	err := json.Unmarshal([]byte(body), &doc)
+	assert.NoError(t, err)
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+	assert.NotEmpty(t, doc.Paths)
+}
+
+func TestGetStats(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "GET /_stats"
+
+	// success
+	r := httptest.NewRequest("GET", "/_stats", nil)
+	code, body := getResponse(mockServe(ptrn, GetStats, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"buckets":{"main":{`)
+
+	// failure - database error
+	DB.Close()
+	r = httptest.NewRequest("GET", "/_stats", nil)
+	code, _ = getResponse(mockServe(ptrn, GetStats, r))
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestGetUsage(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "GET /{user}/_usage"
+
+	// success
+	r := httptest.NewRequest("GET", "/0000/_usage", nil)
+	code, body := getResponse(mockServe(ptrn, GetUsage, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"keys": 2, "bytes": 14}`, body)
+
+	// failure - invalid name
+	r = httptest.NewRequest("GET", "/x:admin/_usage", nil)
+	code, _ = getResponse(mockServe(ptrn, GetUsage, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - database error
+	DB.Close()
+	r = httptest.NewRequest("GET", "/0000/_usage", nil)
+	code, _ = getResponse(mockServe(ptrn, GetUsage, r))
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestGetUserCount(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "GET /{user}/_count"
+
+	// success
+	r := httptest.NewRequest("GET", "/0000/_count", nil)
+	code, body := getResponse(mockServe(ptrn, GetUserCount, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "2\n", body)
+
+	// failure - database error
+	DB.Close()
+	code, _ = getResponse(mockServe(ptrn, GetUserCount, r))
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestGetUsers(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	SetPair(DB, "1111", "test", "Test.")
+
+	// success
+	r := httptest.NewRequest("GET", "/_users", nil)
+	code, body := getResponse(mockServe("GET /_users", GetUsers, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "0000\n1111\n", body)
+
+	// success - json
+	r.Header.Set("Accept", "application/json")
+	code, body = getResponse(mockServe("GET /_users", GetUsers, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"users":["0000","1111"]}`+"\n", body)
+
+	// success - private users excluded
+	SetPair(DB, "__user__", "name", "Secret.")
+	r = httptest.NewRequest("GET", "/_users", nil)
+	code, body = getResponse(mockServe("GET /_users", GetUsers, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "0000\n1111\n", body)
+
+	// failure - database error
+	DB.Close()
+	code, _ = getResponse(mockServe("GET /_users", GetUsers, r))
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestGetValue(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "GET /{user}/{name}"
+
+	// success
+	r := httptest.NewRequest("GET", "/0000/alpha", nil)
+	w := mockServe(ptrn, GetValue, r)
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Alpha.\n", body)
+
+	// success - no Last-Modified for plain values
+	assert.Empty(t, w.Header().Get("Last-Modified"))
+	assert.Equal(t, "7", w.Header().Get("Content-Length"))
+
+	// failure - private name
+	SetPair(DB, "0000", "__secret__", "Secret.\n")
+	r = httptest.NewRequest("GET", "/0000/__secret__", nil)
+	code, body = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "client error 403: name \"__secret__\" is private\n", body)
+
+	// failure - private user
+	r = httptest.NewRequest("GET", "/__secret__/alpha", nil)
+	code, _ = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// success - Last-Modified for new values
+	SetPair(DB, "0000", "test", "Test.\n")
+	r = httptest.NewRequest("GET", "/0000/test", nil)
+	w = mockServe(ptrn, GetValue, r)
+	code, body = getResponse(w)
+	modt := w.Header().Get("Last-Modified")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Test.\n", body)
+	assert.NotEmpty(t, modt)
+
+	// success - not modified since
+	r = httptest.NewRequest("GET", "/0000/test", nil)
+	r.Header.Set("If-Modified-Since", modt)
+	code, body = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusNotModified, code)
+	assert.Empty(t, body)
+
+	// success - expanded value
+	SetPair(DB, "0000", "ref", "${alpha} ${bravo}")
+	r = httptest.NewRequest("GET", "/0000/ref?expand=true", nil)
+	code, body = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Alpha. Bravo.\n", body)
+
+	// failure - reference loop
+	SetPair(DB, "0000", "loop", "${loop}")
+	r = httptest.NewRequest("GET", "/0000/loop?expand=true", nil)
+	code, _ = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusLoopDetected, code)
+
+	// failure - missing reference
+	SetPair(DB, "0000", "miss", "${nope}")
+	r = httptest.NewRequest("GET", "/0000/miss?expand=true", nil)
+	code, body = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, "client error 422: reference does not exist: 0000:nope\n", body)
+
+	// failure - private references
+	SetPair(DB, "0000", "__secret__", "Secret.")
+	SetPair(DB, "__user__", "name", "Secret.")
+	SetPair(DB, "0000", "priv", "${__secret__}")
+	SetPair(DB, "0000", "privuser", "${__user__:name}")
+	r = httptest.NewRequest("GET", "/0000/priv?expand=true", nil)
+	code, body = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, "client error 422: reference does not exist: 0000:__secret__\n", body)
+	r = httptest.NewRequest("GET", "/0000/privuser?expand=true", nil)
+	code, body = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, "client error 422: reference does not exist: __user__:name\n", body)
+
+	// success - json path
+	SetPair(DB, "0000", "json", `{"a": {"b": [1, 2]}}`)
+	w = mockServe(ptrn, GetValue, httptest.NewRequest("GET", "/0000/json?path=a.b.1", nil))
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "2\n", body)
+
+	// failure - json path does not exist
+	r = httptest.NewRequest("GET", "/0000/json?path=a.c", nil)
+	code, body = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "client error 404: path \"a.c\" does not exist\n", body)
+
+	// failure - json path on invalid json
+	r = httptest.NewRequest("GET", "/0000/alpha?path=a", nil)
+	code, body = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "client error 409: pair 0000/alpha is not valid json\n", body)
+
+	// success - raw value
+	SetPairRaw(DB, "0000", "raw", []byte(" Raw.\n\n"))
+	r = httptest.NewRequest("GET", "/0000/raw", nil)
+	w = mockServe(ptrn, GetValue, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, " Raw.\n\n", body)
+
+	// success - missing value read through from upstream
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteHTTP(w, http.StatusOK, "Upstream.")
+	}))
+	defer srv.Close()
+	Upstream = srv.URL
+	r = httptest.NewRequest("GET", "/0000/follow", nil)
+	code, body = getResponse(mockServe(ptrn, GetValue, r))
+	Upstream = ""
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Upstream.\n", body)
+
+	// success - typed value
+	SetPairValue(DB, "0000", "typed", Value{Data: []byte("<p>\n"), Type: "text/html"})
+	w = mockServe(ptrn, GetValue, httptest.NewRequest("GET", "/0000/typed", nil))
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "text/html", w.Header().Get("Content-Type"))
+	assert.Equal(t, "<p>\n", body)
+
+	// success - gzip value for gzip client
+	SetPairValue(DB, "0000", "zip", Value{Data: mockGzip("<p>\n"), Type: "text/html", Gzip: true})
+	r = httptest.NewRequest("GET", "/0000/zip", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = mockServe(ptrn, GetValue, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/html", w.Header().Get("Content-Type"))
+	assert.Equal(t, string(mockGzip("<p>\n")), body)
+
+	// success - gzip value for other client
+	w = mockServe(ptrn, GetValue, httptest.NewRequest("GET", "/0000/zip", nil))
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/html", w.Header().Get("Content-Type"))
+	assert.Equal(t, "<p>\n", body)
+
+	// success - plain value defaults to text
+	w = mockServe(ptrn, GetValue, httptest.NewRequest("GET", "/0000/alpha", nil))
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+
+	// success - ETag
+	w = mockServe(ptrn, GetValue, httptest.NewRequest("GET", "/0000/test", nil))
+	etag := w.Header().Get("ETag")
+	assert.Equal(t, PairETag([]byte("Test.\n")), etag)
+
+	// success - ETag matches
+	r = httptest.NewRequest("GET", "/0000/test", nil)
+	r.Header.Set("If-None-Match", `"nope", `+etag)
+	code, body = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusNotModified, code)
+	assert.Empty(t, body)
+
+	// success - ETag does not match
+	r = httptest.NewRequest("GET", "/0000/test", nil)
+	r.Header.Set("If-None-Match", `W/"nope"`)
+	r.Header.Set("If-Modified-Since", modt)
+	code, body = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Test.\n", body)
+
+	// success - head request
+	r = httptest.NewRequest("HEAD", "/0000/alpha", nil)
+	w = mockServe(ptrn, GetValue, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "7", w.Header().Get("Content-Length"))
+	assert.Empty(t, body)
+
+	// failure - invalid name
+	r = httptest.NewRequest("GET", "/0000/x:admin", nil)
+	code, body = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: invalid name \"x:admin\"\n", body)
+
+	// failure - pair does not exist
+	r = httptest.NewRequest("GET", "/0000/nope", nil)
+	code, body = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "client error 404: pair 0000/nope does not exist\n", body)
+
+	// failure - database error
+	DB.Close()
+	r = httptest.NewRequest("GET", "/0000/alpha", nil)
+	code, _ = getResponse(mockServe(ptrn, GetValue, r))
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestGetWatch(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "GET /{user}/{name}/watch"
+	etag := PairETag([]byte("Alpha.\n"))
+	WatchTimeout = 50 * time.Millisecond
+	defer func() { WatchTimeout = 30 * time.Second }()
+
+	// success - no If-None-Match
+	r := httptest.NewRequest("GET", "/0000/alpha/watch", nil)
+	w := mockServe(ptrn, GetWatch, r)
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Alpha.\n", body)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	// success - stale If-None-Match
+	r = httptest.NewRequest("GET", "/0000/alpha/watch", nil)
+	r.Header.Set("If-None-Match", `W/"0000000000000000"`)
+	code, body = getResponse(mockServe(ptrn, GetWatch, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Alpha.\n", body)
+
+	// success - unchanged until timeout
+	r = httptest.NewRequest("GET", "/0000/alpha/watch", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = mockServe(ptrn, GetWatch, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusNotModified, code)
+	assert.Empty(t, body)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	// success - watcher removed after timeout
+	_, ok := Watchers.Load("0000:alpha")
+	assert.False(t, ok)
+
+	// success - watcher removed after disconnect
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	r = httptest.NewRequestWithContext(ctx, "GET", "/0000/alpha/watch", nil)
+	r.Header.Set("If-None-Match", etag)
+	WatchTimeout = 5 * time.Second
+	mockServe(ptrn, GetWatch, r)
+	WatchTimeout = 50 * time.Millisecond
+	_, ok = Watchers.Load("0000:alpha")
+	assert.False(t, ok)
+
+	// success - changed while waiting
+	WatchTimeout = 5 * time.Second
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		SetPair(DB, "0000", "alpha", "Test.")
+	}()
+
+	r = httptest.NewRequest("GET", "/0000/alpha/watch", nil)
+	r.Header.Set("If-None-Match", etag)
+	code, body = getResponse(mockServe(ptrn, GetWatch, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Test.\n", body)
+
+	// success - reload not held while waiting
+	free := make(chan bool, 1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		okay := reloadMutex.TryLock()
+		if okay {
+			reloadMutex.Unlock()
+		}
+
+		free <- okay
+		SetPair(DB, "0000", "alpha", "Alpha.")
+	}()
+
+	r = httptest.NewRequest("GET", "/0000/alpha/watch", nil)
+	r.Header.Set("If-None-Match", PairETag([]byte("Test.\n")))
+	code, _ = getResponse(mockServe(ptrn, HoldReload(http.HandlerFunc(GetWatch)).ServeHTTP, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, <-free)
+	SetPair(DB, "0000", "alpha", "Test.")
+
+	// success - created while waiting
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		SetPair(DB, "0000", "test", "Test.")
+	}()
+
+	r = httptest.NewRequest("GET", "/0000/test/watch", nil)
+	code, body = getResponse(mockServe(ptrn, GetWatch, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Test.\n", body)
+
+	// failure - deleted while waiting
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		DeletePair(DB, "0000", "test")
+	}()
+
+	r = httptest.NewRequest("GET", "/0000/test/watch", nil)
+	r.Header.Set("If-None-Match", PairETag([]byte("Test.\n")))
+	code, body = getResponse(mockServe(ptrn, GetWatch, r))
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "client error 404: pair 0000/test does not exist\n", body)
+
+	// failure - invalid path
+	r = httptest.NewRequest("GET", "/0000/a:b/watch", nil)
+	code, _ = getResponse(mockServe(ptrn, GetWatch, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// success - outlasts WriteTimeout
+	WatchTimeout = 200 * time.Millisecond
+	WriteTimeout = 50 * time.Millisecond
+	defer func() { WriteTimeout = 10 * time.Second }()
+	mux := http.NewServeMux()
+	mux.HandleFunc(ptrn, GetWatch)
+	srv := NewServer("127.0.0.1:0", mux, false)
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	go srv.Serve(lis)
+	defer srv.Close()
+
+	r, _ = http.NewRequest("GET", "http://"+lis.Addr().String()+"/0000/alpha/watch", nil)
+	r.Header.Set("If-None-Match", PairETag([]byte("Test.\n")))
+	rslt, err := http.DefaultClient.Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, rslt.StatusCode)
+	rslt.Body.Close()
+}
+
+func TestHeadValue(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "HEAD /{user}/{name}"
+
+	// success
+	r := httptest.NewRequest("HEAD", "/0000/alpha", nil)
+	w := mockServe(ptrn, HeadValue, r)
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "7", w.Header().Get("Content-Length"))
+	assert.Equal(t, PairETag([]byte("Alpha.\n")), w.Header().Get("ETag"))
+	assert.Empty(t, body)
+
+	// failure - pair does not exist
+	r = httptest.NewRequest("HEAD", "/0000/nope", nil)
+	code, _ = getResponse(mockServe(ptrn, HeadValue, r))
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestPatchValue(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "PATCH /{user}/{name}"
+	SetPair(DB, "0000", "json", `{"a":"b","c":"d"}`)
+
+	// success
+	r := httptest.NewRequest("PATCH", "/0000/json", strings.NewReader(`{"c":null,"e":"f"}`))
+	code, body := getResponse(mockServe(ptrn, PatchValue, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Patched.\n", body)
+
+	// success - check database
+	pval, _, _ := GetPair(DB, "0000", "json")
+	assert.Equal(t, `{"a":"b","e":"f"}`+"\n", pval)
+
+	// failure - invalid patch
+	r = httptest.NewRequest("PATCH", "/0000/json", strings.NewReader(`{"c"`))
+	code, body = getResponse(mockServe(ptrn, PatchValue, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: invalid json patch\n", body)
+
+	// failure - pair is not JSON
+	r = httptest.NewRequest("PATCH", "/0000/alpha", strings.NewReader(`{"a":"b"}`))
+	code, body = getResponse(mockServe(ptrn, PatchValue, r))
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "client error 409: pair 0000/alpha is not valid json\n", body)
+
+	// failure - pair does not exist
+	r = httptest.NewRequest("PATCH", "/0000/nope", strings.NewReader(`{"a":"b"}`))
+	code, _ = getResponse(mockServe(ptrn, PatchValue, r))
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// failure - invalid name
+	r = httptest.NewRequest("PATCH", "/0000/x:admin", strings.NewReader(`{"a":"b"}`))
+	code, _ = getResponse(mockServe(ptrn, PatchValue, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - read-only
+	ReadOnly = true
+	defer func() { ReadOnly = false }()
+	r = httptest.NewRequest("PATCH", "/0000/json", strings.NewReader(`{"a":"b"}`))
+	code, _ = getResponse(mockServe(ptrn, PatchValue, r))
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
+
+func TestPostAppend(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "POST /{user}/{name}/append"
+
+	// success
+	r := httptest.NewRequest("POST", "/0000/alpha/append", strings.NewReader("Alpha 2.\n"))
+	code, body := getResponse(mockServe(ptrn, PostAppend, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Appended.\n", body)
+
+	// success - check database
+	pval, _, _ := GetPair(DB, "0000", "alpha")
+	assert.Equal(t, "Alpha.\nAlpha 2.\n", pval)
+
+	// failure - empty body
+	r = httptest.NewRequest("POST", "/0000/alpha/append", nil)
+	code, _ = getResponse(mockServe(ptrn, PostAppend, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - value too large
+	defer func(size int) { MaxValue = size }(MaxValue)
+	MaxValue = len(pval) + 4
+	r = httptest.NewRequest("POST", "/0000/alpha/append", strings.NewReader("Alpha 3.\n"))
+	code, body = getResponse(mockServe(ptrn, PostAppend, r))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, "client error 413: value is too large: 25 bytes is over limit of 20\n", body)
+
+	// failure - not json with RequireJSON
+	defer func() { RequireJSON = false }()
+	RequireJSON = true
+	r = httptest.NewRequest("POST", "/0000/alpha/append", strings.NewReader("A."))
+	code, body = getResponse(mockServe(ptrn, PostAppend, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, "client error 400: value is not valid json")
+}
+
+func TestPostCompact(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	mockFree(DB)
+
+	// success
+	r := httptest.NewRequest("POST", "/_compact", nil)
+	code, body := getResponse(mockServe("POST /_compact", PostCompact, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Regexp(t, `^Compacted \d+ bytes to \d+ bytes\.\n$`, body)
+
+	// success - json
+	r = httptest.NewRequest("POST", "/_compact", nil)
+	r.Header.Set("Accept", "application/json")
+	code, body = getResponse(mockServe("POST /_compact", PostCompact, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Regexp(t, `^{"after":\d+,"before":\d+}\n$`, body)
+
+	// success - dry run
+	defer func() { DryRun = false }()
+	DryRun = true
+	path := DB.Path()
+	info, _ := os.Stat(path)
+	r = httptest.NewRequest("POST", "/_compact", nil)
+	code, body = getResponse(mockServe("POST /_compact", PostCompact, r))
+	DryRun = false
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Compaction skipped in dry run.\n", body)
+	same, _ := os.Stat(path)
+	assert.True(t, os.SameFile(info, same))
+	assert.Equal(t, info.ModTime(), same.ModTime())
+	stopDB(DB)
+
+	// failure - directory of stores
+	DB = nil
+	r = httptest.NewRequest("POST", "/_compact", nil)
+	code, body = getResponse(mockServe("POST /_compact", PostCompact, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: cannot compact a directory of stores\n", body)
+
+	// failure - store-prefixed path
+	dir := t.TempDir()
+	db, _ := OpenDB(filepath.Join(dir, "test.db"))
+	db.Close()
+	stores := &Stores{Dir: dir}
+	defer stores.Close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /_compact", PostCompact)
+	hand := HoldReload(RouteStores(stores, mux))
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, httptest.NewRequest("POST", "/test/_compact", nil))
+		done <- w
+	}()
+
+	select {
+	case w := <-done:
+		code, body = getResponse(w)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, "client error 400: cannot compact a directory of stores\n", body)
+	case <-time.After(time.Second):
+		t.Fatal("compaction of a store deadlocked")
+	}
+}
+
+func TestPostCopy(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "POST /{user}/{name}/copy"
+
+	// success
+	r := httptest.NewRequest("POST", "/0000/alpha/copy", strings.NewReader("test\n"))
+	code, body := getResponse(mockServe(ptrn, PostCopy, r))
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "Copied.\n", body)
+
+	// success - check database
+	pval, _, _ := GetPair(DB, "0000", "test")
+	assert.Equal(t, "Alpha.\n", pval)
+
+	// success - overwrite
+	r = httptest.NewRequest("POST", "/0000/alpha/copy?overwrite=true", strings.NewReader("bravo"))
+	code, _ = getResponse(mockServe(ptrn, PostCopy, r))
+	assert.Equal(t, http.StatusCreated, code)
+
+	// failure - destination exists
+	r = httptest.NewRequest("POST", "/0000/alpha/copy", strings.NewReader("bravo"))
+	code, body = getResponse(mockServe(ptrn, PostCopy, r))
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "client error 409: pair 0000/bravo already exists\n", body)
+
+	// failure - pair does not exist
+	r = httptest.NewRequest("POST", "/0000/nope/copy", strings.NewReader("test"))
+	code, body = getResponse(mockServe(ptrn, PostCopy, r))
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "client error 404: pair 0000/nope does not exist\n", body)
+
+	// failure - database error
+	DB.Close()
+	r = httptest.NewRequest("POST", "/0000/alpha/copy", strings.NewReader("other"))
+	code, _ = getResponse(mockServe(ptrn, PostCopy, r))
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestPostCopyAcross(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "POST /_copy"
+	form := `{"from":{"user":"0000","name":"alpha"},"to":{"user":"1111","name":"%s"}}`
+
+	// success
+	r := httptest.NewRequest("POST", "/_copy", strings.NewReader(fmt.Sprintf(form, "test")))
+	code, body := getResponse(mockServe(ptrn, PostCopyAcross, r))
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "Copied.\n", body)
+
+	// success - check database
+	pval, _, _ := GetPair(DB, "1111", "test")
+	assert.Equal(t, "Alpha.\n", pval)
+
+	// failure - destination exists
+	r = httptest.NewRequest("POST", "/_copy", strings.NewReader(fmt.Sprintf(form, "test")))
+	code, body = getResponse(mockServe(ptrn, PostCopyAcross, r))
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "client error 409: pair 1111/test already exists\n", body)
+
+	// failure - invalid name
+	r = httptest.NewRequest("POST", "/_copy", strings.NewReader(fmt.Sprintf(form, "x:admin")))
+	code, _ = getResponse(mockServe(ptrn, PostCopyAcross, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - invalid json
+	r = httptest.NewRequest("POST", "/_copy", strings.NewReader("nope"))
+	code, _ = getResponse(mockServe(ptrn, PostCopyAcross, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - body too large
+	defer func(size int) { MaxBody = size }(MaxBody)
+	MaxBody = 8
+	r = httptest.NewRequest("POST", "/_copy", strings.NewReader(fmt.Sprintf(form, "test")))
+	code, body = getResponse(mockServe(ptrn, PostCopyAcross, r))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, "client error 413: body is over limit of 8 bytes\n", body)
+}
+
+func TestPostGetSet(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "POST /{user}/{name}/getset"
+
+	// success - existing pair
+	r := httptest.NewRequest("POST", "/0000/alpha/getset", strings.NewReader("Alpha 2.\n"))
+	code, body := getResponse(mockServe(ptrn, PostGetSet, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Alpha.\n", body)
+
+	// success - new pair
+	r = httptest.NewRequest("POST", "/0000/charlie/getset", strings.NewReader("Charlie.\n"))
+	code, body = getResponse(mockServe(ptrn, PostGetSet, r))
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "Created.\n", body)
+
+	// success - check database
+	pval, _, _ := GetPair(DB, "0000", "alpha")
+	assert.Equal(t, "Alpha 2.\n", pval)
+
+	// failure - empty body
+	r = httptest.NewRequest("POST", "/0000/alpha/getset", nil)
+	code, _ = getResponse(mockServe(ptrn, PostGetSet, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - invalid name
+	r = httptest.NewRequest("POST", "/0000/x:admin/getset", strings.NewReader("Alpha.\n"))
+	code, _ = getResponse(mockServe(ptrn, PostGetSet, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - not json with RequireJSON
+	defer func() { RequireJSON = false }()
+	RequireJSON = true
+	r = httptest.NewRequest("POST", "/0000/alpha/getset", strings.NewReader("Alpha 3.\n"))
+	code, body = getResponse(mockServe(ptrn, PostGetSet, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, "client error 400: value is not valid json")
+
+	// success - json with RequireJSON
+	r = httptest.NewRequest("POST", "/0000/alpha/getset", strings.NewReader(`{"a": 1}`))
+	code, _ = getResponse(mockServe(ptrn, PostGetSet, r))
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestPostImport(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	body := `{"user":"1111","name":"test","value":"Test."}` + "\nnope\n"
+
+	// success
+	r := httptest.NewRequest("POST", "/_import", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	PostImport(w, r)
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "imported 1, skipped 1\n", body)
+
+	// failure - not json with RequireJSON
+	defer func() { RequireJSON = false }()
+	RequireJSON = true
+	r = httptest.NewRequest("POST", "/_import", strings.NewReader(`{"user":"1111","name":"json","value":"{}"}`+"\n"+`{"user":"1111","name":"text","value":"Text."}`))
+	w = httptest.NewRecorder()
+	PostImport(w, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "imported 1, skipped 1\n", body)
+
+	// failure - body too large
+	defer func(size int) { MaxBody = size }(MaxBody)
+	MaxBody = 8
+	r = httptest.NewRequest("POST", "/_import", strings.NewReader(`{"user":"1111","name":"test","value":"Test."}`))
+	w = httptest.NewRecorder()
+	PostImport(w, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, "client error 413: body is over limit of 8 bytes (imported 0, skipped 1)\n", body)
+
+	// failure - private pair
+	MaxBody = 64 << 20
+	RequireJSON = false
+	r = httptest.NewRequest("POST", "/_import", strings.NewReader(`{"user":"__index__","name":"__index__","value":"Index."}`))
+	w = httptest.NewRecorder()
+	PostImport(w, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "client error 403: pair is private: __index__:__index__ (imported 0, skipped 0)\n", body)
+	_, ok, _ := GetPair(DB, "__index__", "__index__")
+	assert.False(t, ok)
+}
+
+func TestPostIncr(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "POST /{user}/{name}/incr"
+
+	// success - default delta
+	r := httptest.NewRequest("POST", "/0000/count/incr", nil)
+	code, body := getResponse(mockServe(ptrn, PostIncr, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "1\n", body)
+
+	// success - body delta
+	r = httptest.NewRequest("POST", "/0000/count/incr", strings.NewReader("10\n"))
+	code, body = getResponse(mockServe(ptrn, PostIncr, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "11\n", body)
+
+	// failure - invalid delta
+	r = httptest.NewRequest("POST", "/0000/count/incr", strings.NewReader("nope"))
+	code, body = getResponse(mockServe(ptrn, PostIncr, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: invalid delta \"nope\"\n", body)
+
+	// failure - pair is not an integer
+	r = httptest.NewRequest("POST", "/0000/alpha/incr", nil)
+	code, body = getResponse(mockServe(ptrn, PostIncr, r))
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "client error 409: pair 0000/alpha is not an integer\n", body)
+
+	// failure - integer overflow
+	r = httptest.NewRequest("POST", "/0000/count/incr", strings.NewReader("9223372036854775807"))
+	code, body = getResponse(mockServe(ptrn, PostIncr, r))
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "client error 409: pair 0000/count would overflow\n", body)
+
+	// failure - body too large
+	defer func(size int) { MaxBody = size }(MaxBody)
+	MaxBody = 8
+	r = httptest.NewRequest("POST", "/0000/count/incr", strings.NewReader("1234567890"))
+	code, body = getResponse(mockServe(ptrn, PostIncr, r))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, "client error 413: body is over limit of 8 bytes\n", body)
+}
+
+func TestPostInit(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	defer func(flag bool) { StrictBucket = flag }(StrictBucket)
+	defer func() { Bucket = []byte("main") }()
+	StrictBucket = true
+	Bucket = []byte("test")
+
+	// failure - strict bucket does not exist
+	r := httptest.NewRequest("PUT", "/0000/test", strings.NewReader("Test.\n"))
+	code, body := getResponse(mockServe("PUT /{user}/{name}", PutValue, r))
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "server error 503: bucket does not exist: test\n", body)
+
+	// success - bucket created
+	r = httptest.NewRequest("POST", "/_init", nil)
+	code, body = getResponse(mockServe("POST /_init", PostInit, r))
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "Created.\n", body)
+
+	// success - bucket exists
+	r = httptest.NewRequest("POST", "/_init", nil)
+	code, body = getResponse(mockServe("POST /_init", PostInit, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Exists.\n", body)
+
+	// success - strict bucket exists
+	r = httptest.NewRequest("PUT", "/0000/test", strings.NewReader("Test.\n"))
+	code, _ = getResponse(mockServe("PUT /{user}/{name}", PutValue, r))
+	assert.Equal(t, http.StatusCreated, code)
+}
+
+func TestPostMget(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "POST /{user}/_mget"
+
+	// success
+	r := httptest.NewRequest("POST", "/0000/_mget", strings.NewReader(`["alpha", "nope"]`))
+	code, body := getResponse(mockServe(ptrn, PostMget, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"alpha": "Alpha.\n", "nope": null}`, body)
+
+	// failure - invalid json
+	r = httptest.NewRequest("POST", "/0000/_mget", strings.NewReader(`{"alpha": 1}`))
+	code, _ = getResponse(mockServe(ptrn, PostMget, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - invalid name
+	r = httptest.NewRequest("POST", "/0000/_mget", strings.NewReader(`["x:admin"]`))
+	code, body = getResponse(mockServe(ptrn, PostMget, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: invalid name \"x:admin\"\n", body)
+
+	// failure - too many names
+	defer func(size int) { MaxList = size }(MaxList)
+	MaxList = 1
+	r = httptest.NewRequest("POST", "/0000/_mget", strings.NewReader(`["alpha", "bravo"]`))
+	code, body = getResponse(mockServe(ptrn, PostMget, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: cannot get more than 1 pairs\n", body)
+
+	// failure - database error
+	DB.Close()
+	r = httptest.NewRequest("POST", "/0000/_mget", strings.NewReader(`["alpha"]`))
+	code, _ = getResponse(mockServe(ptrn, PostMget, r))
+	assert.Equal(t, http.StatusInternalServerError, code)
+
+	// failure - body too large
+	defer func(size int) { MaxBody = size }(MaxBody)
+	MaxBody = 8
+	r = httptest.NewRequest("POST", "/0000/_mget", strings.NewReader(`["alpha", "bravo"]`))
+	code, body = getResponse(mockServe(ptrn, PostMget, r))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, "client error 413: body is over limit of 8 bytes\n", body)
+}
+
+func TestPostNamespace(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "POST /{user}"
+
+	// success
+	r := httptest.NewRequest("POST", "/0000", strings.NewReader(`{"alpha": "Alpha 2.", "test": "Test."}`))
+	code, body := getResponse(mockServe(ptrn, PostNamespace, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "2\n", body)
+
+	// success - check database
+	pval, _, _ := GetPair(DB, "0000", "test")
+	assert.Equal(t, "Test.\n", pval)
+
+	// failure - invalid json
+	r = httptest.NewRequest("POST", "/0000", strings.NewReader(`nope`))
+	code, _ = getResponse(mockServe(ptrn, PostNamespace, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - invalid name
+	r = httptest.NewRequest("POST", "/0000", strings.NewReader(`{"charlie": "C.", "x:admin": "Admin."}`))
+	code, body = getResponse(mockServe(ptrn, PostNamespace, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: invalid name \"x:admin\"\n", body)
+
+	// success - check database
+	_, ok, _ := GetPair(DB, "0000", "charlie")
+	assert.False(t, ok)
+
+	// failure - empty value
+	r = httptest.NewRequest("POST", "/0000", strings.NewReader(`{"charlie": " "}`))
+	code, body = getResponse(mockServe(ptrn, PostNamespace, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: value for \"charlie\" is empty\n", body)
+
+	// failure - not json with RequireJSON
+	defer func() { RequireJSON = false }()
+	RequireJSON = true
+	r = httptest.NewRequest("POST", "/0000", strings.NewReader(`{"charlie": "C."}`))
+	code, body = getResponse(mockServe(ptrn, PostNamespace, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, "client error 400: value is not valid json")
+
+	// failure - body too large
+	defer func(size int) { MaxBody = size }(MaxBody)
+	MaxBody = 8
+	r = httptest.NewRequest("POST", "/0000", strings.NewReader(`{"alpha": "Alpha 2."}`))
+	code, body = getResponse(mockServe(ptrn, PostNamespace, r))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, "client error 413: body is over limit of 8 bytes\n", body)
+}
+
+func TestPostPop(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "POST /{user}/{name}/pop"
+
+	// success
+	r := httptest.NewRequest("POST", "/0000/alpha/pop", nil)
+	code, body := getResponse(mockServe(ptrn, PostPop, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Alpha.\n", body)
+
+	// failure - missing pair
+	r = httptest.NewRequest("POST", "/0000/alpha/pop", nil)
+	code, _ = getResponse(mockServe(ptrn, PostPop, r))
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// failure - invalid name
+	r = httptest.NewRequest("POST", "/0000/x:admin/pop", nil)
+	code, _ = getResponse(mockServe(ptrn, PostPop, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - database error
+	DB.Close()
+	r = httptest.NewRequest("POST", "/0000/bravo/pop", nil)
+	code, _ = getResponse(mockServe(ptrn, PostPop, r))
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestPostRename(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "POST /{user}/{name}/rename"
+
+	// success
+	r := httptest.NewRequest("POST", "/0000/alpha/rename", strings.NewReader("test\n"))
+	code, body := getResponse(mockServe(ptrn, PostRename, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Renamed.\n", body)
+
+	// success - check database
+	pval, _, _ := GetPair(DB, "0000", "test")
+	assert.Equal(t, "Alpha.\n", pval)
+
+	// success - overwrite
+	r = httptest.NewRequest("POST", "/0000/test/rename?overwrite=true", strings.NewReader("bravo"))
+	code, _ = getResponse(mockServe(ptrn, PostRename, r))
+	assert.Equal(t, http.StatusOK, code)
+
+	// failure - destination exists
+	SetPair(DB, "0000", "test", "Test.")
+	r = httptest.NewRequest("POST", "/0000/test/rename", strings.NewReader("bravo"))
+	code, body = getResponse(mockServe(ptrn, PostRename, r))
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "client error 409: pair 0000/bravo already exists\n", body)
+
+	// failure - pair does not exist
+	r = httptest.NewRequest("POST", "/0000/nope/rename", strings.NewReader("test"))
+	code, body = getResponse(mockServe(ptrn, PostRename, r))
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "client error 404: pair 0000/nope does not exist\n", body)
+
+	// failure - invalid destination
+	r = httptest.NewRequest("POST", "/0000/test/rename", strings.NewReader("x:admin"))
+	code, _ = getResponse(mockServe(ptrn, PostRename, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - database error
+	DB.Close()
+	r = httptest.NewRequest("POST", "/0000/test/rename", strings.NewReader("bravo"))
+	code, _ = getResponse(mockServe(ptrn, PostRename, r))
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestPostTouch(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "POST /{user}/{name}/touch"
+
+	// success
+	r := httptest.NewRequest("POST", "/0000/alpha/touch?ttl=1h", nil)
+	code, body := getResponse(mockServe(ptrn, PostTouch, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Touched.\n", body)
+
+	// success - check database
+	vval, _, _ := GetPairValue(DB, "0000", "alpha")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), vval.Expiry, time.Second)
+
+	// success - expiry removed
+	r = httptest.NewRequest("POST", "/0000/alpha/touch", nil)
+	code, _ = getResponse(mockServe(ptrn, PostTouch, r))
+	assert.Equal(t, http.StatusOK, code)
+	vval, _, _ = GetPairValue(DB, "0000", "alpha")
+	assert.Zero(t, vval.Expiry)
+
+	// failure - pair does not exist
+	r = httptest.NewRequest("POST", "/0000/nope/touch?ttl=1h", nil)
+	code, body = getResponse(mockServe(ptrn, PostTouch, r))
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "client error 404: pair 0000/nope does not exist\n", body)
+
+	// failure - invalid ttl
+	r = httptest.NewRequest("POST", "/0000/alpha/touch?ttl=-1h", nil)
+	code, body = getResponse(mockServe(ptrn, PostTouch, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: invalid ttl \"-1h\"\n", body)
+
+	// failure - invalid name
+	r = httptest.NewRequest("POST", "/0000/x:admin/touch", nil)
+	code, _ = getResponse(mockServe(ptrn, PostTouch, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - read-only
+	ReadOnly = true
+	defer func() { ReadOnly = false }()
+	r = httptest.NewRequest("POST", "/0000/alpha/touch?ttl=1h", nil)
+	code, _ = getResponse(mockServe(ptrn, PostTouch, r))
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
+
+func TestPostTx(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "POST /_tx"
+
+	// success
+	r := httptest.NewRequest("POST", "/_tx", strings.NewReader(`[
+		{"op": "set", "user": "0000", "name": "test", "value": "Test."},
+		{"op": "delete", "user": "0000", "name": "alpha"}
+	]`))
+	code, body := getResponse(mockServe(ptrn, PostTx, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "2\n", body)
+
+	// failure - invalid operation
+	r = httptest.NewRequest("POST", "/_tx", strings.NewReader(`[{"op": "nope"}]`))
+	code, body = getResponse(mockServe(ptrn, PostTx, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: operation 0: invalid op \"nope\"\n", body)
+
+	// failure - private name
+	r = httptest.NewRequest("POST", "/_tx", strings.NewReader(`[
+		{"op": "set", "user": "0000", "name": "__secret__", "value": "Secret."}
+	]`))
+	code, body = getResponse(mockServe(ptrn, PostTx, r))
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "client error 403: pair 0000/__secret__ is private\n", body)
+
+	// failure - not json with RequireJSON
+	RequireJSON = true
+	r = httptest.NewRequest("POST", "/_tx", strings.NewReader(`[{"op": "set", "user": "0000", "name": "doc", "value": "Doc."}]`))
+	code, body = getResponse(mockServe(ptrn, PostTx, r))
+	RequireJSON = false
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: operation 0: value is not valid json\n", body)
+
+	// failure - invalid json
+	r = httptest.NewRequest("POST", "/_tx", strings.NewReader("nope"))
+	code, _ = getResponse(mockServe(ptrn, PostTx, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - database error
+	DB.Close()
+	r = httptest.NewRequest("POST", "/_tx", strings.NewReader(`[{"op": "delete", "user": "0000", "name": "bravo"}]`))
+	code, _ = getResponse(mockServe(ptrn, PostTx, r))
+	assert.Equal(t, http.StatusInternalServerError, code)
+
+	// failure - body too large
+	defer func(size int) { MaxBody = size }(MaxBody)
+	MaxBody = 8
+	r = httptest.NewRequest("POST", "/_tx", strings.NewReader(`[{"op": "delete"}]`))
+	code, body = getResponse(mockServe(ptrn, PostTx, r))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, "client error 413: body is over limit of 8 bytes\n", body)
+}
+
+func TestPutValue(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	ptrn := "PUT /{user}/{name}"
+
+	// success - pair created
+	r := httptest.NewRequest("PUT", "/0000/test", strings.NewReader("\tTest.\n"))
+	code, body := getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "Created.\n", body)
+
+	// success - check database
+	pval, _, _ := GetPair(DB, "0000", "test")
+	assert.Equal(t, "Test.\n", pval)
+
+	// success - pair updated
+	r = httptest.NewRequest("PUT", "/0000/alpha", strings.NewReader("Alpha 2.\n"))
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Updated.\n", body)
+
+	// success - pair with ttl
+	r = httptest.NewRequest("PUT", "/0000/temp?ttl=1h", strings.NewReader("Temp.\n"))
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusCreated, code)
+
+	// success - gzip pair
+	r = httptest.NewRequest("PUT", "/0000/zip", bytes.NewReader(mockGzip(" Zip. ")))
+	r.Header.Set("Content-Encoding", "gzip")
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusCreated, code)
+	vval, _, _ := GetPairValue(DB, "0000", "zip")
+	assert.Equal(t, Value{Data: mockGzip(" Zip. "), Modified: vval.Modified, Gzip: true}, vval)
+
+	// failure - invalid gzip pair
+	r = httptest.NewRequest("PUT", "/0000/zip", strings.NewReader("nope"))
+	r.Header.Set("Content-Encoding", "gzip")
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: invalid gzip body\n", body)
+
+	// failure - gzip pair decompresses over MaxValue
+	r = httptest.NewRequest("PUT", "/0000/zip", bytes.NewReader(mockGzip(strings.Repeat("0", MaxValue+1))))
+	r.Header.Set("Content-Encoding", "gzip")
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+
+	// success - raw pair
+	r = httptest.NewRequest("PUT", "/0000/raw", strings.NewReader("  Raw. "))
+	r.Header.Set("Content-Type", "application/octet-stream")
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusCreated, code)
+
+	// success - check database
+	pval, _, _ = GetPair(DB, "0000", "raw")
+	assert.Equal(t, "  Raw. ", pval)
+
+	// success - value query
+	r = httptest.NewRequest("PUT", "/0000/query?value=Query+value.", nil)
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "Created.\n", body)
+
+	// success - check database
+	pval, _, _ = GetPair(DB, "0000", "query")
+	assert.Equal(t, "Query value.\n", pval)
+
+	// success - encoded slash and reserved characters
+	r = httptest.NewRequest("PUT", "/0000/a%2Fb%20%3F%23%25", strings.NewReader("Encoded.\n"))
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusCreated, code)
+	pval, _, _ = GetPair(DB, "0000", "a/b ?#%")
+	assert.Equal(t, "Encoded.\n", pval)
+	r = httptest.NewRequest("GET", "/0000/A%2FB%20%3F%23%25", nil)
+	code, body = getResponse(mockServe("GET /{user}/{name}", GetValue, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Encoded.\n", body)
+
+	// success - case preserved
+	LowerKeys = false
+	r = httptest.NewRequest("PUT", "/0000/ALPHA", strings.NewReader("Upper.\n"))
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	LowerKeys = true
+	assert.Equal(t, http.StatusCreated, code)
+	pval, _, _ = GetPair(DB, "0000", "alpha")
+	assert.Equal(t, "Alpha 2.\n", pval)
+
+	// success - json round trip
+	r = httptest.NewRequest("PUT", "/0000/json", strings.NewReader(`{"a":1}`))
+	r.Header.Set("Content-Type", "application/json")
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusCreated, code)
+	w := mockServe("GET /{user}/{name}", GetValue, httptest.NewRequest("GET", "/0000/json", nil))
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "{\"a\":1}\n", body)
+
+	// success - octet-stream round trip
+	r = httptest.NewRequest("PUT", "/0000/bin", strings.NewReader("\x00\x01\n"))
+	r.Header.Set("Content-Type", "application/octet-stream")
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusCreated, code)
+	w = mockServe("GET /{user}/{name}", GetValue, httptest.NewRequest("GET", "/0000/bin", nil))
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "\x00\x01\n", body)
+
+	// failure - body and value query
+	r = httptest.NewRequest("PUT", "/0000/query?value=Query.", strings.NewReader("Body.\n"))
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: body and value query cannot both be set\n", body)
+
+	// failure - empty value query
+	r = httptest.NewRequest("PUT", "/0000/query?value=+", nil)
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: value is empty\n", body)
+
+	// failure - value query too large
+	r = httptest.NewRequest("PUT", "/0000/query?value="+strings.Repeat("a", MaxValue+1), nil)
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+
+	// success - pair swapped
+	r = httptest.NewRequest("PUT", "/0000/bravo", strings.NewReader("Bravo 2.\n"))
+	r.Header.Set("If-Match", "Bravo.")
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Updated.\n", body)
+
+	// failure - pair does not match
+	r = httptest.NewRequest("PUT", "/0000/bravo", strings.NewReader("Bravo 3.\n"))
+	r.Header.Set("If-Match", "Bravo.")
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusPreconditionFailed, code)
+	assert.Equal(t, "client error 412: pair 0000/bravo does not match\n", body)
+
+	// success - pair swapped by ETag
+	r = httptest.NewRequest("PUT", "/0000/bravo", strings.NewReader("Bravo 3.\n"))
+	r.Header.Set("If-Match", PairETag([]byte("Bravo 2.\n")))
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusOK, code)
+
+	// failure - ETag does not match
+	r = httptest.NewRequest("PUT", "/0000/bravo", strings.NewReader("Bravo 4.\n"))
+	r.Header.Set("If-Match", PairETag([]byte("Bravo 2.\n")))
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusPreconditionFailed, code)
+
+	// success - raw pair swapped by ETag
+	SetPairRaw(DB, "0000", "rawswap", []byte("  Raw. "))
+	r = httptest.NewRequest("PUT", "/0000/rawswap", strings.NewReader("Raw 2.\n"))
+	r.Header.Set("If-Match", PairETag([]byte("  Raw. ")))
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusOK, code)
+
+	// success - gzip pair swapped by ETag
+	SetPairValue(DB, "0000", "zipswap", Value{Data: mockGzip("Zip."), Gzip: true})
+	r = httptest.NewRequest("PUT", "/0000/zipswap", strings.NewReader("Zip 2.\n"))
+	r.Header.Set("If-Match", PairETag(mockGzip("Zip.")))
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusOK, code)
+	pval, _, _ = GetPair(DB, "0000", "zipswap")
+	assert.Equal(t, "Zip 2.\n", pval)
+
+	// success - pair created if absent
+	r = httptest.NewRequest("PUT", "/0000/lock", strings.NewReader("Lock.\n"))
+	r.Header.Set("If-None-Match", "*")
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "Created.\n", body)
+
+	// failure - pair exists
+	r = httptest.NewRequest("PUT", "/0000/lock", strings.NewReader("Lock 2.\n"))
+	r.Header.Set("If-None-Match", "*")
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusPreconditionFailed, code)
+	assert.Equal(t, "client error 412: pair 0000/lock already exists\n", body)
+
+	// success - check database
+	pval, _, _ = GetPair(DB, "0000", "lock")
+	assert.Equal(t, "Lock.\n", pval)
+
+	// failure - If-None-Match not *
+	r = httptest.NewRequest("PUT", "/0000/lock", strings.NewReader("Lock 2.\n"))
+	r.Header.Set("If-None-Match", PairETag([]byte("Lock.\n")))
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: If-None-Match must be *\n", body)
+
+	// failure - If-None-Match with ttl
+	r = httptest.NewRequest("PUT", "/0000/lock2?ttl=1h", strings.NewReader("Lock.\n"))
+	r.Header.Set("If-None-Match", "*")
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// failure - invalid name
+	r = httptest.NewRequest("PUT", "/0000/x:admin", strings.NewReader("Admin.\n"))
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// success - check database
+	_, ok, _ := GetPair(DB, "0000:x", "admin")
+	assert.False(t, ok)
+
+	// failure - invalid ttl
+	r = httptest.NewRequest("PUT", "/0000/test?ttl=nope", strings.NewReader("Test.\n"))
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: invalid ttl \"nope\"\n", body)
+
+	// failure - empty body
+	r = httptest.NewRequest("PUT", "/0000/test", strings.NewReader(" \n"))
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: body is empty\n", body)
+
+	// failure - over quota
+	MaxUserKeys = 1
+	defer func() { MaxUserKeys = 0 }()
+	r = httptest.NewRequest("PUT", "/0000/quota", strings.NewReader("Quota.\n"))
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusInsufficientStorage, code)
+	assert.Contains(t, body, "server error 507: user quota exceeded")
+	MaxUserKeys = 0
+
+	// failure - body too large
+	r = httptest.NewRequest("PUT", "/0000/test", strings.NewReader(strings.Repeat("a", MaxValue+1)))
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, fmt.Sprintf("client error 413: body is over limit of %d bytes\n", MaxValue), body)
+
+	// success - dry run
+	DryRun = true
+	defer func() { DryRun = false }()
+	r = httptest.NewRequest("PUT", "/0000/dry", strings.NewReader("Dry.\n"))
+	w = mockServe(ptrn, PutValue, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "Created.\n", body)
+	assert.Equal(t, "true", w.Header().Get("X-Dry-Run"))
+
+	// success - check database
+	_, ok, _ = GetPair(DB, "0000", "dry")
+	assert.False(t, ok)
+	DryRun = false
+
+	// failure - private name
+	r = httptest.NewRequest("PUT", "/0000/__secret__", strings.NewReader("Secret.\n"))
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "client error 403: name \"__secret__\" is private\n", body)
+
+	// success - check database
+	_, ok, _ = GetPair(DB, "0000", "__secret__")
+	assert.False(t, ok)
+
+	// setup - require json
+	RequireJSON = true
+	defer func() { RequireJSON = false }()
+
+	// failure - require json, wrong content type
+	r = httptest.NewRequest("PUT", "/0000/doc", strings.NewReader(`{"a": 1}`))
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusUnsupportedMediaType, code)
+	assert.Equal(t, "client error 415: content type must be application/json\n", body)
+
+	// failure - require json, invalid json
+	r = httptest.NewRequest("PUT", "/0000/doc", strings.NewReader(`{"a": 1`))
+	r.Header.Set("Content-Type", "application/json")
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: value is not valid json: unexpected end of JSON input\n", body)
+
+	// success - require json, valid json
+	r = httptest.NewRequest("PUT", "/0000/doc", strings.NewReader(`{"a": 1}`))
+	r.Header.Set("Content-Type", "application/json")
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusCreated, code)
+
+	// success - require json, valid gzip json
+	r = httptest.NewRequest("PUT", "/0000/doc", bytes.NewReader(mockGzip(`{"a": 2}`)))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Encoding", "gzip")
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusOK, code)
+	RequireJSON = false
+
+	// failure - read-only
+	ReadOnly = true
+	defer func() { ReadOnly = false }()
+	want, _ := os.ReadFile(DB.Path())
+	r = httptest.NewRequest("PUT", "/0000/test", strings.NewReader("Test 2.\n"))
+	code, body = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+	assert.Equal(t, "client error 405: server is read-only\n", body)
+
+	// success - check database
+	data, _ := os.ReadFile(DB.Path())
+	assert.Equal(t, want, data)
+	ReadOnly = false
+
+	// failure - database error
+	DB.Close()
+	r = httptest.NewRequest("PUT", "/0000/test", strings.NewReader("Test.\n"))
+	code, _ = getResponse(mockServe(ptrn, PutValue, r))
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////
+//                      part seven · server middleware functions                     //
+///////////////////////////////////////////////////////////////////////////////////////
+
+func TestStoresClose(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "test.db"), nil, 0600)
+	stores := &Stores{Dir: dir}
+	db, _, _ := stores.Open("test")
+
+	// success
+	err := stores.Close()
+	assert.NoError(t, err)
+	assert.Empty(t, stores.conns)
+	assert.Empty(t, db.Path())
+}
+
+func TestStoresOpen(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "test.db"), nil, 0600)
+	stores := &Stores{Dir: dir}
+	defer stores.Close()
+
+	// success
+	db, ok, err := stores.Open("test")
+	assert.NotNil(t, db)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - cached connection
+	db2, ok, err := stores.Open("test")
+	assert.Same(t, db, db2)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// failure - missing store
+	db, ok, err = stores.Open("nope")
+	assert.Nil(t, db)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// failure - invalid store name
+	db, ok, err = stores.Open("..")
+	assert.Nil(t, db)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+}
+
+func TestCORS(t *testing.T) {
+	// setup
+	hand := CORS(http.HandlerFunc(GetIndex))
+
+	// success - no origin
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	code, _ := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	// success - origin
+	CORSOrigin = "https://example.com"
+	defer func() { CORSOrigin = "" }()
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, body, Version)
+
+	// success - preflight
+	r := httptest.NewRequest("OPTIONS", "/0000/alpha", nil)
+	r.Header.Set("Access-Control-Request-Method", "PUT")
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusNoContent, code)
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), "PUT")
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+	assert.Empty(t, body)
+}
+
+func TestFollowUpstream(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	var meth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		meth = r.Method
+		WriteHTTP(w, http.StatusOK, "Upstream.")
+	}))
+	defer srv.Close()
+	upstream, _ := url.Parse(srv.URL)
+	hand := FollowUpstream(upstream, http.HandlerFunc(GetIndex))
+
+	// success - reads served locally
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "/0000/alpha", nil))
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "Gesedels")
+	assert.Empty(t, meth)
+
+	// success - writes proxied
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("PUT", "/0000/alpha", strings.NewReader("Test.")))
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Upstream.\n", body)
+	assert.Equal(t, "PUT", meth)
+
+	// success - written pair deleted locally
+	_, ok, _ := GetPair(DB, "0000", "alpha")
+	assert.False(t, ok)
+	_, ok, _ = GetPair(DB, "0000", "bravo")
+	assert.True(t, ok)
+
+	// success - written user deleted locally
+	hand.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/0000", nil))
+	_, ok, _ = GetPair(DB, "0000", "bravo")
+	assert.False(t, ok)
+
+	// success - written tenant pair deleted locally
+	HostRouting = true
+	defer func() { HostRouting = false }()
+	SetPair(DB, "acme.0000", "alpha", "Acme.")
+	SetPair(DB, "0000", "alpha", "Alpha.")
+	r := httptest.NewRequest("PUT", "/0000/alpha", strings.NewReader("Test."))
+	r.Host = "acme.gesedels.example"
+	hand.ServeHTTP(httptest.NewRecorder(), r)
+	_, ok, _ = GetPair(DB, "acme.0000", "alpha")
+	assert.False(t, ok)
+	_, ok, _ = GetPair(DB, "0000", "alpha")
+	assert.True(t, ok)
+}
+
+func TestHoldReload(t *testing.T) {
+	// setup
+	var locked, released bool
+	hand := HoldReload(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locked = !reloadMutex.TryLock()
+		releaseReload(r)
+		released = reloadMutex.TryLock()
+	}))
+
+	// success
+	hand.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.True(t, locked)
+	assert.True(t, released)
+	reloadMutex.Unlock()
+	assert.True(t, reloadMutex.TryLock())
+	reloadMutex.Unlock()
+
+	// setup - stores
+	dir := t.TempDir()
+	db, _ := OpenDB(filepath.Join(dir, "test.db"))
+	SetPair(db, "0000", "alpha", "Alpha.\n")
+	db.Close()
+	stores := &Stores{Dir: dir}
+	defer stores.Close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{user}/{name}/watch", GetWatch)
+	mux.HandleFunc("GET /_export", func(w http.ResponseWriter, r *http.Request) {
+		defer holdStream(r)()
+		if locked = !reloadMutex.TryLock(); !locked {
+			reloadMutex.Unlock()
+		}
+
+		released = !streamMutex.TryLock()
+	})
+
+	hand = HoldReload(RouteStores(stores, mux))
+
+	// success - store-prefixed stream holds streamMutex
+	hand.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test/_export", nil))
+	assert.False(t, locked)
+	assert.True(t, released)
+	assert.True(t, streamMutex.TryLock())
+	streamMutex.Unlock()
+
+	// success - store-prefixed watch not held while waiting
+	defer func(dura time.Duration) { WatchTimeout = dura }(WatchTimeout)
+	WatchTimeout = 200 * time.Millisecond
+	done := make(chan int, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/test/0000/a%2Fb/watch", nil)
+		hand.ServeHTTP(w, r)
+		done <- w.Code
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, reloadMutex.TryLock())
+	reloadMutex.Unlock()
+	assert.Equal(t, http.StatusNotModified, <-done)
+}
+
+func TestLogRequests(t *testing.T) {
+	// setup
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer Requests.Store(0)
+	defer MethodRequests["GET"].Store(0)
+	hand := LogRequests(http.HandlerFunc(GetIndex))
+
+	// success
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	code, _ := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, int64(1), Requests.Load())
+	assert.Equal(t, int64(1), MethodRequests["GET"].Load())
+
+	// success - metrics requests not counted
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, int64(1), Requests.Load())
+
+	// success - request ID logged
+	buff := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buff, nil)))
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), ctxKey("id"), "abcd"))
+	hand.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Contains(t, buff.String(), "level=INFO msg=request method=GET path=/ status=200")
+	assert.True(t, strings.HasSuffix(buff.String(), " id=abcd\n"))
+
+	// success - client errors logged as warnings
+	buff.Reset()
+	hand = LogRequests(http.NotFoundHandler())
+	hand.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Contains(t, buff.String(), "level=WARN msg=request")
+}
+
+func TestNegotiate(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	hand := Negotiate(http.HandlerFunc(GetValue))
+
+	// success - plaintext
+	r := httptest.NewRequest("GET", "/0000/alpha", nil)
+	r.SetPathValue("user", "0000")
+	r.SetPathValue("name", "alpha")
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, r)
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Alpha.\n", body)
+
+	// success - json
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"value":"Alpha."}`+"\n", body)
+}
+
+func TestRateLimit(t *testing.T) {
+	// setup
+	hand := RateLimit(2, http.HandlerFunc(GetIndex))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "1.2.3.4:5678"
+
+	// success
+	for range 2 {
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, r)
+		code, _ := getResponse(w)
+		assert.Equal(t, http.StatusOK, code)
+	}
+
+	// success - separate client
+	r2 := httptest.NewRequest("GET", "/", nil)
+	r2.RemoteAddr = "5.6.7.8:5678"
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, r2)
+	code, _ := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+
+	// failure - limit exceeded
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, r)
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, "client error 429: too many requests\n", body)
+}
+
+func TestRequestDB(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	db := mockDB(t)
+
+	// success - global database
+	r := httptest.NewRequest("GET", "/", nil)
+	assert.Same(t, DB, RequestDB(r))
+
+	// success - request database
+	r = r.WithContext(context.WithValue(r.Context(), ctxKey("db"), db))
+	assert.Same(t, db, RequestDB(r))
+}
+
+func TestRequestID(t *testing.T) {
+	// success
+	ctx := context.WithValue(context.Background(), ctxKey("id"), "abcd")
+	assert.Equal(t, "abcd", RequestID(ctx))
+
+	// success - no ID
+	assert.Empty(t, RequestID(context.Background()))
+}
+
+func TestRejectTenants(t *testing.T) {
+	// setup
+	hand := RejectTenants(GetIndex)
+	r := httptest.NewRequest("GET", "/_export", nil)
+	r.Host = "acme.gesedels.example"
+
+	// success - host routing off
+	w := httptest.NewRecorder()
+	hand(w, r)
+	code, _ := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+
+	// failure - tenant host
+	HostRouting = true
+	defer func() { HostRouting = false }()
+	w = httptest.NewRecorder()
+	hand(w, r)
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "client error 403: endpoint is not available to tenant hosts\n", body)
+
+	// success - host without tenant
+	r.Host = "localhost:8080"
+	w = httptest.NewRecorder()
+	hand(w, r)
+	code, _ = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestRequireAuth(t *testing.T) {
+	// setup
+	hand := RequireAuth(GetIndex)
+
+	// success - no token
+	r := httptest.NewRequest("PUT", "/", nil)
+	w := httptest.NewRecorder()
+	hand(w, r)
+	code, _ := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+
+	// success - correct credentials
+	Token = "token"
+	defer func() { Token = "" }()
+	r = httptest.NewRequest("PUT", "/", nil)
+	r.SetBasicAuth("user", "token")
+	w = httptest.NewRecorder()
+	hand(w, r)
+	code, _ = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+
+	// failure - missing credentials
+	r = httptest.NewRequest("PUT", "/", nil)
+	w = httptest.NewRecorder()
+	hand(w, r)
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, `Basic realm="gesedels"`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, "client error 401: invalid credentials\n", body)
+
+	// failure - wrong credentials
+	r.SetBasicAuth("user", "nope")
+	w = httptest.NewRecorder()
+	hand(w, r)
+	code, _ = getResponse(w)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestRouteStores(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	db, _ := OpenDB(filepath.Join(dir, "test.db"))
+	SetPair(db, "0000", "alpha", "Alpha.\n")
+	db.Close()
+	stores := &Stores{Dir: dir}
+	defer stores.Close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{user}/{name}", GetValue)
+	hand := RouteStores(stores, mux)
+
+	// success
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "/test/0000/alpha", nil))
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Alpha.\n", body)
+
+	// failure - missing store
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "/nope/0000/alpha", nil))
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "client error 404: store \"nope\" does not exist\n", body)
+}
+
+func TestRouteTenants(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	SetPair(DB, "acme.0000", "alpha", "Acme.\n")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{user}/{name}", RouteTenants(GetValue))
+
+	// success - host routing off
+	r := httptest.NewRequest("GET", "/0000/alpha", nil)
+	r.Host = "acme.gesedels.example"
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	code, body := getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Alpha.\n", body)
+
+	// success - host routing on
+	HostRouting = true
+	defer func() { HostRouting = false }()
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Acme.\n", body)
+
+	// failure - host without tenant
+	r = httptest.NewRequest("GET", "/acme.0000/alpha", nil)
+	r.Host = "localhost:8080"
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "client error 400: host \"localhost:8080\" has no tenant\n", body)
+
+	// success - references scoped to tenant
+	SetPair(DB, "acme.0000", "ref", "${0000:alpha}")
+	r = httptest.NewRequest("GET", "/0000/ref?expand=true", nil)
+	r.Host = "acme.gesedels.example"
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	code, body = getResponse(w)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Acme.\n", body)
+}
+
+func TestTraceRequests(t *testing.T) {
+	// setup
+	var id string
+	hand := TraceRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = RequestID(r.Context())
+	}))
+
+	// success - generated ID
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.NotEmpty(t, id)
+	assert.Equal(t, id, w.Header().Get("X-Request-ID"))
+
+	// success - honored ID
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-ID", "abcd-1234")
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, r)
+	assert.Equal(t, "abcd-1234", id)
+	assert.Equal(t, "abcd-1234", w.Header().Get("X-Request-ID"))
+
+	// success - invalid ID replaced
+	r.Header.Set("X-Request-ID", "bad id\n")
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, r)
+	assert.NotEqual(t, "bad id\n", id)
+	assert.Equal(t, id, w.Header().Get("X-Request-ID"))
+}
+
+func TestTrimSlash(t *testing.T) {
+	// setup
+	var path, raw string
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, raw = r.URL.Path, r.URL.EscapedPath()
+	})
+
+	// success - canonical path
+	TrimSlash(false, inner).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/0000/alpha", nil))
+	assert.Equal(t, "/0000/alpha", path)
+
+	// success - root path
+	TrimSlash(false, inner).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "/", path)
+
+	// success - trailing slash trimmed
+	TrimSlash(false, inner).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/0000/alpha/", nil))
+	assert.Equal(t, "/0000/alpha", path)
+
+	// success - escaped path trimmed
+	TrimSlash(false, inner).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/0000/a%2Fb/", nil))
+	assert.Equal(t, "/0000/a/b", path)
+	assert.Equal(t, "/0000/a%2Fb", raw)
+
+	// success - trailing slash redirected
+	path = ""
+	w := httptest.NewRecorder()
+	TrimSlash(true, inner).ServeHTTP(w, httptest.NewRequest("GET", "/0000/a%2Fb/?expand=true", nil))
+	assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+	assert.Equal(t, "/0000/a%2Fb?expand=true", w.Header().Get("Location"))
+	assert.Empty(t, path)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////
+//                        part eight · main runtime functions                        //
+///////////////////////////////////////////////////////////////////////////////////////
+
+func TestApplyConfig(t *testing.T) {
+	// setup
+	fset := flag.NewFlagSet("test", flag.ContinueOnError)
+	addr := fset.String("addr", "default", "")
+	path := fset.String("path", "default", "")
+	bucket := fset.String("bucket", "default", "")
+	token := fset.String("token", "", "")
+	maxValue := fset.Int("max-value", 100, "")
+	readOnly := fset.Bool("read-only", false, "")
+	fset.Parse([]string{"--addr", "flag", "--max-value", "200"})
+	conf := Config{Addr: "file", Path: "file", MaxValue: 300, ReadOnly: true}
+
+	// success
+	err := ApplyConfig(fset, conf)
+	assert.NoError(t, err)
+	assert.Equal(t, "flag", *addr)
+	assert.Equal(t, "file", *path)
+	assert.Equal(t, "default", *bucket)
+	assert.Equal(t, "", *token)
+	assert.Equal(t, 200, *maxValue)
+	assert.True(t, *readOnly)
+}
+
+func TestListen(t *testing.T) {
+	// setup
+	cert, key := mockCert(t)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(GetIndex))
+	defer srv.Close()
+
+	// success - plaintext
+	lis, err := Listen("127.0.0.1:0", "", "")
+	assert.NoError(t, err)
+	lis.Close()
+
+	// success - connection limit
+	MaxConns = 2
+	defer func() { MaxConns = 0 }()
+	lis, err = Listen("127.0.0.1:0", "", "")
+	assert.NoError(t, err)
+	conns := make(chan net.Conn, 3)
+	go func(lis net.Listener) {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+
+			conns <- conn
+		}
+	}(lis)
+
+	for range 3 {
+		dial, _ := net.Dial("tcp", lis.Addr().String())
+		defer dial.Close()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, conns, 2)
+	(<-conns).Close()
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, conns, 2)
+	lis.Close()
+	MaxConns = 0
+
+	// success - tls
+	lis, err = Listen("127.0.0.1:0", cert, key)
+	assert.NoError(t, err)
+	srv.Listener.Close()
+	srv.Listener = lis
+	srv.Start()
+	clnt := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+
+	rslt, err := clnt.Get("https://" + lis.Addr().String())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rslt.StatusCode)
+	rslt.Body.Close()
+
+	// success - unix socket
+	dir, _ := os.MkdirTemp("", "gesedels-")
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "test.sock")
+	lis, err = Listen("unix:"+sock, "", "")
+	assert.NoError(t, err)
+	info, _ := os.Stat(sock)
+	assert.Equal(t, SocketMode, info.Mode().Perm())
+
+	// success - stale socket replaced
+	lis.(*net.UnixListener).SetUnlinkOnClose(false)
+	lis.Close()
+	lis, err = Listen("unix:"+sock, "", "")
+	assert.NoError(t, err)
+
+	// success - socket removed on close
+	lis.Close()
+	_, err = os.Stat(sock)
+	assert.True(t, os.IsNotExist(err))
+
+	// failure - missing key
+	_, err = Listen("127.0.0.1:0", cert, "")
+	assert.EqualError(t, err, "tls cert and key must be set together")
+
+	// failure - invalid cert
+	_, err = Listen("127.0.0.1:0", key, key)
+	assert.Error(t, err)
+}
+
+func TestLoadConfig(t *testing.T) {
+	// setup
+	dest := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(dest, []byte(`{"addr": "127.0.0.1:9090", "max_value": 100}`), 0600)
+
+	// success
+	conf, err := LoadConfig(dest)
+	assert.Equal(t, Config{Addr: "127.0.0.1:9090", MaxValue: 100}, conf)
+	assert.NoError(t, err)
+
+	// failure - unknown field
+	os.WriteFile(dest, []byte(`{"nope": true}`), 0600)
+	_, err = LoadConfig(dest)
+	assert.ErrorContains(t, err, "unknown field")
+
+	// failure - missing file
+	_, err = LoadConfig(filepath.Join(t.TempDir(), "nope.json"))
+	assert.Error(t, err)
+}
+
+func TestNewLogger(t *testing.T) {
+	// success - text
+	buff := new(bytes.Buffer)
+	logger, err := NewLogger(buff, "warn", "text")
+	assert.NoError(t, err)
+	logger.Info("hidden")
+	logger.Warn("shown", "key", "val")
+	assert.NotContains(t, buff.String(), "hidden")
+	assert.Contains(t, buff.String(), "level=WARN msg=shown key=val")
+
+	// success - json
+	buff.Reset()
+	logger, err = NewLogger(buff, "debug", "json")
+	assert.NoError(t, err)
+	logger.Debug("shown")
+	assert.Contains(t, buff.String(), `"level":"DEBUG","msg":"shown"`)
+
+	// failure - invalid level
+	_, err = NewLogger(buff, "loud", "text")
+	assert.EqualError(t, err, `invalid log level "loud"`)
+
+	// failure - invalid format
+	_, err = NewLogger(buff, "info", "xml")
+	assert.EqualError(t, err, `invalid log format "xml"`)
+}
+
+func TestNewMux(t *testing.T) {
+	// setup
+	mux := NewMux()
+	var doc struct {
+		Paths map[string]map[string]any `json:"paths"`
+	}
+
+	json.Unmarshal(OpenAPI, &doc)
+	repl := strings.NewReplacer("{user}", "0000", "{name}", "alpha")
+
+	// success - documented routes registered
+	for path, ops := range doc.Paths {
+		for meth := range ops {
+			if meth == "parameters" || path == "/" {
+				continue
+			}
+
+			r := httptest.NewRequest(strings.ToUpper(meth), repl.Replace(path), nil)
+			_, ptrn := mux.Handler(r)
+			assert.NotEqual(t, "GET /", ptrn, "%s %s", meth, path)
+			assert.NotEmpty(t, ptrn, "%s %s", meth, path)
+		}
+	}
+
+	// success - registered routes documented
+	code, _ := os.ReadFile("gesedels.go")
+	rexp := regexp.MustCompile(`mux\.HandleFunc\("(\w+) ([^"]+)"`)
+	for _, elems := range rexp.FindAllStringSubmatch(string(code), -1) {
+		meth, path := strings.ToLower(elems[1]), elems[2]
+		assert.Contains(t, doc.Paths[path], meth, "%s %s", elems[1], path)
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	// setup
+	hand := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})
+
+	// success
+	srv := NewServer("127.0.0.1:0", hand, false)
+	assert.Equal(t, "127.0.0.1:0", srv.Addr)
+	assert.Equal(t, 10*time.Second, srv.ReadTimeout)
+	assert.Equal(t, 10*time.Second, srv.WriteTimeout)
+	assert.Equal(t, 60*time.Second, srv.IdleTimeout)
+	assert.Equal(t, http.DefaultMaxHeaderBytes, srv.MaxHeaderBytes)
+	assert.Nil(t, srv.Protocols)
+
+	// success - custom timeouts
+	ReadTimeout, WriteTimeout, IdleTimeout = time.Second, 2*time.Second, 3*time.Second
+	defer func() {
+		ReadTimeout, WriteTimeout, IdleTimeout = 10*time.Second, 10*time.Second, 60*time.Second
+	}()
+
+	srv = NewServer("127.0.0.1:0", hand, false)
+	assert.Equal(t, time.Second, srv.ReadTimeout)
+	assert.Equal(t, 2*time.Second, srv.WriteTimeout)
+	assert.Equal(t, 3*time.Second, srv.IdleTimeout)
+
+	// success - oversized headers rejected
+	defer func(size int) { MaxHeader = size }(MaxHeader)
+	MaxHeader = 1024
+	srv = NewServer("127.0.0.1:0", hand, false)
+	assert.Equal(t, 1024, srv.MaxHeaderBytes)
+	hlis, _ := net.Listen("tcp", "127.0.0.1:0")
+	go srv.Serve(hlis)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", "http://"+hlis.Addr().String(), nil)
+	req.Header.Set("X-Large", strings.Repeat("a", 8192))
+	rslt, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	rslt.Body.Close()
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, rslt.StatusCode)
+
+	// success - h2c
+	srv = NewServer("127.0.0.1:0", hand, true)
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	go srv.Serve(lis)
+	defer srv.Close()
+
+	for h2c, want := range map[bool]string{false: "HTTP/1.1", true: "HTTP/2.0"} {
+		prts := new(http.Protocols)
+		prts.SetHTTP1(!h2c)
+		prts.SetUnencryptedHTTP2(h2c)
+		clnt := &http.Client{Transport: &http.Transport{Protocols: prts}}
+		rslt, err := clnt.Get("http://" + lis.Addr().String())
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(rslt.Body)
+		rslt.Body.Close()
+		assert.Equal(t, want, string(body))
+	}
+
+	// success - h2c upgrade
+	conn, _ := net.Dial("tcp", lis.Addr().String())
+	defer conn.Close()
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\n"+
+		"Upgrade: h2c\r\nHTTP2-Settings: AAMAAABkAARAAAAAAAIAAAAA\r\n\r\n")
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", line)
+}
+
+func TestReload(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.json")
+	os.WriteFile(config, []byte(`{"addr": "file", "token": "file", "max_value": 300}`), 0644)
+	fset := flag.NewFlagSet("test", flag.ContinueOnError)
+	fset.String("addr", "flag", "")
+	fset.String("path", filepath.Join(dir, "test.db"), "")
+	fset.String("bucket", "main", "")
+	fset.String("api-version", "", "")
+	fset.String("token", "", "")
+	fset.Int("max-value", 100, "")
+	fset.Bool("read-only", false, "")
+	fset.Parse([]string{"--max-value", "200"})
+	cli := map[string]bool{"max-value": true}
+	DB, _ = OpenDB(filepath.Join(dir, "test.db"))
+	prev := DB
+	defer func(addr string, size int) {
+		stopDB(DB)
+		Addr, Token, MaxValue = addr, "", size
+	}(Addr, MaxValue)
+	Addr = "flag"
+
+	// success
+	err := Reload(fset, cli, config, &Stores{})
+	assert.NoError(t, err)
+	assert.Equal(t, "file", Token)
+	assert.Equal(t, 200, MaxValue)
+	assert.False(t, ReadOnly)
+	assert.Equal(t, "flag", Addr)
+	assert.NotEqual(t, prev, DB)
+	assert.Equal(t, filepath.Join(dir, "test.db"), DB.Path())
+
+	// failure - missing config file
+	err = Reload(fset, cli, config+".nope", &Stores{})
+	assert.Error(t, err)
+}
+
+func TestRunBackup(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	path := DB.Path()
+	DB.Close()
+	dest := filepath.Join(t.TempDir(), "backup.db")
+
+	// success
+	ok, err := RunBackup([]string{"--path", path, dest}, nil, nil)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - check backup
+	back, _ := OpenDB(dest)
+	pval, _, _ := GetPair(back, "0000", "alpha")
+	assert.Equal(t, "Alpha.\n", pval)
+	back.Close()
+
+	// failure - wrong arguments
+	_, err = RunBackup([]string{"--path", path}, nil, nil)
+	assert.EqualError(t, err, "backup requires 1 argument")
+
+	// failure - missing database
+	_, err = RunBackup([]string{"--path", path + ".nope", dest}, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestRunCompact(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	mockFree(DB)
+	path := DB.Path()
+	DB.Close()
+	dest := filepath.Join(t.TempDir(), "compact.db")
+	w := new(bytes.Buffer)
+
+	// success
+	ok, err := RunCompact([]string{path, dest}, nil, w)
+	assert.Regexp(t, `^Compacted \d+ bytes to \d+ bytes\.\n$`, w.String())
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// failure - wrong arguments
+	_, err = RunCompact([]string{path}, nil, w)
+	assert.EqualError(t, err, "compact requires 2 arguments")
+
+	// failure - destination exists
+	_, err = RunCompact([]string{path, dest}, nil, w)
+	assert.EqualError(t, err, fmt.Sprintf("destination %q already exists", dest))
+
+	// failure - missing database
+	_, err = RunCompact([]string{path + ".nope", dest + ".new"}, nil, w)
+	assert.Error(t, err)
+}
+
+func TestRunDelete(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	path := DB.Path()
+	DB.Close()
+	w := new(bytes.Buffer)
+
+	// success
+	ok, err := RunDelete([]string{"--path", path, "0000", "alpha"}, nil, w)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - pair does not exist
+	ok, err = RunDelete([]string{"--path", path, "0000", "alpha"}, nil, w)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	// failure - wrong arguments
+	_, err = RunDelete([]string{"--path", path, "0000"}, nil, w)
+	assert.EqualError(t, err, "delete requires 2 arguments")
+}
+
+func TestRunDump(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	path := DB.Path()
+	DB.Close()
+
+	// success
+	w := new(bytes.Buffer)
+	ok, err := RunDump([]string{path}, nil, w)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"user":"0000","name":"alpha","value":"Alpha.\n"}`+"\n"+
+		`{"user":"0000","name":"bravo","value":"Bravo.\n"}`+"\n", w.String())
+
+	// failure - wrong arguments
+	_, err = RunDump(nil, nil, w)
+	assert.EqualError(t, err, "dump requires 1 argument")
+}
+
+func TestRunGet(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	path := DB.Path()
+	DB.Close()
+
+	// success
+	w := new(bytes.Buffer)
+	ok, err := RunGet([]string{"--path", path, "0000", "alpha"}, nil, w)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, "Alpha.\n", w.String())
+
+	// success - pair does not exist
+	w.Reset()
+	ok, err = RunGet([]string{"--path", path, "0000", "nope"}, nil, w)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+	assert.Empty(t, w.String())
+
+	// failure - invalid name
+	_, err = RunGet([]string{"--path", path, "0000", "x:admin"}, nil, w)
+	assert.EqualError(t, err, `invalid name "x:admin"`)
+
+	// failure - missing database
+	_, err = RunGet([]string{"--path", path + ".nope", "0000", "alpha"}, nil, w)
+	assert.Error(t, err)
+}
+
+func TestRunLoad(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	path := DB.Path()
+	DB.Close()
+	r := strings.NewReader(`{"user":"0000","name":"test","value":"Test.\n"}` + "\nnope\n")
+
+	// success
+	w := new(bytes.Buffer)
+	ok, err := RunLoad([]string{path}, r, w)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, "imported 1, skipped 1\n", w.String())
+
+	// success - check database
+	w.Reset()
+	RunGet([]string{"--path", path, "0000", "test"}, nil, w)
+	assert.Equal(t, "Test.\n", w.String())
+
+	// failure - wrong arguments
+	_, err = RunLoad(nil, r, w)
+	assert.EqualError(t, err, "load requires 1 argument")
+}
+
+func TestRunRepl(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	path := DB.Path()
+	DB.Close()
+
+	// success
+	w := new(bytes.Buffer)
+	r := strings.NewReader("get 0000 alpha\nset 0000 charlie Charlie and more.\n" +
+		"list 0000\n\ndel 0000 bravo\nexit\nget 0000 alpha\n")
+	ok, err := RunRepl([]string{path}, r, w)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, "> Alpha.\n> ok\n> alpha\nbravo\ncharlie\n> > ok\n> ", w.String())
+
+	// success - invalid commands
+	w.Reset()
+	r = strings.NewReader("nope\nget 0000\nget 0000 x:admin\nget 0000 nope\ndel 0000 nope")
+	ok, err = RunRepl([]string{path}, r, w)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, `> unknown command "nope"`+"\n> usage: get user name\n"+
+		`> invalid name "x:admin"`+"\n> pair 0000/nope does not exist\n"+
+		"> pair 0000/nope does not exist\n> ", w.String())
+
+	// success - read-only database
+	w.Reset()
+	r = strings.NewReader("get 0000 charlie\nset 0000 alpha Alpha.\ndel 0000 alpha\n")
+	ok, err = RunRepl([]string{"--read-only", path}, r, w)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, "> Charlie and more.\n> set is disabled for a read-only database\n"+
+		"> del is disabled for a read-only database\n> ", w.String())
+
+	// failure - wrong arguments
+	_, err = RunRepl(nil, nil, w)
+	assert.EqualError(t, err, "repl requires 1 argument")
+}
+
+func TestRunSelftest(t *testing.T) {
+	// setup
+	w := new(bytes.Buffer)
+
+	// success
+	ok, err := RunSelftest(nil, nil, w)
+	assert.Equal(t, "set: ok\nget: ok\ndelete: ok\nverify: ok\n", w.String())
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// failure - wrong arguments
+	_, err = RunSelftest([]string{"nope"}, nil, w)
+	assert.EqualError(t, err, "selftest requires 0 arguments")
+
+	// failure - step failed
+	w.Reset()
+	defer func(size int) { MaxValue = size }(MaxValue)
+	MaxValue = 1
+	ok, err = RunSelftest(nil, nil, w)
+	assert.Equal(t, "set: failed: value is too large: 11 bytes is over limit of 1\n", w.String())
+	assert.False(t, ok)
+	assert.EqualError(t, err, "selftest set failed: value is too large: 11 bytes is over limit of 1")
+}
+
+func TestRunSet(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	path := DB.Path()
+	DB.Close()
+	w := new(bytes.Buffer)
+
+	// success
+	ok, err := RunSet([]string{"--path", path, "0000", "test", "Test."}, nil, w)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// success - check database
+	w.Reset()
+	RunGet([]string{"--path", path, "0000", "test"}, nil, w)
+	assert.Equal(t, "Test.\n", w.String())
+
+	// success - flat pairs moved into user buckets
+	db, _ := bbolt.Open(path, 0666, nil)
+	db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(Bucket).Put([]byte("1111:flat"), []byte("Flat.\n"))
+	})
+	db.Close()
+	RunSet([]string{"--path", path, "0000", "test", "Test 2."}, nil, w)
+	w.Reset()
+	RunGet([]string{"--path", path, "1111", "flat"}, nil, w)
+	assert.Equal(t, "Flat.\n", w.String())
+
+	// success - value index rebuilt after write
+	defer func(flag bool) { IndexValues = flag }(IndexValues)
+	IndexValues = true
+	db, _ = OpenDB(path)
+	SyncIndex(db)
+	db.Close()
+	IndexValues = false
+	RunSet([]string{"--path", path, "0000", "index", "Index."}, nil, w)
+	IndexValues = true
+	db, _ = OpenDB(path)
+	SyncIndex(db)
+	recs, _ := FindPairs(db, "Index", 0)
+	assert.Equal(t, []Record{{User: "0000", Name: "index"}}, recs)
+	db.Close()
+
+	// failure - wrong arguments
+	_, err = RunSet([]string{"--path", path, "0000", "test"}, nil, w)
+	assert.EqualError(t, err, "set requires 3 arguments")
+}
+
+func TestServe(t *testing.T) {
+	// setup
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := lis.Addr().String()
+	srv := &http.Server{Handler: http.HandlerFunc(GetIndex)}
+	sigs := make(chan os.Signal, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- Serve(srv, lis, sigs)
+	}()
+
+	// success - server running
+	rslt, err := http.Get("http://" + addr)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rslt.StatusCode)
+	rslt.Body.Close()
+
+	// success - server shut down
+	sigs <- os.Interrupt
+	assert.NoError(t, <-errs)
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	// setup
+	DB = mockDB(t)
+	path := DB.Path()
+	DB.Close()
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer lis.Close()
+
+	// success - subcommand
+	err := run([]string{"delete", "--path", path, "0000", "alpha"})
+	assert.NoError(t, err)
+
+	// failure - subcommand on missing pair
+	err = run([]string{"delete", "--path", path, "0000", "alpha"})
+	assert.Equal(t, ErrNotFound, err)
+
+	// failure - invalid socket mode
+	err = run([]string{"serve", "--socket-mode", "nope"})
+	assert.EqualError(t, err, `invalid socket mode "nope"`)
+
+	// failure - invalid backup interval
+	err = run([]string{"--backup-dir", t.TempDir(), "--backup-interval", "0s"})
+	assert.EqualError(t, err, "backup interval must be positive")
+
+	// failure - invalid max header
+	err = run([]string{"--max-header", "0"})
+	assert.EqualError(t, err, "max header must be positive")
+
+	// failure - invalid sweep interval
+	err = run([]string{"--sweep-interval", "-1s"})
+	assert.EqualError(t, err, "sweep interval cannot be negative")
+
+	// failure - invalid key separator
+	err = run([]string{"--key-sep", `\x`})
+	assert.EqualError(t, err, `invalid key separator "\\x"`)
+
+	// failure - invalid api version
+	err = run([]string{"--api-version", "v1:main"})
+	assert.EqualError(t, err, `invalid api version "v1:main"`)
+
+	// failure - invalid webhook
+	err = run([]string{"--webhook", "nope"})
+	assert.EqualError(t, err, `invalid webhook url "nope"`)
+
+	// failure - invalid upstream
+	err = run([]string{"--upstream", "nope"})
+	assert.EqualError(t, err, `invalid upstream url "nope"`)
+
+	// failure - upstream with read-only
+	err = run([]string{"--upstream", "http://127.0.0.1:1", "--read-only"})
+	assert.EqualError(t, err, "upstream cannot be used with dir or read-only")
+
+	// failure - invalid log level
+	err = run([]string{"--log-level", "loud"})
+	assert.EqualError(t, err, `invalid log level "loud"`)
+
+	// failure - missing config file
+	err = run([]string{"--config", path + ".nope"})
+	assert.Error(t, err)
+
+	// failure - invalid database path
+	err = run([]string{"--path", filepath.Join(path, "nope.db"), "--addr", "127.0.0.1:0"})
+	assert.Error(t, err)
+
+	// failure - seed with read-only
+	err = run([]string{"--seed", path + ".json", "--read-only"})
+	assert.EqualError(t, err, "seed cannot be used with dir or read-only")
+
+	// failure - missing seed file
+	err = run([]string{"--path", path, "--addr", "127.0.0.1:0", "--seed", path + ".nope"})
+	assert.Error(t, err)
+
+	// failure - address in use
+	seed := filepath.Join(t.TempDir(), "seed.json")
+	os.WriteFile(seed, []byte(`{"user":"0000","name":"bravo","value":"Seed."}`+"\n"+
+		`{"user":"0000","name":"seed","value":"Seed."}`+"\n"), 0666)
+	err = run([]string{"--path", path, "--addr", lis.Addr().String(), "--seed", seed})
+	assert.ErrorContains(t, err, "address already in use")
+
+	// success - database closed on failure
+	db, err := commandDB(path, false)
+	assert.NoError(t, err)
+
+	// success - database seeded before failure
+	pval, _, _ := GetPair(db, "0000", "bravo")
+	assert.Equal(t, "Bravo.\n", pval)
+	pval, _, _ = GetPair(db, "0000", "seed")
+	assert.Equal(t, "Seed.\n", pval)
+	db.Close()
 }